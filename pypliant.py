@@ -17,8 +17,8 @@ class Pliant(object):
     return self._call("AtomicClient.GetLocalPath", [path])
   def put(self, local, path):
     return self._call("AtomicClient.PutLocalPath", [{"LocalPath": local, "DestPath": path}])
-  def mkdir(self, path):
-    return self._call("AtomicClient.MakeDir", [path])
+  def mkdir(self, path, parents=False):
+    return self._call("AtomicClient.MakeDir", [{"Path": path, "Parents": parents}])
   def listdir(self, path):
     files = self._call("AtomicClient.ListFiles", [path])
     return files
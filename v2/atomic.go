@@ -2,8 +2,10 @@ package v2
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
+	"io"
+	"log"
+	"os"
 	"path/filepath"
 	"sort"
 	"sync"
@@ -13,7 +15,11 @@ import (
 	"github.com/golang/protobuf/proto"
 )
 
-var NO_SUCH_PATH = errors.New("No such path")
+var NO_SUCH_PATH error = NewError(ERR_NOT_FOUND, "No such path")
+var NO_SUCH_TAG error = NewError(ERR_NOT_FOUND, "No such tag")
+var TAG_CHANGED error = NewError(ERR_CONFLICT, "Tag does not have the expected key")
+var NOT_A_DIRECTORY error = NewError(ERR_NOT_DIR, "Not a directory")
+var PATH_EXISTS error = NewError(ERR_EXISTS, "Path already exists")
 
 type Atomic interface {
 	// This interface connects paths which appear mutable with
@@ -24,16 +30,30 @@ type Atomic interface {
 
 	Put(destination *Path, resource Resource) (*Key, error)
 	GetResource(key *Key) Resource
+	// like GetResource, but also handles files whose content is stored inline in their metadata
+	GetFileResource(metadata *FileMetadata) Resource
 
 	Link(key *Key, path *Path, isDir bool) error
 	Unlink(path *Path) error
+	// applies several Link/Unlink/Put/MakeDir operations together
+	Batch(ops []*BatchOp) error
 
 	CreateResourceForLocalFile(localFile string) (Resource, error)
 
 	Pull(tag string, lease *Lease) (*Key, error)
 	Push(key *Key, new_tag string, lease *Lease) error
+	// like Push, but only updates the tag if it still points to expected
+	PushIfUnchanged(key *Key, tag string, expected *Key, lease *Lease) error
+	DeleteTag(tag string) error
 
 	ForEachRoot(prefix string, callback func(name string, key *Key)) error
+
+	WatchRoots(prefix string, sinceSeq uint64, timeout time.Duration) ([]TagChange, uint64, error)
+
+	Diff(a *Key, b *Key) ([]DiffEntry, error)
+	Merge(base *Key, ours *Key, theirs *Key) (*Key, []MergeConflict, error)
+
+	GetCompressionStats() CompressionStats
 }
 
 // A wrapper around Atomic which uses simple types for its parameters.
@@ -68,11 +88,18 @@ func (ac *AtomicClient) Pull(args *PullArgs, result *string) error {
 	if err != nil {
 		return err
 	}
+	if key == nil {
+		return NO_SUCH_TAG
+	}
 
 	parsedPath := NewPath(args.Destination)
 	return ac.atomic.Link(key, parsedPath, true)
 }
 
+func (ac *AtomicClient) DeleteTag(tag string, result *string) error {
+	return ac.atomic.DeleteTag(tag)
+}
+
 type ListRootsRecord struct {
 	Name string
 	Key  *Key
@@ -91,12 +118,148 @@ func (ac *AtomicClient) ListRoots(prefix string, resultPtr *[]ListRootsRecord) e
 	return nil
 }
 
+type WatchRootsArgs struct {
+	Prefix         string
+	SinceSeq       uint64
+	TimeoutSeconds int
+}
+
+type WatchRootsReply struct {
+	Changes []TagChange
+	Seq     uint64
+}
+
+func (ac *AtomicClient) WatchRoots(args *WatchRootsArgs, reply *WatchRootsReply) error {
+	changes, seq, err := ac.atomic.WatchRoots(args.Prefix, args.SinceSeq, time.Duration(args.TimeoutSeconds)*time.Second)
+	if err != nil {
+		return err
+	}
+
+	reply.Changes = changes
+	reply.Seq = seq
+	return nil
+}
+
+func (ac *AtomicClient) GetCompressionStats(nothing *string, result *CompressionStats) error {
+	*result = ac.atomic.GetCompressionStats()
+	return nil
+}
+
+// returns the key of the directory at path, or if there is no such path, the key of the tag with that name
+func (ac *AtomicClient) resolveKey(pathOrTag string) (*Key, error) {
+	metadata, err := ac.atomic.GetMetadata(NewPath(pathOrTag))
+	if err != nil && err != NO_SUCH_PATH {
+		return nil, err
+	}
+	if metadata != nil {
+		if !metadata.GetIsDir() {
+			return nil, Errorf(ERR_NOT_DIR, "%s is not a directory", pathOrTag)
+		}
+		return KeyFromBytes(metadata.GetKey()), nil
+	}
+
+	key, err := ac.atomic.Pull(pathOrTag, &Lease{})
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, Errorf(ERR_NOT_FOUND, "No path or tag named %s", pathOrTag)
+	}
+	return key, nil
+}
+
+type DiffArgs struct {
+	// each may be either a path or a tag
+	A string
+	B string
+}
+
+func (ac *AtomicClient) Diff(args *DiffArgs, result *[]DiffEntry) error {
+	a, err := ac.resolveKey(args.A)
+	if err != nil {
+		return err
+	}
+	b, err := ac.resolveKey(args.B)
+	if err != nil {
+		return err
+	}
+
+	entries, err := ac.atomic.Diff(a, b)
+	if err != nil {
+		return err
+	}
+	*result = entries
+	return nil
+}
+
+type MergeArgs struct {
+	// each may be either a path or a tag
+	Base   string
+	Ours   string
+	Theirs string
+	// if set, and there were no conflicts, the merged directory is linked here
+	Destination string
+	// if set, and there were no conflicts, the merged directory is pushed to this tag provided the tag
+	// still points to ours
+	Tag string
+}
+
+type MergeResult struct {
+	Key       string
+	Conflicts []MergeConflict
+}
+
+func (ac *AtomicClient) Merge(args *MergeArgs, result *MergeResult) error {
+	base, err := ac.resolveKey(args.Base)
+	if err != nil {
+		return err
+	}
+	ours, err := ac.resolveKey(args.Ours)
+	if err != nil {
+		return err
+	}
+	theirs, err := ac.resolveKey(args.Theirs)
+	if err != nil {
+		return err
+	}
+
+	merged, conflicts, err := ac.atomic.Merge(base, ours, theirs)
+	if err != nil {
+		return err
+	}
+	result.Key = merged.String()
+	result.Conflicts = conflicts
+	if len(conflicts) > 0 {
+		return nil
+	}
+
+	if args.Destination != "" {
+		err = ac.atomic.Link(merged, NewPath(args.Destination), true)
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.Tag != "" {
+		err = ac.atomic.PushIfUnchanged(merged, args.Tag, ours, &Lease{})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 type ListFilesRecord struct {
-	Name         string
-	IsDir        bool
-	Size         int64
-	TotalSize    int64
-	CreationTime int64
+	Name          string
+	IsDir         bool
+	Size          int64
+	TotalSize     int64
+	CreationTime  int64
+	Mode          uint32
+	Mtime         int64
+	IsSymlink     bool
+	SymlinkTarget string
 }
 
 func (ac *AtomicClient) ListFiles(path string, result *[]ListFilesRecord) error {
@@ -110,24 +273,39 @@ func (ac *AtomicClient) ListFiles(path string, result *[]ListFilesRecord) error
 
 	for it.HasNext() {
 		name, metadata := it.Next()
-		records = append(records, ListFilesRecord{Name: name, IsDir: metadata.GetIsDir(), TotalSize: metadata.GetTotalSize(), Size: metadata.GetSize(), CreationTime: metadata.GetCreationTime()})
+		records = append(records, ListFilesRecord{Name: name, IsDir: metadata.GetIsDir(), TotalSize: metadata.GetTotalSize(), Size: metadata.GetSize(), CreationTime: metadata.GetCreationTime(),
+			Mode: metadata.GetMode(), Mtime: metadata.GetMtime(), IsSymlink: metadata.GetIsSymlink(), SymlinkTarget: metadata.GetSymlinkTarget()})
 	}
 
 	*result = records
 	return nil
 }
 
-func (ac *AtomicClient) MakeDir(path string, result *string) error {
-	parsedPath := NewPath(path)
+type MakeDirArgs struct {
+	Path string
+	// if set, missing parent directories are created, and an existing directory at Path is left as it is
+	Parents bool
+}
+
+func (ac *AtomicClient) MakeDir(args *MakeDirArgs, result *string) error {
+	parsedPath := NewPath(args.Path)
+	if args.Parents {
+		return ac.atomic.Batch([]*BatchOp{{Op: BATCH_MKDIR, Path: parsedPath, Parents: true}})
+	}
 	return ac.atomic.Link(EMPTY_DIR_KEY, parsedPath, true)
 }
 
+// The returned key can be passed to Link.  For a file stored inline, this means copying its content into
+// the cache, because otherwise there is no chunk with that key.
 func (ac *AtomicClient) GetKey(path string, key *string) error {
 	parsedPath := NewPath(path)
 	metadata, err := ac.atomic.GetMetadata(parsedPath)
 	if err != nil {
 		return err
 	}
+	if isInline(metadata) {
+		ac.atomic.GetFileResource(metadata)
+	}
 	*key = KeyFromBytes(metadata.GetKey()).String()
 	return nil
 }
@@ -136,19 +314,26 @@ const STAT_ERROR_MISSING = "missing"
 const STAT_ERROR_NONE = ""
 
 type StatResponse struct {
-	Size         int64
-	Key          []byte
-	CreationTime int64
-	IsDir        bool
-	TotalSize    int64
-	Error        string
+	Size          int64
+	Key           []byte
+	CreationTime  int64
+	IsDir         bool
+	TotalSize     int64
+	Mode          uint32
+	Mtime         int64
+	IsSymlink     bool
+	SymlinkTarget string
+	Xattrs        map[string][]byte
+	Error         string
 }
 
 func (ac *AtomicClient) Stat(path string, result *StatResponse) error {
 	parsedPath := NewPath(path)
 	metadata, err := ac.atomic.GetMetadata(parsedPath)
 	fmt.Printf("Stat() = %s, err=%s\n", metadata, err)
-	if err != nil {
+	if err == NO_SUCH_PATH {
+		metadata = nil
+	} else if err != nil {
 		return err
 	}
 
@@ -160,6 +345,14 @@ func (ac *AtomicClient) Stat(path string, result *StatResponse) error {
 		result.CreationTime = metadata.GetCreationTime()
 		result.IsDir = metadata.GetIsDir()
 		result.TotalSize = metadata.GetTotalSize()
+		result.Mode = metadata.GetMode()
+		result.Mtime = metadata.GetMtime()
+		result.IsSymlink = metadata.GetIsSymlink()
+		result.SymlinkTarget = metadata.GetSymlinkTarget()
+		result.Xattrs = make(map[string][]byte)
+		for _, xattr := range metadata.GetXattrs() {
+			result.Xattrs[xattr.GetName()] = xattr.GetValue()
+		}
 		result.Error = STAT_ERROR_NONE
 	}
 
@@ -172,10 +365,13 @@ func (ac *AtomicClient) GetLocalPath(path string, localPath *string) error {
 	if err != nil {
 		return err
 	}
+	if metadata == nil {
+		return NO_SUCH_PATH
+	}
 	key := KeyFromBytes(metadata.GetKey())
-	resource := ac.atomic.GetResource(key)
+	resource := ac.atomic.GetFileResource(metadata)
 	if resource == nil {
-		return errors.New(fmt.Sprintf("Resource missing: %s", key.String()))
+		return Errorf(ERR_CORRUPT, "Resource missing: %s", key.String())
 	}
 	*localPath, err = filepath.Abs((resource.(*FilesystemResource)).filename)
 	if err != nil {
@@ -187,39 +383,190 @@ func (ac *AtomicClient) GetLocalPath(path string, localPath *string) error {
 type PutLocalPathArgs struct {
 	LocalPath string
 	DestPath  string
+	// if set, missing parent directories are created
+	Parents bool
 }
 
+// Copies the local file or directory tree into DestPath, along with the mode, mtime and user xattrs of
+// everything in it.  Symlinks are stored as symlinks rather than as copies of their targets.  A tree is
+// imported as a single batch, so either all of it appears or none of it does.
 func (ac *AtomicClient) PutLocalPath(args *PutLocalPathArgs, result *string) error {
-	parsedPath := NewPath(args.DestPath)
-	resource, err := ac.atomic.CreateResourceForLocalFile(args.LocalPath)
+	ops := make([]*BatchOp, 0)
+	err := filepath.Walk(args.LocalPath, func(localPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(args.LocalPath, localPath)
+		if err != nil {
+			return err
+		}
+		destPath := args.DestPath
+		if rel != "." {
+			destPath = joinDiffPath(args.DestPath, filepath.ToSlash(rel))
+		}
+		// only the first operation can need missing parents, as the rest are beneath it
+		op := &BatchOp{Path: NewPath(destPath), Parents: args.Parents && len(ops) == 0}
+		op.Attributes, err = ReadLocalAttributes(localPath)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			op.Op = BATCH_MKDIR
+		} else if op.Attributes.GetIsSymlink() {
+			op.Op = BATCH_PUT
+			op.Resource = NewMemResource([]byte(op.Attributes.GetSymlinkTarget()))
+		} else if info.Mode().IsRegular() {
+			op.Op = BATCH_PUT
+			op.Resource, err = ac.atomic.CreateResourceForLocalFile(localPath)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Created resource: %s\n", op.Resource)
+		} else {
+			return fmt.Errorf("%s is not a file, directory or symlink", localPath)
+		}
+		ops = append(ops, op)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	fmt.Printf("Created resource: %s\n", resource)
-	_, err = ac.atomic.Put(parsedPath, resource)
-	return err
+
+	return ac.atomic.Batch(ops)
 }
 
 type LinkArgs struct {
 	Key   string
 	Path  string
 	IsDir bool
+	// if set, missing parent directories are created
+	Parents bool
+	// if set, the mode, mtime, symlink target and xattrs to record for the link.  A key only identifies
+	// content, so these are otherwise lost when a file is linked elsewhere.
+	Attributes *FileMetadata
 }
 
 func (ac *AtomicClient) Link(args *LinkArgs, result *string) error {
 	parsedPath := NewPath(args.Path)
 	parsedKey := NewKey(args.Key)
+	if args.Parents || args.Attributes != nil {
+		return ac.atomic.Batch([]*BatchOp{{Op: BATCH_LINK, Path: parsedPath, Key: parsedKey, IsDir: args.IsDir, Parents: args.Parents, Attributes: args.Attributes}})
+	}
 	return ac.atomic.Link(parsedKey, parsedPath, args.IsDir)
 }
 
+type ExportArgs struct {
+	Path string
+	// where to write the copy.  Must not already exist.
+	LocalPath string
+}
+
+// Copies the file or directory at Path to LocalPath, restoring the mode, mtime, xattrs and symlinks
+// recorded when its files were put.
+func (ac *AtomicClient) Export(args *ExportArgs, result *string) error {
+	metadata, err := ac.atomic.GetMetadata(NewPath(args.Path))
+	if err != nil {
+		return err
+	}
+	if metadata == nil {
+		return NO_SUCH_PATH
+	}
+	return exportEntry(ac.atomic, args.Path, metadata, args.LocalPath)
+}
+
+func exportEntry(atomic Atomic, path string, metadata *FileMetadata, localPath string) error {
+	if metadata.GetIsSymlink() {
+		return os.Symlink(metadata.GetSymlinkTarget(), localPath)
+	}
+
+	if metadata.GetIsDir() {
+		err := os.Mkdir(localPath, 0700)
+		if err != nil {
+			return err
+		}
+		it, err := atomic.GetDirectoryIterator(NewPath(path))
+		if err != nil {
+			return err
+		}
+		for it.HasNext() {
+			name, child := it.Next()
+			err = exportEntry(atomic, joinDiffPath(path, name), child, filepath.Join(localPath, name))
+			if err != nil {
+				return err
+			}
+		}
+		// restored after the children are written, as writing them changes the directory's mtime
+		return RestoreLocalAttributes(localPath, metadata)
+	}
+
+	resource := atomic.GetFileResource(metadata)
+	if resource == nil {
+		return Errorf(ERR_CORRUPT, "Resource missing: %s", KeyFromBytes(metadata.GetKey()).String())
+	}
+	w, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	r := resource.GetReader()
+	_, err = io.Copy(w, r)
+	closeReader(r)
+	closeErr := w.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return RestoreLocalAttributes(localPath, metadata)
+}
+
 func (ac *AtomicClient) Unlink(path string, result *string) error {
 	parsedPath := NewPath(path)
 	return ac.atomic.Unlink(parsedPath)
 }
 
+type BatchOpArgs struct {
+	// one of BATCH_LINK, BATCH_UNLINK, BATCH_PUT or BATCH_MKDIR
+	Op   string
+	Path string
+	// for BATCH_LINK
+	Key   string
+	IsDir bool
+	// for BATCH_PUT
+	LocalPath string
+	// for BATCH_LINK and BATCH_PUT, as in LinkArgs
+	Attributes *FileMetadata
+}
+
+func (ac *AtomicClient) ApplyBatch(args []BatchOpArgs, result *string) error {
+	ops := make([]*BatchOp, 0, len(args))
+	for _, arg := range args {
+		op := &BatchOp{Op: arg.Op, Path: NewPath(arg.Path), IsDir: arg.IsDir, Attributes: arg.Attributes}
+		if arg.Op == BATCH_LINK {
+			op.Key = NewKey(arg.Key)
+		} else if arg.Op == BATCH_PUT {
+			resource, err := ac.atomic.CreateResourceForLocalFile(arg.LocalPath)
+			if err != nil {
+				return err
+			}
+			op.Resource = resource
+		}
+		ops = append(ops, op)
+	}
+
+	return ac.atomic.Batch(ops)
+}
+
+// by default, file content is never stored inline
+const DEFAULT_INLINE_THRESHOLD = 0
+
 type AtomicState struct {
 	lock sync.Mutex // protects access to roots
 
+	// files no larger than this are stored in their directory's leaf rather than as separate chunks
+	inlineThreshold int64
+
 	roots RootMap
 
 	dirService DirectoryService
@@ -324,7 +671,7 @@ func NewMemRootMap() RootMap {
 }
 
 func NewAtomicState(dirService DirectoryService, chunks *ChunkCache, cache *filesystemCacheDB, tags TagService, roots RootMap) *AtomicState {
-	return &AtomicState{dirService: dirService, roots: roots, cache: cache, chunks: chunks, tags: tags, leases: make([]string, 0, 10)}
+	return &AtomicState{dirService: dirService, roots: roots, cache: cache, chunks: chunks, tags: tags, leases: make([]string, 0, 10), inlineThreshold: DEFAULT_INLINE_THRESHOLD}
 }
 
 var LEASE_TIMEOUT uint64 = 60 * 60 * 24
@@ -428,6 +775,30 @@ func (self *AtomicState) DumpDebug() {
 }
 
 func (self *AtomicState) Push(key *Key, tag string, lease *Lease) error {
+	err := self.pushChunks(key)
+	if err != nil {
+		return err
+	}
+
+	return self.tags.Put(tag, key)
+}
+
+func (self *AtomicState) PushIfUnchanged(key *Key, tag string, expected *Key, lease *Lease) error {
+	err := self.pushChunks(key)
+	if err != nil {
+		return err
+	}
+
+	return self.tags.CompareAndPut(tag, expected, key)
+}
+
+// maximum number of chunks checked against the remote at once when pushing
+var PUSH_CHECK_BATCH = 1000
+
+// copies every chunk reachable from the directory key which is not already on the remote.  Chunks are
+// checked against the remote in batches of PUSH_CHECK_BATCH, and any which another minion has already
+// pushed are only marked as remote.
+func (self *AtomicState) pushChunks(key *Key) error {
 	seen := make(map[Key]*Key)
 	pending := make([]typedKey, 0, 1000)
 
@@ -437,47 +808,73 @@ func (self *AtomicState) Push(key *Key, tag string, lease *Lease) error {
 	pending = append(pending, typedKey{key, true})
 
 	for len(pending) > 0 {
-		next := pending[len(pending)-1]
-		pending = pending[:len(pending)-1]
+		batch := make([]typedKey, 0, PUSH_CHECK_BATCH)
+		entries := make([]*cacheEntry, 0, PUSH_CHECK_BATCH)
+		for len(pending) > 0 && len(batch) < PUSH_CHECK_BATCH {
+			next := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+
+			_, wasSeen := seen[*next.key]
+			if wasSeen {
+				continue
+			}
 
-		_, wasSeen := seen[*next.key]
-		if wasSeen {
-			continue
-		}
+			// remember we've handled this block
+			seen[*next.key] = next.key
 
-		// remember we've handled this block
-		seen[*next.key] = next.key
+			entry := self.cache.Get(next.key)
+			if entry == nil {
+				panic("Could not find cache entry for " + next.key.String())
+			}
+			if entry.source == REMOTE {
+				continue
+			}
 
-		entry := self.cache.Get(next.key)
-		if entry == nil {
-			panic("Could not find cache entry for " + next.key.String())
+			batch = append(batch, next)
+			entries = append(entries, entry)
 		}
-		if entry.source == REMOTE {
-			continue
+
+		keys := make([]*Key, len(batch))
+		for i, next := range batch {
+			keys[i] = next.key
+		}
+		onRemote, err := self.chunks.RemoteHasMany(keys)
+		if err != nil {
+			log.Printf("Could not check which chunks are already on the remote, pushing all of them: %s", err)
+			onRemote = make([]bool, len(keys))
 		}
 
-		// copy chunk to remote
-		self.chunks.PushToRemote(next.key)
-		// remember it's now available on the remote, to prevent pushing it again in the future
-		// this may alleviate the need for tracking blocks with 'seen'
-		self.cache.Put(next.key, &cacheEntry{source: REMOTE, resource: entry.resource})
+		for i, next := range batch {
+			if !onRemote[i] {
+				// copy chunk to remote
+				err = self.chunks.PushToRemote(next.key)
+				if err != nil {
+					return err
+				}
+			}
+			// remember it's now available on the remote, to prevent pushing it again in the future
+			self.cache.Put(next.key, &cacheEntry{source: REMOTE, resource: entries[i].resource})
 
-		if !next.isDir {
-			continue
-		}
+			if !next.isDir {
+				continue
+			}
 
-		// now record all the keys that this references
-		dir := self.dirService.GetDirectory(next.key)
-		it := dir.Iterate()
-		for it.HasNext() {
-			_, meta := it.Next()
-			pending = append(pending, typedKey{KeyFromBytes(meta.GetKey()), meta.GetIsDir()})
+			// now record all the keys that this references.  A directory on the remote may still be
+			// missing some of its children if the push which wrote it was interrupted, so always descend.
+			dir := self.dirService.GetDirectory(next.key)
+			it := dir.Iterate()
+			for it.HasNext() {
+				_, meta := it.Next()
+				if isInline(meta) {
+					// already included in this directory's chunk
+					continue
+				}
+				pending = append(pending, typedKey{KeyFromBytes(meta.GetKey()), meta.GetIsDir()})
+			}
 		}
 	}
 
-	self.tags.Put(tag, key)
-
-	return nil
+	return self.chunks.FlushRemote()
 }
 
 func (self *AtomicState) CreateResourceForLocalFile(localFile string) (Resource, error) {
@@ -513,8 +910,10 @@ func (self *AtomicState) unsafeGetDirsFromPath(path *Path) ([]Directory, error)
 		if err != nil {
 			return nil, err
 		}
-		if metadata == nil || !metadata.GetIsDir() {
+		if metadata == nil {
 			return nil, NO_SUCH_PATH
+		} else if !metadata.GetIsDir() {
+			return nil, NOT_A_DIRECTORY
 		}
 		dirKey = KeyFromBytes(metadata.GetKey())
 	}
@@ -577,8 +976,28 @@ func NewMemDirIterator(names []string, metadatas []*FileMetadata) Iterator {
 }
 
 func (self *AtomicState) ForEachRoot(prefix string, callback func(name string, key *Key)) error {
-	self.tags.ForEach(callback)
-	return nil
+	return self.tags.ForEach(prefix, callback)
+}
+
+func (self *AtomicState) WatchRoots(prefix string, sinceSeq uint64, timeout time.Duration) ([]TagChange, uint64, error) {
+	return self.tags.Watch(prefix, sinceSeq, timeout)
+}
+
+func (self *AtomicState) Diff(a *Key, b *Key) ([]DiffEntry, error) {
+	// directories are immutable, so there is no need to hold the lock while comparing them
+	return Diff(self.dirService, a, b)
+}
+
+func (self *AtomicState) Merge(base *Key, ours *Key, theirs *Key) (*Key, []MergeConflict, error) {
+	return Merge(self.dirService, self.chunks, base, ours, theirs)
+}
+
+func (self *AtomicState) GetCompressionStats() CompressionStats {
+	return self.chunks.GetCompressionStats()
+}
+
+func (self *AtomicState) DeleteTag(tag string) error {
+	return self.tags.Delete(tag)
 }
 
 func (self *AtomicState) GetDirectoryIterator(path *Path) (Iterator, error) {
@@ -653,39 +1072,69 @@ func (self *AtomicState) GetResource(key *Key) Resource {
 }
 
 func (self *AtomicState) Put(destination *Path, resource Resource) (*Key, error) {
-	buffer := resource.AsBytes()
-	key := computeContentKey(buffer)
-
-	self.cache.Put(key, &cacheEntry{source: LOCAL, resource: resource})
-
-	err := self.Link(key, destination, false)
+	op := &BatchOp{Op: BATCH_PUT, Path: destination, Resource: resource}
+	err := self.Batch([]*BatchOp{op})
 	if err != nil {
 		return nil, err
 	}
 
-	return key, nil
+	return op.Key, nil
 }
 
-func (self *AtomicState) unsafeLink(key *Key, path *Path, isDir bool) error {
-	var length int64
-	var childrenSize int64
+// Sets the size at or below which file content is stored inline.  0 disables inlining.
+func (self *AtomicState) SetInlineThreshold(threshold int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.inlineThreshold = threshold
+}
+
+// content is only inlined if it is not empty, because an empty Content cannot be distinguished from
+// no Content once unmarshalled
+func isInline(metadata *FileMetadata) bool {
+	return len(metadata.GetContent()) > 0
+}
+
+func (self *AtomicState) GetFileResource(metadata *FileMetadata) Resource {
+	key := KeyFromBytes(metadata.GetKey())
+	if isInline(metadata) && self.cache.Get(key) == nil {
+		// write the content into the cache so it has a local path like any other file
+		self.cache.Put(key, &cacheEntry{source: LOCAL, resource: NewMemResource(metadata.GetContent())})
+	}
+	return self.GetResource(key)
+}
+
+// returns the length of the chunk for key, and the total size of its children if it is a directory
+func (self *AtomicState) unsafeGetSizes(key *Key, isDir bool) (int64, int64, error) {
 	if *key == *EMPTY_DIR_KEY {
-		length = 0
-		childrenSize = 0
-	} else {
-		resource, err := self.chunks.Get(key)
+		return 0, 0, nil
+	}
+
+	resource, err := self.chunks.Get(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	length := resource.GetLength()
+
+	var childrenSize int64
+	if isDir {
+		dir := self.dirService.GetDirectory(key)
+		childrenSize, err = dir.GetTotalSize()
 		if err != nil {
-			return err
-		}
-		length = resource.GetLength()
-		if isDir {
-			dir := self.dirService.GetDirectory(key)
-			childrenSize, err = dir.GetTotalSize()
-		} else {
-			childrenSize = 0
+			return 0, 0, err
 		}
 	}
 
+	return length, childrenSize, nil
+}
+
+// attrs, if not nil, supplies the attributes of the linked entry
+func (self *AtomicState) unsafeLink(key *Key, path *Path, isDir bool, attrs *FileMetadata) error {
+	length, childrenSize, err := self.unsafeGetSizes(key, isDir)
+	if err != nil {
+		return err
+	}
+
 	// TODO: check for len(path) == 0 (error)
 	var newParentKey *Key
 	if len(path.path) == 0 {
@@ -701,6 +1150,7 @@ func (self *AtomicState) unsafeLink(key *Key, path *Path, isDir bool) error {
 		}
 
 		var metadata *FileMetadata = &FileMetadata{TotalSize: proto.Int64(childrenSize + length), Size: proto.Int64(length), Key: key.AsBytes(), IsDir: proto.Bool(isDir), CreationTime: proto.Int64(time.Now().Unix())}
+		copyAttributes(metadata, attrs)
 
 		i := len(parentDirs) - 1
 		for i >= 0 {
@@ -712,6 +1162,14 @@ func (self *AtomicState) unsafeLink(key *Key, path *Path, isDir bool) error {
 			// update metadata to point to new metadata which points to newParentKey
 			metadata = &FileMetadata{TotalSize: proto.Int64(childrenSize + length), Size: proto.Int64(length), Key: newParentKey.AsBytes(), IsDir: proto.Bool(true), CreationTime: proto.Int64(time.Now().Unix())}
 			filename = path.path[i]
+			// the directory keeps its attributes, only its contents changed
+			if i > 0 {
+				previous, err := parentDirs[i-1].Get(filename)
+				if err != nil {
+					return err
+				}
+				copyAttributes(metadata, previous)
+			}
 			i -= 1
 		}
 	}
@@ -722,6 +1180,12 @@ func (self *AtomicState) unsafeLink(key *Key, path *Path, isDir bool) error {
 		length = self.cache.Get(newParentKey).resource.GetLength()
 	}
 	newParentMetadata := &FileMetadata{TotalSize: proto.Int64(childrenSize + length), Size: proto.Int64(length), Key: newParentKey.AsBytes(), IsDir: proto.Bool(true), CreationTime: proto.Int64(time.Now().Unix())}
+	if len(path.path) > 1 {
+		previous, _ := self.roots.Get(path.path[0])
+		copyAttributes(newParentMetadata, previous)
+	} else {
+		copyAttributes(newParentMetadata, attrs)
+	}
 
 	self.roots.Set(path.path[0], newParentMetadata)
 	return nil
@@ -731,7 +1195,7 @@ func (self *AtomicState) Link(key *Key, path *Path, isDir bool) error {
 	self.lock.Lock()
 	defer self.lock.Unlock()
 
-	return self.unsafeLink(key, path, isDir)
+	return self.unsafeLink(key, path, isDir, nil)
 }
 
 func (self *AtomicState) Unlink(path *Path) error {
@@ -752,7 +1216,23 @@ func (self *AtomicState) Unlink(path *Path) error {
 		if err != nil {
 			return err
 		}
-		return self.unsafeLink(newParentDirKey, parentPath, true)
+
+		// the directory keeps its attributes, only its contents changed
+		var attrs *FileMetadata
+		grandparentPath, dirname := parentPath.Split()
+		if grandparentPath.IsRoot() {
+			attrs, _ = self.roots.Get(dirname)
+		} else {
+			grandparentDir, err := self.unsafeGetDirFromPath(grandparentPath)
+			if err != nil {
+				return err
+			}
+			attrs, err = grandparentDir.Get(dirname)
+			if err != nil {
+				return err
+			}
+		}
+		return self.unsafeLink(newParentDirKey, parentPath, true, attrs)
 	}
 
 	return nil
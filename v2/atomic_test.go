@@ -2,11 +2,15 @@ package v2
 
 import (
 	"fmt"
+	"io/ioutil"
 
+	"github.com/golang/protobuf/proto"
 	. "gopkg.in/check.v1"
 	//	"testing"
 
 	"os"
+	"path/filepath"
+	"time"
 )
 
 type AtomicSuite struct {
@@ -34,6 +38,13 @@ func newCache(c *C) *filesystemCacheDB {
 	return cache
 }
 
+// an AtomicState with its own cache in front of remote
+func newTestAtomicState(c *C, remote ChunkService, tags TagService) *AtomicState {
+	cache := newCache(c)
+	chunks := NewChunkCache(remote, cache)
+	return NewAtomicState(NewLeafDirService(chunks), chunks, cache, tags, NewMemRootMap())
+}
+
 func (*AtomicSuite) TestChunkCache(c *C) {
 	cache := newCache(c)
 	chunks := NewChunkCache(NewMemChunkService(), cache)
@@ -50,6 +61,22 @@ func (*AtomicSuite) TestChunkCache(c *C) {
 	c.Assert(fetched.AsBytes(), DeepEquals, []byte("B"))
 }
 
+func (*AtomicSuite) TestChunkCacheMissingChunk(c *C) {
+	remote := NewMemChunkService()
+	chunks := NewChunkCache(remote, newCache(c))
+
+	_, err := chunks.Get(&Key{100})
+	c.Assert(err, Equals, NO_SUCH_CHUNK)
+	_, err = chunks.Get(&Key{100})
+	c.Assert(err, Equals, NO_SUCH_CHUNK)
+
+	// the failure was not cached, so the chunk is found once it reaches the remote
+	remote.Put(&Key{100}, NewMemResource([]byte("A")))
+	fetched, err := chunks.Get(&Key{100})
+	c.Assert(err, IsNil)
+	c.Assert(fetched.AsBytes(), DeepEquals, []byte("A"))
+}
+
 func (s *AtomicSuite) TestAtomicDirOps(c *C) {
 	cache := newCache(c)
 	chunks := NewChunkCache(NewMemChunkService(), cache)
@@ -137,7 +164,7 @@ func (s *AtomicSuite) TestStat(c *C) {
 	ac := &AtomicClient{atomic: as}
 
 	var mkdirResult string
-	ac.MakeDir("a", &mkdirResult)
+	ac.MakeDir(&MakeDirArgs{Path: "a"}, &mkdirResult)
 
 	tempFile := c.MkDir() + "/tmpfile"
 	wfile, _ := os.Create(tempFile)
@@ -207,3 +234,537 @@ func (s *AtomicSuite) TestPush(c *C) {
 	c.Assert(n, Equals, 4)
 	c.Assert("test", Equals, string(b))
 }
+
+// records which keys were written, while still reporting existing chunks via MemChunkService.HasMany
+type recordingChunkService struct {
+	*MemChunkService
+	written map[Key]bool
+}
+
+func (s *recordingChunkService) Put(key *Key, resource Resource) error {
+	s.written[*key] = true
+	return s.MemChunkService.Put(key, resource)
+}
+
+func (s *AtomicSuite) TestPushSkipsExistingChunks(c *C) {
+	remote := &recordingChunkService{MemChunkService: NewMemChunkService(), written: make(map[Key]bool)}
+	tags := NewMemTagService()
+
+	push := func(tag string) *Key {
+		as := newTestAtomicState(c, remote, tags)
+		c.Assert(as.Link(EMPTY_DIR_KEY, NewPath("a"), true), IsNil)
+		fileKey, err := as.Put(NewPath("a/b"), NewMemResource([]byte("shared content")))
+		c.Assert(err, IsNil)
+		metadata, _ := as.GetMetadata(NewPath("a"))
+		c.Assert(as.Push(KeyFromBytes(metadata.GetKey()), tag, nil), IsNil)
+		return fileKey
+	}
+
+	fileKey := push("first")
+	c.Assert(remote.written[*fileKey], Equals, true)
+
+	// a second minion with the same file does not upload it again
+	remote.written = make(map[Key]bool)
+	c.Assert(*push("second"), Equals, *fileKey)
+	c.Assert(remote.written[*fileKey], Equals, false)
+
+	found, err := remote.HasMany([]*Key{fileKey, computeContentKey([]byte("missing"))})
+	c.Assert(err, IsNil)
+	c.Assert(found, DeepEquals, []bool{true, false})
+}
+
+func (s *AtomicSuite) TestRootsByPrefix(c *C) {
+	tags := NewMemTagService()
+	as := newTestAtomicState(c, NewMemChunkService(), tags)
+	ac := &AtomicClient{atomic: as}
+
+	tags.Put("x/b", EMPTY_DIR_KEY)
+	tags.Put("x/a", EMPTY_DIR_KEY)
+	tags.Put("y", EMPTY_DIR_KEY)
+
+	var result []ListRootsRecord
+	err := ac.ListRoots("x/", &result)
+	c.Assert(err, IsNil)
+	c.Assert(len(result), Equals, 2)
+	c.Assert(result[0].Name, Equals, "x/a")
+	c.Assert(result[1].Name, Equals, "x/b")
+
+	var deleteResult string
+	c.Assert(ac.DeleteTag("x/a", &deleteResult), IsNil)
+	c.Assert(ac.DeleteTag("x/a", &deleteResult), Equals, NO_SUCH_TAG)
+
+	err = ac.ListRoots("", &result)
+	c.Assert(err, IsNil)
+	c.Assert(len(result), Equals, 2)
+}
+
+func (s *AtomicSuite) TestWatchRoots(c *C) {
+	tags := NewMemTagService()
+	as := newTestAtomicState(c, NewMemChunkService(), tags)
+	ac := &AtomicClient{atomic: as}
+
+	tags.Put("x/a", EMPTY_DIR_KEY)
+
+	var reply WatchRootsReply
+	c.Assert(ac.WatchRoots(&WatchRootsArgs{Prefix: "x/", SinceSeq: 1, TimeoutSeconds: 0}, &reply), IsNil)
+	c.Assert(len(reply.Changes), Equals, 0)
+	c.Assert(reply.Seq, Equals, uint64(1))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		tags.Put("y", EMPTY_DIR_KEY)
+		tags.Put("x/b", EMPTY_DIR_KEY)
+	}()
+	c.Assert(ac.WatchRoots(&WatchRootsArgs{Prefix: "x/", SinceSeq: reply.Seq, TimeoutSeconds: 10}, &reply), IsNil)
+	c.Assert(len(reply.Changes), Equals, 1)
+	c.Assert(reply.Changes[0].Name, Equals, "x/b")
+}
+
+type countingChunkService struct {
+	ChunkService
+	puts int
+}
+
+func (s *countingChunkService) Put(key *Key, resource Resource) error {
+	s.puts++
+	return s.ChunkService.Put(key, resource)
+}
+
+func (s *AtomicSuite) TestBatch(c *C) {
+	as := newTestAtomicState(c, NewMemChunkService(), NewMemTagService())
+	// count the directories written
+	counter := &countingChunkService{ChunkService: as.chunks}
+	as.dirService = NewLeafDirService(counter)
+	ac := &AtomicClient{atomic: as}
+
+	var result string
+	c.Assert(ac.MakeDir(&MakeDirArgs{Path: "a"}, &result), IsNil)
+	c.Assert(ac.MakeDir(&MakeDirArgs{Path: "a/old"}, &result), IsNil)
+
+	ops := []*BatchOp{
+		{Op: BATCH_MKDIR, Path: NewPath("a/x")},
+		{Op: BATCH_PUT, Path: NewPath("a/x/f1"), Resource: NewMemResource([]byte("one"))},
+		{Op: BATCH_PUT, Path: NewPath("a/x/f2"), Resource: NewMemResource([]byte("two"))},
+		{Op: BATCH_LINK, Path: NewPath("a/y"), Key: EMPTY_DIR_KEY, IsDir: true},
+		{Op: BATCH_UNLINK, Path: NewPath("a/old")},
+	}
+	counter.puts = 0
+	c.Assert(as.Batch(ops), IsNil)
+
+	// one write for a/x and one for a, despite five operations
+	c.Assert(counter.puts, Equals, 2)
+
+	it, _ := as.GetDirectoryIterator(NewPath("a"))
+	c.Assert(fetchNamesFromIter(it), DeepEquals, []string{"x", "y"})
+	it, _ = as.GetDirectoryIterator(NewPath("a/x"))
+	c.Assert(fetchNamesFromIter(it), DeepEquals, []string{"f1", "f2"})
+
+	metadata, err := as.GetMetadata(NewPath("a/x/f2"))
+	c.Assert(err, IsNil)
+	c.Assert(metadata.GetSize(), Equals, int64(3))
+	c.Assert(KeyFromBytes(metadata.GetKey()), DeepEquals, ops[2].Key)
+
+	// a failing operation leaves everything untouched
+	before, _ := as.GetMetadata(NewPath("a"))
+	ops = []*BatchOp{
+		{Op: BATCH_UNLINK, Path: NewPath("a/x")},
+		{Op: BATCH_MKDIR, Path: NewPath("a/missing/z")},
+	}
+	c.Assert(as.Batch(ops), Equals, NO_SUCH_PATH)
+	after, _ := as.GetMetadata(NewPath("a"))
+	c.Assert(after.GetKey(), DeepEquals, before.GetKey())
+
+	tempFile := c.MkDir() + "/tmpfile"
+	wfile, _ := os.Create(tempFile)
+	wfile.WriteString("test")
+	wfile.Close()
+
+	err = ac.ApplyBatch([]BatchOpArgs{
+		{Op: BATCH_MKDIR, Path: "b"},
+		{Op: BATCH_PUT, Path: "b/file", LocalPath: tempFile},
+		{Op: BATCH_LINK, Path: "b/y", Key: EMPTY_DIR_KEY.String(), IsDir: true},
+	}, &result)
+	c.Assert(err, IsNil)
+	it, _ = as.GetDirectoryIterator(NewPath("b"))
+	c.Assert(fetchNamesFromIter(it), DeepEquals, []string{"file", "y"})
+}
+
+func (s *AtomicSuite) TestDiff(c *C) {
+	as := newTestAtomicState(c, NewMemChunkService(), NewMemTagService())
+	ac := &AtomicClient{atomic: as}
+
+	put := func(path string, content string) {
+		_, err := as.Put(NewPath(path), NewMemResource([]byte(content)))
+		c.Assert(err, IsNil)
+	}
+
+	var result string
+	ac.MakeDir(&MakeDirArgs{Path: "a"}, &result)
+	ac.MakeDir(&MakeDirArgs{Path: "a/same"}, &result)
+	ac.MakeDir(&MakeDirArgs{Path: "a/sub"}, &result)
+	ac.MakeDir(&MakeDirArgs{Path: "a/gone"}, &result)
+	put("a/same/f", "1")
+	put("a/sub/changed", "1")
+	put("a/sub/removed", "1")
+	put("a/top", "1")
+
+	c.Assert(ac.Push(&PushArgs{Source: "a", Tag: "v1"}, &result), IsNil)
+
+	put("a/sub/changed", "2")
+	put("a/sub/added", "1")
+	ac.Unlink("a/sub/removed", &result)
+	ac.Unlink("a/gone", &result)
+	ac.MakeDir(&MakeDirArgs{Path: "a/new"}, &result)
+
+	var entries []DiffEntry
+	c.Assert(ac.Diff(&DiffArgs{A: "v1", B: "a"}, &entries), IsNil)
+
+	summary := make([]string, 0)
+	for _, entry := range entries {
+		summary = append(summary, entry.Change+" "+entry.Path)
+	}
+	c.Assert(summary, DeepEquals, []string{
+		"removed gone",
+		"added new",
+		"added sub/added",
+		"modified sub/changed",
+		"removed sub/removed"})
+	c.Assert(entries[0].IsDir, Equals, true)
+	c.Assert(entries[3].OldKey, Not(Equals), entries[3].NewKey)
+
+	c.Assert(ac.Diff(&DiffArgs{A: "a", B: "a"}, &entries), IsNil)
+	c.Assert(len(entries), Equals, 0)
+
+	// changing only the attributes of a file is a modification
+	metadata, _ := as.GetMetadata(NewPath("a/top"))
+	c.Assert(as.Batch([]*BatchOp{{Op: BATCH_LINK, Path: NewPath("a/top"), Key: KeyFromBytes(metadata.GetKey()),
+		Attributes: &FileMetadata{Mode: proto.Uint32(0755)}}}), IsNil)
+	c.Assert(ac.Diff(&DiffArgs{A: "v1", B: "a"}, &entries), IsNil)
+	c.Assert(entries[len(entries)-1].Path, Equals, "top")
+	c.Assert(entries[len(entries)-1].Change, Equals, DIFF_MODIFIED)
+	c.Assert(entries[len(entries)-1].OldKey, Equals, entries[len(entries)-1].NewKey)
+
+	c.Assert(ac.Diff(&DiffArgs{A: "missing", B: "a"}, &entries), NotNil)
+}
+
+func (s *AtomicSuite) TestMerge(c *C) {
+	tags := NewMemTagService()
+	as := newTestAtomicState(c, NewMemChunkService(), tags)
+	ac := &AtomicClient{atomic: as}
+
+	put := func(path string, content string) {
+		_, err := as.Put(NewPath(path), NewMemResource([]byte(content)))
+		c.Assert(err, IsNil)
+	}
+	push := func(path string, tag string) {
+		var result string
+		c.Assert(ac.Push(&PushArgs{Source: path, Tag: tag}, &result), IsNil)
+	}
+
+	var result string
+	ac.MakeDir(&MakeDirArgs{Path: "a"}, &result)
+	ac.MakeDir(&MakeDirArgs{Path: "a/out"}, &result)
+	put("a/shared", "1")
+	push("a", "base")
+
+	// two minions each add their own output
+	put("a/out/x", "x")
+	put("a/ours-only", "1")
+	push("a", "ours")
+
+	c.Assert(ac.Link(&LinkArgs{Key: tagKey(tags, "base").String(), Path: "b", IsDir: true}, &result), IsNil)
+	put("b/out/y", "y")
+	ac.Unlink("b/shared", &result)
+	push("b", "theirs")
+
+	var merge MergeResult
+	c.Assert(ac.Merge(&MergeArgs{Base: "base", Ours: "ours", Theirs: "theirs", Destination: "m", Tag: "ours"}, &merge), IsNil)
+	c.Assert(len(merge.Conflicts), Equals, 0)
+
+	it, _ := as.GetDirectoryIterator(NewPath("m"))
+	c.Assert(fetchNamesFromIter(it), DeepEquals, []string{"ours-only", "out"})
+	it, _ = as.GetDirectoryIterator(NewPath("m/out"))
+	c.Assert(fetchNamesFromIter(it), DeepEquals, []string{"x", "y"})
+	c.Assert(tagKey(tags, "ours").String(), Equals, merge.Key)
+
+	// pushing again fails because ours has moved on
+	c.Assert(ac.Merge(&MergeArgs{Base: "base", Ours: "a", Theirs: "theirs", Tag: "ours"}, &merge), Equals, TAG_CHANGED)
+
+	// changing the same file on both sides is a conflict
+	put("a/out/x", "x2")
+	put("b/out/x", "x3")
+	c.Assert(ac.Merge(&MergeArgs{Base: "ours", Ours: "a", Theirs: "b", Tag: "ours"}, &merge), IsNil)
+	c.Assert(len(merge.Conflicts), Equals, 1)
+	c.Assert(merge.Conflicts[0].Path, Equals, "out/x")
+	c.Assert(tagKey(tags, "ours").String(), Not(Equals), merge.Key)
+}
+
+func (s *AtomicSuite) TestSameAttributes(c *C) {
+	base := &FileMetadata{Mode: proto.Uint32(0644), Mtime: proto.Int64(1),
+		Xattrs: []*Xattr{{Name: proto.String("user.a"), Value: []byte("1")}, {Name: proto.String("user.b"), Value: []byte("2")}}}
+	c.Assert(sameAttributes(base, base), Equals, true)
+
+	// xattrs are compared regardless of order
+	reordered := &FileMetadata{Mode: proto.Uint32(0644), Mtime: proto.Int64(1),
+		Xattrs: []*Xattr{{Name: proto.String("user.b"), Value: []byte("2")}, {Name: proto.String("user.a"), Value: []byte("1")}}}
+	c.Assert(sameAttributes(base, reordered), Equals, true)
+
+	for _, changed := range []*FileMetadata{
+		{Mode: proto.Uint32(0600), Mtime: proto.Int64(1), Xattrs: base.Xattrs},
+		{Mode: proto.Uint32(0644), Mtime: proto.Int64(2), Xattrs: base.Xattrs},
+		{Mode: proto.Uint32(0644), Mtime: proto.Int64(1), Xattrs: base.Xattrs, IsSymlink: proto.Bool(true), SymlinkTarget: proto.String("x")},
+		{Mode: proto.Uint32(0644), Mtime: proto.Int64(1), Xattrs: base.Xattrs[:1]},
+		{Mode: proto.Uint32(0644), Mtime: proto.Int64(1), Xattrs: []*Xattr{base.Xattrs[0], {Name: proto.String("user.b"), Value: []byte("3")}}},
+	} {
+		c.Assert(sameAttributes(base, changed), Equals, false)
+		c.Assert(sameEntry(base, changed), Equals, false)
+	}
+}
+
+func (s *AtomicSuite) TestDiffAndMergeUnreadableDirectory(c *C) {
+	as := newTestAtomicState(c, NewMemChunkService(), NewMemTagService())
+	c.Assert(as.Link(EMPTY_DIR_KEY, NewPath("a"), true), IsNil)
+	_, err := as.Put(NewPath("a/f"), NewMemResource([]byte("f")))
+	c.Assert(err, IsNil)
+	metadata, _ := as.GetMetadata(NewPath("a"))
+	readable := KeyFromBytes(metadata.GetKey())
+
+	// the remote does not have this directory, so it cannot be read
+	missing := &Key{1}
+	_, err = as.Diff(readable, missing)
+	c.Assert(err, NotNil)
+	_, _, err = as.Merge(EMPTY_DIR_KEY, readable, missing)
+	c.Assert(err, NotNil)
+}
+
+func tagKey(tags TagService, tag string) *Key {
+	key, _ := tags.Get(tag)
+	return key
+}
+
+func (s *AtomicSuite) TestMakeParents(c *C) {
+	as := newTestAtomicState(c, NewMemChunkService(), NewMemTagService())
+	ac := &AtomicClient{atomic: as}
+
+	var result string
+	c.Assert(ac.MakeDir(&MakeDirArgs{Path: "a/b/c"}, &result), Equals, NO_SUCH_PATH)
+	c.Assert(ac.MakeDir(&MakeDirArgs{Path: "a/b/c", Parents: true}, &result), IsNil)
+
+	tempFile := c.MkDir() + "/tmpfile"
+	wfile, _ := os.Create(tempFile)
+	wfile.WriteString("test")
+	wfile.Close()
+
+	c.Assert(ac.PutLocalPath(&PutLocalPathArgs{LocalPath: tempFile, DestPath: "a/b/c/file", Parents: true}, &result), IsNil)
+	c.Assert(ac.PutLocalPath(&PutLocalPathArgs{LocalPath: tempFile, DestPath: "a/x/y/file", Parents: true}, &result), IsNil)
+	c.Assert(ac.Link(&LinkArgs{Key: EMPTY_DIR_KEY.String(), Path: "d/e", IsDir: true, Parents: true}, &result), IsNil)
+
+	// an existing directory is left alone
+	c.Assert(ac.MakeDir(&MakeDirArgs{Path: "a/b/c", Parents: true}, &result), IsNil)
+	it, _ := as.GetDirectoryIterator(NewPath("a/b/c"))
+	c.Assert(fetchNamesFromIter(it), DeepEquals, []string{"file"})
+
+	it, _ = as.GetDirectoryIterator(NewPath("a"))
+	c.Assert(fetchNamesFromIter(it), DeepEquals, []string{"b", "x"})
+	it, _ = as.GetDirectoryIterator(NewPath("d"))
+	c.Assert(fetchNamesFromIter(it), DeepEquals, []string{"e"})
+
+	// but a file in the way is still an error
+	c.Assert(ac.MakeDir(&MakeDirArgs{Path: "a/b/c/file/z", Parents: true}, &result), Equals, NOT_A_DIRECTORY)
+	c.Assert(ac.MakeDir(&MakeDirArgs{Path: "a/b/c/file", Parents: true}, &result), Equals, PATH_EXISTS)
+}
+
+func (s *AtomicSuite) TestLocalAttributes(c *C) {
+	as := newTestAtomicState(c, NewMemChunkService(), NewMemTagService())
+	ac := &AtomicClient{atomic: as}
+
+	dir := c.MkDir()
+	script := dir + "/script"
+	wfile, _ := os.Create(script)
+	wfile.WriteString("#!/bin/sh\n")
+	wfile.Close()
+	c.Assert(os.Chmod(script, 0750), IsNil)
+	mtime := time.Unix(1000000, 500)
+	c.Assert(os.Chtimes(script, mtime, mtime), IsNil)
+	c.Assert(os.Symlink("script", dir+"/link"), IsNil)
+
+	var result string
+	c.Assert(ac.PutLocalPath(&PutLocalPathArgs{LocalPath: script, DestPath: "a/script", Parents: true}, &result), IsNil)
+	c.Assert(ac.PutLocalPath(&PutLocalPathArgs{LocalPath: dir + "/link", DestPath: "a/link"}, &result), IsNil)
+
+	var stat StatResponse
+	c.Assert(ac.Stat("a/script", &stat), IsNil)
+	c.Assert(stat.Mode, Equals, uint32(0750))
+	c.Assert(stat.Mtime, Equals, mtime.UnixNano())
+	c.Assert(stat.IsSymlink, Equals, false)
+	c.Assert(stat.Size, Equals, int64(10))
+
+	c.Assert(ac.Stat("a/link", &stat), IsNil)
+	c.Assert(stat.IsSymlink, Equals, true)
+	c.Assert(stat.SymlinkTarget, Equals, "script")
+
+	var records []ListFilesRecord
+	c.Assert(ac.ListFiles("a", &records), IsNil)
+	c.Assert(len(records), Equals, 2)
+	c.Assert(records[0].Name, Equals, "link")
+	c.Assert(records[0].IsSymlink, Equals, true)
+	c.Assert(records[1].Mode, Equals, uint32(0750))
+}
+
+func (s *AtomicSuite) TestExportRestoresAttributes(c *C) {
+	as := newTestAtomicState(c, NewMemChunkService(), NewMemTagService())
+	ac := &AtomicClient{atomic: as}
+
+	dir := c.MkDir()
+	script := dir + "/script"
+	c.Assert(ioutil.WriteFile(script, []byte("#!/bin/sh\n"), 0600), IsNil)
+	c.Assert(os.Chmod(script, 0750), IsNil)
+	mtime := time.Unix(1000000, 500)
+	c.Assert(os.Chtimes(script, mtime, mtime), IsNil)
+	c.Assert(os.Symlink("script", dir+"/link"), IsNil)
+
+	var result string
+	c.Assert(ac.PutLocalPath(&PutLocalPathArgs{LocalPath: script, DestPath: "a/sub/script", Parents: true}, &result), IsNil)
+	c.Assert(ac.PutLocalPath(&PutLocalPathArgs{LocalPath: dir + "/link", DestPath: "a/sub/link"}, &result), IsNil)
+
+	// linking a key elsewhere keeps the attributes it is given
+	var key string
+	c.Assert(ac.GetKey("a/sub/script", &key), IsNil)
+	attrs, err := ReadLocalAttributes(script)
+	c.Assert(err, IsNil)
+	c.Assert(ac.Link(&LinkArgs{Key: key, Path: "a/copy", Attributes: attrs}, &result), IsNil)
+	c.Assert(ac.ApplyBatch([]BatchOpArgs{{Op: BATCH_LINK, Path: "a/batch-copy", Key: key, Attributes: attrs}}, &result), IsNil)
+
+	exported := c.MkDir() + "/a"
+	c.Assert(ac.Export(&ExportArgs{Path: "a", LocalPath: exported}, &result), IsNil)
+
+	for _, path := range []string{"sub/script", "copy", "batch-copy"} {
+		info, err := os.Lstat(exported + "/" + path)
+		c.Assert(err, IsNil)
+		c.Assert(info.Mode(), Equals, os.FileMode(0750))
+		c.Assert(info.ModTime().UnixNano(), Equals, mtime.UnixNano())
+		content, err := ioutil.ReadFile(exported + "/" + path)
+		c.Assert(err, IsNil)
+		c.Assert(string(content), Equals, "#!/bin/sh\n")
+	}
+	target, err := os.Readlink(exported + "/sub/link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "script")
+
+	// an existing file is not overwritten
+	c.Assert(ac.Export(&ExportArgs{Path: "a/copy", LocalPath: script}, &result), NotNil)
+	c.Assert(ac.Export(&ExportArgs{Path: "a/missing", LocalPath: dir + "/missing"}, &result), Equals, NO_SUCH_PATH)
+}
+
+func (s *AtomicSuite) TestImportAndExportTree(c *C) {
+	as := newTestAtomicState(c, NewMemChunkService(), NewMemTagService())
+	ac := &AtomicClient{atomic: as}
+
+	tree := c.MkDir() + "/tree"
+	c.Assert(os.MkdirAll(tree+"/sub", 0700), IsNil)
+	c.Assert(ioutil.WriteFile(tree+"/run.sh", []byte("#!/bin/sh\n"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(tree+"/sub/data", []byte("some data"), 0640), IsNil)
+	c.Assert(os.Symlink("../run.sh", tree+"/sub/link"), IsNil)
+	c.Assert(writeUserXattrs(tree+"/sub/data", []*Xattr{{Name: proto.String("user.origin"), Value: []byte("lab")}}), IsNil)
+	// the xattr is only checked where the filesystem kept it
+	hasXattr := false
+	if xattrs, err := readUserXattrs(tree + "/sub/data"); err == nil && len(xattrs) == 1 {
+		hasXattr = true
+	}
+	for i, path := range []string{"run.sh", "sub/data", "sub", ""} {
+		mtime := time.Unix(1000000+int64(i), 0)
+		c.Assert(os.Chtimes(filepath.Join(tree, path), mtime, mtime), IsNil)
+	}
+	c.Assert(os.Chmod(tree, 0750), IsNil)
+
+	var result string
+	c.Assert(ac.PutLocalPath(&PutLocalPathArgs{LocalPath: tree, DestPath: "imported/tree", Parents: true}, &result), IsNil)
+
+	exported := c.MkDir() + "/tree"
+	c.Assert(ac.Export(&ExportArgs{Path: "imported/tree", LocalPath: exported}, &result), IsNil)
+
+	// symlinks are recreated with their target, but their own mtime is not restored
+	for _, path := range []string{"", "run.sh", "sub", "sub/data"} {
+		original, err := ReadLocalAttributes(filepath.Join(tree, path))
+		c.Assert(err, IsNil)
+		copied, err := ReadLocalAttributes(filepath.Join(exported, path))
+		c.Assert(err, IsNil)
+		c.Assert(sameAttributes(original, copied), Equals, true, Commentf("%q: %v != %v", path, original, copied))
+	}
+	content, err := ioutil.ReadFile(exported + "/sub/data")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "some data")
+	target, err := os.Readlink(exported + "/sub/link")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "../run.sh")
+	if hasXattr {
+		attrs, _ := ReadLocalAttributes(exported + "/sub/data")
+		c.Assert(len(attrs.GetXattrs()), Equals, 1)
+	}
+
+	// directories keep their attributes when their contents change
+	_, err = as.Put(NewPath("imported/tree/sub/new"), NewMemResource([]byte("new")))
+	c.Assert(err, IsNil)
+	c.Assert(ac.Unlink("imported/tree/run.sh", &result), IsNil)
+	for path, mode := range map[string]uint32{"imported/tree": 0750, "imported/tree/sub": 0700} {
+		metadata, err := as.GetMetadata(NewPath(path))
+		c.Assert(err, IsNil)
+		c.Assert(metadata.GetMode(), Equals, mode, Commentf(path))
+	}
+}
+
+func (s *AtomicSuite) TestInlineFiles(c *C) {
+	remote := &countingChunkService{ChunkService: NewMemChunkService()}
+	as := newTestAtomicState(c, remote, NewMemTagService())
+	as.SetInlineThreshold(10)
+	ac := &AtomicClient{atomic: as}
+
+	var result string
+	ac.MakeDir(&MakeDirArgs{Path: "a"}, &result)
+	small, err := as.Put(NewPath("a/small"), NewMemResource([]byte("tiny")))
+	c.Assert(err, IsNil)
+	_, err = as.Put(NewPath("a/large"), NewMemResource([]byte("larger than ten bytes")))
+	c.Assert(err, IsNil)
+
+	// the small file has no chunk of its own
+	c.Assert(as.cache.Get(small), IsNil)
+	metadata, _ := as.GetMetadata(NewPath("a/small"))
+	c.Assert(metadata.GetContent(), DeepEquals, []byte("tiny"))
+	c.Assert(metadata.GetSize(), Equals, int64(4))
+
+	// so pushing only copies the directory and the large file
+	c.Assert(ac.Push(&PushArgs{Source: "a", Tag: "t"}, &result), IsNil)
+	c.Assert(remote.puts, Equals, 2)
+
+	// but it still gets a local path when asked for
+	var localPath string
+	c.Assert(ac.GetLocalPath("a/small", &localPath), IsNil)
+	file, _ := os.Open(localPath)
+	b := make([]byte, 10)
+	n, _ := file.Read(b)
+	file.Close()
+	c.Assert(string(b[:n]), Equals, "tiny")
+}
+
+func (s *AtomicSuite) TestLinkInlineFileKey(c *C) {
+	as := newTestAtomicState(c, NewMemChunkService(), NewMemTagService())
+	as.SetInlineThreshold(10)
+	ac := &AtomicClient{atomic: as}
+
+	var result string
+	c.Assert(ac.MakeDir(&MakeDirArgs{Path: "a"}, &result), IsNil)
+	_, err := as.Put(NewPath("a/small"), NewMemResource([]byte("tiny")))
+	c.Assert(err, IsNil)
+
+	var key string
+	c.Assert(ac.GetKey("a/small", &key), IsNil)
+	c.Assert(ac.Link(&LinkArgs{Key: key, Path: "a/copy"}, &result), IsNil)
+	c.Assert(ac.ApplyBatch([]BatchOpArgs{{Op: BATCH_LINK, Path: "a/batch-copy", Key: key}}, &result), IsNil)
+
+	for _, path := range []string{"a/copy", "a/batch-copy"} {
+		metadata, err := as.GetMetadata(NewPath(path))
+		c.Assert(err, IsNil)
+		c.Assert(metadata.GetSize(), Equals, int64(4))
+		c.Assert(as.GetFileResource(metadata).AsBytes(), DeepEquals, []byte("tiny"))
+	}
+}
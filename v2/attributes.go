@@ -0,0 +1,106 @@
+package v2
+
+import (
+	"bytes"
+	"os"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// xattrs are stored in every listing of their directory, so larger values are not recorded
+const MAX_XATTR_SIZE = 1024
+
+// only xattrs in this namespace are recorded
+const USER_XATTR_PREFIX = "user."
+
+// Returns a FileMetadata with only the attributes of the local file set: its permission bits,
+// modification time, user xattrs, and if it is a symlink, its target.  Symlinks are not followed.
+func ReadLocalAttributes(localPath string) (*FileMetadata, error) {
+	info, err := os.Lstat(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := &FileMetadata{Mode: proto.Uint32(uint32(info.Mode().Perm())), Mtime: proto.Int64(info.ModTime().UnixNano())}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(localPath)
+		if err != nil {
+			return nil, err
+		}
+		attrs.IsSymlink = proto.Bool(true)
+		attrs.SymlinkTarget = proto.String(target)
+		return attrs, nil
+	}
+
+	attrs.Xattrs, err = readUserXattrs(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return attrs, nil
+}
+
+// Applies the attributes recorded by ReadLocalAttributes to an existing local file or directory.  The
+// mtime is set last, so this should be called once the content has been written.  Symlinks must be created
+// with their target by the caller, and are left as they are.
+func RestoreLocalAttributes(localPath string, attrs *FileMetadata) error {
+	if attrs.GetIsSymlink() {
+		return nil
+	}
+
+	err := writeUserXattrs(localPath, attrs.GetXattrs())
+	if err != nil {
+		return err
+	}
+	if attrs.Mode != nil {
+		err = os.Chmod(localPath, os.FileMode(attrs.GetMode()))
+		if err != nil {
+			return err
+		}
+	}
+	if attrs.Mtime != nil {
+		mtime := time.Unix(0, attrs.GetMtime())
+		err = os.Chtimes(localPath, mtime, mtime)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copies the attributes set by ReadLocalAttributes from src to dst
+func copyAttributes(dst *FileMetadata, src *FileMetadata) {
+	if src == nil {
+		return
+	}
+	dst.Mode = src.Mode
+	dst.Mtime = src.Mtime
+	dst.IsSymlink = src.IsSymlink
+	dst.SymlinkTarget = src.SymlinkTarget
+	dst.Xattrs = src.Xattrs
+}
+
+// returns whether a and b have the same attributes, as set by ReadLocalAttributes
+func sameAttributes(a *FileMetadata, b *FileMetadata) bool {
+	if a.GetMode() != b.GetMode() || a.GetMtime() != b.GetMtime() ||
+		a.GetIsSymlink() != b.GetIsSymlink() || a.GetSymlinkTarget() != b.GetSymlinkTarget() {
+		return false
+	}
+
+	if len(a.GetXattrs()) != len(b.GetXattrs()) {
+		return false
+	}
+	values := make(map[string][]byte)
+	for _, xattr := range a.GetXattrs() {
+		values[xattr.GetName()] = xattr.GetValue()
+	}
+	for _, xattr := range b.GetXattrs() {
+		value, ok := values[xattr.GetName()]
+		if !ok || !bytes.Equal(value, xattr.GetValue()) {
+			return false
+		}
+	}
+	return true
+}
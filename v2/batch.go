@@ -0,0 +1,241 @@
+package v2
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const BATCH_LINK = "link"
+const BATCH_UNLINK = "unlink"
+const BATCH_PUT = "put"
+const BATCH_MKDIR = "mkdir"
+
+var INVALID_BATCH_OP = errors.New("Invalid batch operation")
+
+// A single mutation within a batch.  Key and IsDir are used by BATCH_LINK, and Resource by BATCH_PUT.
+// If Parents is set, any missing directories above Path are created, and BATCH_MKDIR leaves an
+// existing directory as it is.  Attributes, if set, supplies the mode, mtime, symlink target and
+// xattrs of a linked or put file, or of a new directory (see ReadLocalAttributes).  A directory keeps its
+// attributes when entries beneath it change.
+type BatchOp struct {
+	Op         string
+	Path       *Path
+	Key        *Key
+	IsDir      bool
+	Resource   Resource
+	Parents    bool
+	Attributes *FileMetadata
+}
+
+// A directory which is being modified by a batch.  Changes are kept in memory until the batch is
+// committed, at which point each modified directory is written exactly once.
+type batchDir struct {
+	// nil for the top level, whose entries are stored in the RootMap
+	dir Directory
+	// pending changes to entries in this directory.  A nil metadata removes the entry.
+	changes map[string]*FileMetadata
+	// subdirectories which have been descended into
+	children map[string]*batchDir
+}
+
+func newBatchDir(dir Directory) *batchDir {
+	return &batchDir{dir: dir, changes: make(map[string]*FileMetadata), children: make(map[string]*batchDir)}
+}
+
+// must be called with lock held
+func (self *AtomicState) batchLookup(d *batchDir, name string) (*FileMetadata, error) {
+	if metadata, ok := d.changes[name]; ok {
+		return metadata, nil
+	}
+	if d.dir == nil {
+		metadata, _ := self.roots.Get(name)
+		return metadata, nil
+	}
+	return d.dir.Get(name)
+}
+
+// must be called with lock held
+func (self *AtomicState) batchParent(top *batchDir, path *Path, parents bool) (*batchDir, string, error) {
+	if len(path.path) == 0 {
+		return nil, "", NO_SUCH_PATH
+	}
+
+	d := top
+	for _, name := range path.path[:len(path.path)-1] {
+		child, ok := d.children[name]
+		if !ok {
+			metadata, err := self.batchLookup(d, name)
+			if err != nil {
+				return nil, "", err
+			}
+			if metadata == nil && parents {
+				metadata = self.dirMetadata(EMPTY_DIR_KEY, 0)
+				d.set(name, metadata)
+			}
+			if metadata == nil {
+				return nil, "", NO_SUCH_PATH
+			} else if !metadata.GetIsDir() {
+				return nil, "", NOT_A_DIRECTORY
+			}
+			child = newBatchDir(self.dirService.GetDirectory(KeyFromBytes(metadata.GetKey())))
+			d.children[name] = child
+		}
+		d = child
+	}
+
+	return d, path.path[len(path.path)-1], nil
+}
+
+// Records the new version of a directory whose contents changed, keeping the attributes of the version it replaces
+// must be called with lock held
+func (self *AtomicState) unsafeReplaceDir(d *batchDir, name string, metadata *FileMetadata) error {
+	previous, err := self.batchLookup(d, name)
+	if err != nil {
+		return err
+	}
+	copyAttributes(metadata, previous)
+	d.changes[name] = metadata
+	return nil
+}
+
+func (d *batchDir) set(name string, metadata *FileMetadata) {
+	d.changes[name] = metadata
+	// anything pending beneath the old entry is discarded along with it
+	delete(d.children, name)
+}
+
+// must be called with lock held
+func (self *AtomicState) dirMetadata(key *Key, childrenSize int64) *FileMetadata {
+	var length int64
+	if *key != *EMPTY_DIR_KEY {
+		length = self.cache.Get(key).resource.GetLength()
+	}
+	return &FileMetadata{TotalSize: proto.Int64(childrenSize + length), Size: proto.Int64(length), Key: key.AsBytes(), IsDir: proto.Bool(true), CreationTime: proto.Int64(time.Now().Unix())}
+}
+
+// Writes each modified directory beneath d, deepest first, and returns the metadata for the new
+// version of d.  Returns nil if nothing beneath d changed.
+// must be called with lock held
+func (self *AtomicState) flushBatchDir(d *batchDir) (*FileMetadata, error) {
+	for name, child := range d.children {
+		metadata, err := self.flushBatchDir(child)
+		if err != nil {
+			return nil, err
+		}
+		if metadata != nil {
+			err = self.unsafeReplaceDir(d, name, metadata)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(d.changes) == 0 {
+		return nil, nil
+	}
+
+	key, childrenSize, err := d.dir.Update(d.changes)
+	if err != nil {
+		return nil, err
+	}
+	return self.dirMetadata(key, childrenSize), nil
+}
+
+// must be called with lock held
+func (self *AtomicState) unsafeApplyBatchOp(top *batchDir, op *BatchOp) error {
+	parent, name, err := self.batchParent(top, op.Path, op.Parents)
+	if err != nil {
+		return err
+	}
+
+	switch op.Op {
+	case BATCH_UNLINK:
+		parent.set(name, nil)
+		return nil
+	case BATCH_MKDIR:
+		if op.Parents {
+			existing, err := self.batchLookup(parent, name)
+			if err != nil {
+				return err
+			}
+			if existing != nil && existing.GetIsDir() {
+				if op.Attributes != nil {
+					updated := proto.Clone(existing).(*FileMetadata)
+					copyAttributes(updated, op.Attributes)
+					parent.changes[name] = updated
+				}
+				return nil
+			} else if existing != nil {
+				return PATH_EXISTS
+			}
+		}
+		metadata := self.dirMetadata(EMPTY_DIR_KEY, 0)
+		copyAttributes(metadata, op.Attributes)
+		parent.set(name, metadata)
+		return nil
+	case BATCH_PUT:
+		buffer := op.Resource.AsBytes()
+		op.Key = computeContentKey(buffer)
+		if len(buffer) > 0 && int64(len(buffer)) <= self.inlineThreshold && parent != top {
+			length := int64(len(buffer))
+			metadata := &FileMetadata{TotalSize: proto.Int64(length), Size: proto.Int64(length), Key: op.Key.AsBytes(), IsDir: proto.Bool(false), CreationTime: proto.Int64(time.Now().Unix()), Content: buffer}
+			copyAttributes(metadata, op.Attributes)
+			parent.set(name, metadata)
+			return nil
+		}
+		self.cache.Put(op.Key, &cacheEntry{source: LOCAL, resource: op.Resource})
+		op.IsDir = false
+	case BATCH_LINK:
+	default:
+		return INVALID_BATCH_OP
+	}
+
+	// entries at the top level are always directories
+	isDir := op.IsDir || parent == top
+	length, childrenSize, err := self.unsafeGetSizes(op.Key, isDir)
+	if err != nil {
+		return err
+	}
+	metadata := &FileMetadata{TotalSize: proto.Int64(childrenSize + length), Size: proto.Int64(length), Key: op.Key.AsBytes(), IsDir: proto.Bool(isDir), CreationTime: proto.Int64(time.Now().Unix())}
+	copyAttributes(metadata, op.Attributes)
+	parent.set(name, metadata)
+
+	return nil
+}
+
+// Applies all of the operations in order.  Unlike calling Link/Unlink/Put for each, intermediate
+// directories are only modified in memory, and each changed directory is written once at the end.
+// If any operation fails, none of them take effect.  The key of each BATCH_PUT is stored in op.Key.
+func (self *AtomicState) Batch(ops []*BatchOp) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	top := newBatchDir(nil)
+	for _, op := range ops {
+		err := self.unsafeApplyBatchOp(top, op)
+		if err != nil {
+			return err
+		}
+	}
+
+	for name, child := range top.children {
+		metadata, err := self.flushBatchDir(child)
+		if err != nil {
+			return err
+		}
+		if metadata != nil {
+			err = self.unsafeReplaceDir(top, name, metadata)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, metadata := range top.changes {
+		self.roots.Set(name, metadata)
+	}
+
+	return nil
+}
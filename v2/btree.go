@@ -283,6 +283,36 @@ func (d *LeafDir) Remove(name string) (*Key, int64, error) {
 	}
 }
 
+func (d *LeafDir) Update(changes map[string]*FileMetadata) (*Key, int64, error) {
+	leaf, err := d.readLeaf(d.key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// merge the existing entries with the changes, rather than copying the leaf once per change
+	entries := make([]*LeafEntry, 0, len(leaf.entries)+len(changes))
+	for _, entry := range leaf.entries {
+		if _, changed := changes[entry.name]; !changed {
+			entries = append(entries, entry)
+		}
+	}
+	for name, metadata := range changes {
+		if metadata != nil {
+			entries = append(entries, &LeafEntry{name: name, metadata: metadata})
+		}
+	}
+	sort.Sort(byEntryName(entries))
+
+	newLeaf := &Leaf{entries: entries}
+	return d.writeLeaf(newLeaf), newLeaf.GetTotalSize(), nil
+}
+
+type byEntryName []*LeafEntry
+
+func (a byEntryName) Len() int           { return len(a) }
+func (a byEntryName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byEntryName) Less(i, j int) bool { return a[i].name < a[j].name }
+
 type LeafIterator struct {
 	leafIndex  int
 	leaf       *Leaf
@@ -306,11 +336,20 @@ func (it *LeafIterator) Next() (string, *FileMetadata) {
 }
 
 func (d *LeafDir) Iterate() Iterator {
-	leaf, err := d.readLeaf(d.key)
+	it, err := d.CheckedIterate()
 	if err != nil {
 		panic(err.Error())
 	}
-	return &LeafIterator{leafIndex: 0, leaf: leaf, reachedEnd: len(leaf.entries) == 0}
+	return it
+}
+
+// Like Iterate, but returns an error instead of panicking if the directory cannot be read
+func (d *LeafDir) CheckedIterate() (Iterator, error) {
+	leaf, err := d.readLeaf(d.key)
+	if err != nil {
+		return nil, err
+	}
+	return &LeafIterator{leafIndex: 0, leaf: leaf, reachedEnd: len(leaf.entries) == 0}, nil
 }
 
 /*
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"os"
 	"strings"
 	"time"
@@ -26,7 +27,6 @@ const (
 type cacheEntry struct {
 	source   sourceEnum
 	resource Resource
-	error    error
 }
 
 type cacheDB interface {
@@ -40,6 +40,13 @@ type ChunkCache struct {
 	local      cacheDB
 	inProgress map[Key]*Key // a "set" of keys which are currently being fetched from remote
 
+	// how chunks are encoded when pushed to the remote.  One of the COMPRESS_ constants.
+	compression string
+	stats       CompressionStats
+
+	// if set, peers are tried before the remote (see EnablePeers)
+	peers *peerFetcher
+
 	lock sync.Mutex
 	cond *sync.Cond
 }
@@ -49,23 +56,85 @@ func (c *ChunkCache) Dump() {
 }
 
 func NewChunkCache(remote ChunkService, local cacheDB) *ChunkCache {
-	c := &ChunkCache{remote: remote, local: local, inProgress: make(map[Key]*Key)}
+	c := &ChunkCache{remote: remote, local: local, inProgress: make(map[Key]*Key), compression: COMPRESS_NONE}
 	c.cond = sync.NewCond(&c.lock)
 	return c
 }
 
+// Sets the codec used to compress chunks pushed to the remote.  Chunks are always fetched correctly
+// regardless of the codec they were written with.
+func (c *ChunkCache) SetCompression(compression string) error {
+	if _, ok := compressionCodecs[compression]; !ok {
+		return UNKNOWN_COMPRESSION
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.compression = compression
+	return nil
+}
+
+func (c *ChunkCache) GetCompressionStats() CompressionStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.stats
+}
+
 func (c *ChunkCache) PushToRemote(key *Key) error {
 	resource, err := c.Get(key)
 	if err != nil {
 		return err
 	}
-	err = c.remote.Put(key, resource)
-	return err
+
+	c.lock.Lock()
+	compression := c.compression
+	c.lock.Unlock()
+
+	encoded, err := EncodeChunk(resource, compression)
+	if err != nil {
+		return err
+	}
+	err = c.remote.Put(key, encoded)
+	if err != nil {
+		return RemoteUnavailable(err)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.stats.Chunks++
+	c.stats.RawBytes += resource.GetLength()
+	c.stats.StoredBytes += encoded.GetLength()
+	if encoded.GetLength() < resource.GetLength() {
+		c.stats.CompressedChunks++
+	}
+
+	return nil
+}
+
+// Returns whether each of keys is already on the remote.  Keys are reported as missing if the remote cannot
+// check without fetching them.
+func (c *ChunkCache) RemoteHasMany(keys []*Key) ([]bool, error) {
+	return hasManyOrNone(c.remote, keys)
+}
+
+// Makes everything pushed by PushToRemote readable from the remote, for remotes which buffer chunks
+func (c *ChunkCache) FlushRemote() error {
+	if remote, ok := c.remote.(FlushableChunkService); ok {
+		return remote.Flush()
+	}
+	return nil
 }
 
 func (c *ChunkCache) Put(key *Key, resource Resource) error {
 	// TODO: local.Put should return an error
 	c.local.Put(key, &cacheEntry{source: LOCAL, resource: resource})
+
+	c.lock.Lock()
+	c.unsafeRecordCached(key)
+	c.lock.Unlock()
+
 	return nil
 }
 
@@ -74,36 +143,73 @@ func (c *ChunkCache) isKeyBeingFetched(key *Key) bool {
 	return keyInProgress
 }
 
+// A chunk which could not be fetched is not recorded in the local cache, so the next Get tries again.
 func (c *ChunkCache) Get(key *Key) (Resource, error) {
+	c.lock.Lock()
+	for {
+		entry := c.local.Get(key)
+		if entry != nil {
+			c.lock.Unlock()
+			return entry.resource, nil
+		}
+		if !c.isKeyBeingFetched(key) {
+			break
+		}
+		// if the fetch in progress fails, this goroutine makes its own attempt
+		c.cond.Wait()
+	}
+	c.inProgress[*key] = key
+	peers := c.peers
+	c.lock.Unlock()
+
+	// other Gets of this key wait for the fetch, but the lock is not held so the rest of the cache stays usable
+	resource, err := c.fetchRemote(peers, key)
+	if err == nil {
+		c.local.Put(key, &cacheEntry{source: REMOTE, resource: resource})
+	}
+
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	delete(c.inProgress, *key)
+	c.cond.Broadcast()
+	if err != nil {
+		return nil, err
+	}
+	c.unsafeRecordCached(key)
+	return resource, nil
+}
 
-	var err error
-	var resource Resource
+// must be called without the lock held, as it may wait on peers and the remote
+func (c *ChunkCache) fetchRemote(peers *peerFetcher, key *Key) (Resource, error) {
+	if peers != nil {
+		resource, err := peers.fetch(key)
+		if err == nil {
+			return resource, nil
+		} else if err != NO_SUCH_CHUNK {
+			log.Printf("Could not find peers for %s: %s", key, err)
+		}
+	}
 
-	entry := c.local.Get(key)
-	if entry == nil {
-		if c.isKeyBeingFetched(key) {
-			for c.isKeyBeingFetched(key) {
-				c.cond.Wait()
-			}
-			resource = c.local.Get(key).resource
-		} else {
-			c.inProgress[*key] = key
-			resource, err = c.remote.Get(key)
-			if err == nil {
-				c.local.Put(key, &cacheEntry{source: REMOTE, resource: resource})
-			} else {
-				c.local.Put(key, &cacheEntry{source: REMOTE, error: err})
-			}
-			delete(c.inProgress, *key)
-			c.cond.Broadcast()
+	encoded, err := c.remote.Get(key)
+	if err != nil {
+		return nil, RemoteUnavailable(err)
+	}
+
+	// decoded chunks are written where the cache can take them over without copying
+	var getDestFn func() string
+	if allocator, ok := c.local.(interface {
+		AllocateTempFilename() string
+	}); ok {
+		getDestFn = allocator.AllocateTempFilename
+	}
+	decoded, err := DecodeChunk(encoded, getDestFn)
+	if decoded != encoded {
+		// the encoded chunk is no longer needed once it has been decoded, or if it turned out to be corrupt
+		if downloaded, ok := encoded.(*FilesystemResource); ok {
+			os.Remove(downloaded.filename)
 		}
-	} else {
-		resource = entry.resource
-		err = entry.error
 	}
-	return resource, err
+	return decoded, err
 }
 
 type memcacheDB struct {
@@ -175,6 +281,34 @@ type FilesystemResource struct {
 	length   int64
 }
 
+// Reads all of r into a file allocated by getDestFn, or into memory if getDestFn is nil.  If r fails part way
+// through, the error is returned and the file is removed.
+func readIntoResource(r io.Reader, getDestFn func() string) (Resource, error) {
+	if getDestFn == nil {
+		buffer, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return NewMemResource(buffer), nil
+	}
+
+	destFile := getDestFn()
+	w, err := os.Create(destFile)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(w, r)
+	closeErr := w.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(destFile)
+		return nil, err
+	}
+	return NewFileResource(destFile)
+}
+
 func NewFileResource(filename string) (*FilesystemResource, error) {
 	s, err := os.Stat(filename)
 	if err != nil {
@@ -268,6 +402,25 @@ func (c *filesystemCacheDB) Get(key *Key) *cacheEntry {
 	return &entry
 }
 
+// Returns every key in the cache
+func (c *filesystemCacheDB) Keys() []*Key {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	keys := make([]*Key, 0, 1000)
+	err := c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(KEY_TO_FILENAME).ForEach(func(k, v []byte) error {
+			keys = append(keys, KeyFromBytes(k))
+			return nil
+		})
+	})
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return keys
+}
+
 func (c *filesystemCacheDB) Dump() {
 	fmt.Printf("-------------\n")
 	fmt.Printf("Dumping cache\n")
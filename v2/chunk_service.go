@@ -19,7 +19,7 @@ func (c *MemChunkService) Get(key *Key) (Resource, error) {
 
 	resource := c.chunks[*key]
 	if resource == nil {
-		panic(fmt.Sprintf("Could not find key: %s", key))
+		return nil, NO_SUCH_CHUNK
 	}
 
 	return resource, nil
@@ -33,6 +33,40 @@ func (c *MemChunkService) Put(key *Key, resource Resource) error {
 
 	return nil
 }
+func (c *MemChunkService) Has(key *Key) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, found := c.chunks[*key]
+	return found, nil
+}
+
+func (c *MemChunkService) HasMany(keys []*Key) ([]bool, error) {
+	return hasEach(c.Has, keys)
+}
+
+// implements HasMany for services which can only check one key at a time
+func hasEach(has func(key *Key) (bool, error), keys []*Key) ([]bool, error) {
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		var err error
+		found[i], err = has(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// Checks which of keys service holds.  If service cannot check without fetching the chunks, every key is
+// reported as missing, so that callers fall back to writing them.
+func hasManyOrNone(service ChunkService, keys []*Key) ([]bool, error) {
+	if checkable, ok := service.(CheckableChunkService); ok {
+		return checkable.HasMany(keys)
+	}
+	return make([]bool, len(keys)), nil
+}
+
 func NewMemChunkService() *MemChunkService {
 	return &MemChunkService{
 		chunks: make(map[Key]Resource)}
@@ -0,0 +1,288 @@
+package chunkd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/pgm/pliant/v2"
+	. "gopkg.in/check.v1"
+)
+
+type ChunkdSuite struct {
+	store  *DirChunkService
+	server *httptest.Server
+	client *Client
+}
+
+var _ = Suite(&ChunkdSuite{})
+
+func Test(t *testing.T) { TestingT(t) }
+
+func tempFileFn(c *C) func() string {
+	dir := c.MkDir()
+	return func() string {
+		f, err := ioutil.TempFile(dir, "get")
+		if err != nil {
+			panic(err.Error())
+		}
+		f.Close()
+		return f.Name()
+	}
+}
+
+func contentKey(data []byte) *v2.Key {
+	hash := sha256.Sum256(data)
+	return v2.KeyFromBytes(hash[:])
+}
+
+func (s *ChunkdSuite) SetUpTest(c *C) {
+	var err error
+	s.store, err = NewDirChunkService(c.MkDir())
+	c.Assert(err, IsNil)
+	s.server = httptest.NewServer(NewServer(s.store, c.MkDir(), []byte("secret")))
+	s.client = NewClient(s.server.URL, []byte("secret"), tempFileFn(c))
+}
+
+func (s *ChunkdSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *ChunkdSuite) TestRoundTrip(c *C) {
+	data := []byte("chunk data")
+	key := contentKey(data)
+
+	_, err := s.client.Get(key)
+	c.Assert(err, Equals, v2.NO_SUCH_CHUNK)
+
+	c.Assert(s.client.Put(key, v2.NewMemResource(data)), IsNil)
+	resource, err := s.client.Get(key)
+	c.Assert(err, IsNil)
+	c.Assert(resource.GetLength(), Equals, int64(len(data)))
+	c.Assert(resource.AsBytes(), DeepEquals, data)
+
+	stored, err := s.store.Get(key)
+	c.Assert(err, IsNil)
+	c.Assert(stored.AsBytes(), DeepEquals, data)
+
+	s.client.Delete(key)
+	_, err = s.client.Get(key)
+	c.Assert(err, Equals, v2.NO_SUCH_CHUNK)
+}
+
+func (s *ChunkdSuite) TestHead(c *C) {
+	data := []byte("chunk data")
+	key := contentKey(data)
+
+	resp, err := s.client.send("HEAD", s.client.chunkURL(key), nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusNotFound)
+
+	s.client.Put(key, v2.NewMemResource(data))
+	resp, err = s.client.send("HEAD", s.client.chunkURL(key), nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(resp.ContentLength, Equals, int64(len(data)))
+}
+
+// counts the chunks read through Get
+type countingStore struct {
+	*DirChunkService
+	gets int
+}
+
+func (s *countingStore) Get(key *v2.Key) (v2.Resource, error) {
+	s.gets++
+	return s.DirChunkService.Get(key)
+}
+
+func (s *ChunkdSuite) TestHeadDoesNotReadChunk(c *C) {
+	data := []byte("chunk data")
+	key := contentKey(data)
+	c.Assert(s.store.Put(key, v2.NewMemResource(data)), IsNil)
+
+	store := &countingStore{DirChunkService: s.store}
+	server := httptest.NewServer(NewServer(store, c.MkDir(), []byte("secret")))
+	defer server.Close()
+	client := NewClient(server.URL, []byte("secret"), tempFileFn(c))
+
+	resp, err := client.send("HEAD", client.chunkURL(key), nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(resp.ContentLength, Equals, int64(len(data)))
+	c.Assert(store.gets, Equals, 0)
+}
+
+func (s *ChunkdSuite) TestIterateAfter(c *C) {
+	keys := make([]*v2.Key, 0)
+	for i := 0; i < 20; i++ {
+		data := []byte(fmt.Sprintf("chunk %d", i))
+		key := contentKey(data)
+		c.Assert(s.store.Put(key, v2.NewMemResource(data)), IsNil)
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i].AsBytes(), keys[j].AsBytes()) < 0 })
+
+	for i, after := range keys {
+		remaining := make([]*v2.Key, 0)
+		for it := s.store.IterateAfter(after); it.HasNext(); {
+			remaining = append(remaining, it.Next())
+		}
+		c.Assert(remaining, DeepEquals, keys[i+1:])
+	}
+}
+
+func (s *ChunkdSuite) TestIteratePagesUnordered(c *C) {
+	store := v2.NewMemChunkService()
+	server := httptest.NewServer(NewServer(store, c.MkDir(), []byte("secret")))
+	defer server.Close()
+	client := NewClient(server.URL, []byte("secret"), tempFileFn(c))
+	client.PageSize = 2
+
+	for i := 0; i < 5; i++ {
+		data := []byte(fmt.Sprintf("chunk %d", i))
+		c.Assert(store.Put(contentKey(data), v2.NewMemResource(data)), IsNil)
+	}
+
+	var previous *v2.Key
+	count := 0
+	for it := client.Iterate(); it.HasNext(); count++ {
+		key := it.Next()
+		if previous != nil {
+			c.Assert(bytes.Compare(previous.AsBytes(), key.AsBytes()) < 0, Equals, true)
+		}
+		previous = key
+	}
+	c.Assert(count, Equals, 5)
+}
+
+func (s *ChunkdSuite) TestHasMany(c *C) {
+	data := []byte("chunk data")
+	key := contentKey(data)
+	missing := contentKey([]byte("missing"))
+	s.client.Put(key, v2.NewMemResource(data))
+
+	has, err := s.client.Has(key)
+	c.Assert(err, IsNil)
+	c.Assert(has, Equals, true)
+	has, err = s.client.Has(missing)
+	c.Assert(err, IsNil)
+	c.Assert(has, Equals, false)
+
+	found, err := s.client.HasMany([]*v2.Key{missing, key, missing})
+	c.Assert(err, IsNil)
+	c.Assert(found, DeepEquals, []bool{false, true, false})
+}
+
+func (s *ChunkdSuite) TestRequiresSecret(c *C) {
+	data := []byte("chunk data")
+	key := contentKey(data)
+	c.Assert(s.client.Put(key, v2.NewMemResource(data)), IsNil)
+
+	other := NewClient(s.server.URL, []byte("wrong"), tempFileFn(c))
+	_, err := other.Get(key)
+	c.Assert(err, NotNil)
+	c.Assert(other.Put(contentKey([]byte("other")), v2.NewMemResource([]byte("other"))), NotNil)
+	other.Delete(key)
+	_, err = other.HasMany([]*v2.Key{key})
+	c.Assert(err, NotNil)
+
+	// a token only authorizes the request it was made for
+	token := authToken([]byte("secret"), "GET", CHUNK_PATH+hex.EncodeToString(key.AsBytes()))
+	for _, method := range []string{"GET", "DELETE"} {
+		req, err := http.NewRequest(method, s.client.chunkURL(key), nil)
+		c.Assert(err, IsNil)
+		if method == "DELETE" {
+			req.Header.Set(AUTH_HEADER, token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusForbidden)
+	}
+
+	stored, err := s.store.Get(key)
+	c.Assert(err, IsNil)
+	c.Assert(stored.AsBytes(), DeepEquals, data)
+	c.Assert(len(fetchKeys(s.store.Iterate())), Equals, 1)
+}
+
+func fetchKeys(it v2.KeyIterator) []*v2.Key {
+	keys := make([]*v2.Key, 0)
+	for it.HasNext() {
+		keys = append(keys, it.Next())
+	}
+	return keys
+}
+
+func (s *ChunkdSuite) TestInvalidKey(c *C) {
+	resp, err := s.client.send("GET", s.server.URL+CHUNK_PATH+"zz", nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusBadRequest)
+}
+
+func (s *ChunkdSuite) TestIteratePages(c *C) {
+	expected := make(map[v2.Key]bool)
+	for i := 0; i < 5; i++ {
+		data := []byte(fmt.Sprintf("chunk %d", i))
+		key := contentKey(data)
+		c.Assert(s.client.Put(key, v2.NewMemResource(data)), IsNil)
+		expected[*key] = true
+	}
+
+	s.client.PageSize = 2
+	var previous *v2.Key
+	count := 0
+	for it := s.client.Iterate(); it.HasNext(); {
+		key := it.Next()
+		c.Assert(expected[*key], Equals, true)
+		if previous != nil {
+			c.Assert(key.String() != previous.String(), Equals, true)
+		}
+		previous = key
+		count++
+	}
+	c.Assert(count, Equals, 5)
+
+	s.client.PageSize = 5
+	it := s.client.Iterate()
+	for count = 0; it.HasNext(); count++ {
+		it.Next()
+	}
+	c.Assert(count, Equals, 5)
+}
+
+func (s *ChunkdSuite) TestPushAndPull(c *C) {
+	tags := v2.NewMemTagService()
+
+	newState := func() *v2.AtomicState {
+		root := c.MkDir()
+		db, err := v2.InitDb(root + "/db.bolt")
+		c.Assert(err, IsNil)
+		cache, _ := v2.NewFilesystemCacheDB(root, db)
+		chunks := v2.NewChunkCache(NewClient(s.server.URL, []byte("secret"), cache.AllocateTempFilename), cache)
+		return v2.NewAtomicState(v2.NewLeafDirService(chunks), chunks, cache, tags, v2.NewMemRootMap())
+	}
+
+	as1 := newState()
+	c.Assert(as1.Link(v2.EMPTY_DIR_KEY, v2.NewPath("a"), true), IsNil)
+	_, err := as1.Put(v2.NewPath("a/b"), v2.NewMemResource([]byte("test")))
+	c.Assert(err, IsNil)
+	metadata, err := as1.GetMetadata(v2.NewPath("a"))
+	c.Assert(err, IsNil)
+	c.Assert(as1.Push(v2.KeyFromBytes(metadata.GetKey()), "tag", nil), IsNil)
+
+	as2 := newState()
+	key, err := as2.Pull("tag", nil)
+	c.Assert(err, IsNil)
+	c.Assert(as2.Link(key, v2.NewPath("z"), true), IsNil)
+	metadata, err = as2.GetMetadata(v2.NewPath("z/b"))
+	c.Assert(err, IsNil)
+	c.Assert(as2.GetFileResource(metadata).AsBytes(), DeepEquals, []byte("test"))
+}
@@ -0,0 +1,258 @@
+package chunkd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pgm/pliant/v2"
+)
+
+// A ChunkService which reads and writes the chunks held by a chunkd Server.  Chunks are downloaded to
+// files allocated by GetDestFn.
+type Client struct {
+	// ie: "http://host:port"
+	BaseURL   string
+	GetDestFn func() string
+	// number of keys requested per page by Iterate
+	PageSize int
+	// the secret the Server was created with
+	Secret []byte
+
+	client *http.Client
+}
+
+func NewClient(baseURL string, secret []byte, getDestFn func() string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), GetDestFn: getDestFn, PageSize: DEFAULT_PAGE_SIZE, Secret: secret, client: &http.Client{}}
+}
+
+// sends req with the header which authenticates it to the server
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set(AUTH_HEADER, authToken(c.Secret, req.Method, req.URL.RequestURI()))
+	return c.client.Do(req)
+}
+
+func (c *Client) send(method string, url string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req)
+}
+
+func (c *Client) chunkURL(key *v2.Key) string {
+	return c.BaseURL + CHUNK_PATH + hex.EncodeToString(key.AsBytes())
+}
+
+func statusError(method string, resp *http.Response) error {
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s %s failed with status %d: %s", method, resp.Request.URL, resp.StatusCode, strings.TrimSpace(string(message)))
+}
+
+func (c *Client) Get(key *v2.Key) (v2.Resource, error) {
+	resp, err := c.send("GET", c.chunkURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, v2.NO_SUCH_CHUNK
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, statusError("GET", resp)
+	}
+
+	destFile := c.GetDestFn()
+	w, err := os.Create(destFile)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(w, resp.Body)
+	closeErr := w.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(destFile)
+		return nil, err
+	}
+
+	return v2.NewFileResource(destFile)
+}
+
+func (c *Client) Put(key *v2.Key, resource v2.Resource) error {
+	r := resource.GetReader()
+	body, ok := r.(io.ReadCloser)
+	if !ok {
+		body = ioutil.NopCloser(r)
+	}
+
+	req, err := http.NewRequest("PUT", c.chunkURL(key), body)
+	if err != nil {
+		body.Close()
+		return err
+	}
+	req.ContentLength = resource.GetLength()
+
+	// the request body is closed by Do
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return statusError("PUT", resp)
+	}
+	return nil
+}
+
+func (c *Client) Has(key *v2.Key) (bool, error) {
+	resp, err := c.send("HEAD", c.chunkURL(key), nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return false, statusError("HEAD", resp)
+	}
+	return true, nil
+}
+
+// Checks the keys with one request per MAX_PAGE_SIZE keys
+func (c *Client) HasMany(keys []*v2.Key) ([]bool, error) {
+	found := make([]bool, 0, len(keys))
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > MAX_PAGE_SIZE {
+			batch = batch[:MAX_PAGE_SIZE]
+		}
+		keys = keys[len(batch):]
+
+		request := &HasRequest{Keys: make([]string, len(batch))}
+		for i, key := range batch {
+			request.Keys[i] = hex.EncodeToString(key.AsBytes())
+		}
+		body, err := json.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequest("POST", c.BaseURL+CHUNK_PATH, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+		response := &HasResponse{}
+		if resp.StatusCode != http.StatusOK {
+			err = statusError("POST", resp)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(response)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(response.Found) != len(batch) {
+			return nil, fmt.Errorf("POST %s returned %d results for %d keys", c.BaseURL+CHUNK_PATH, len(response.Found), len(batch))
+		}
+		found = append(found, response.Found...)
+	}
+	return found, nil
+}
+
+func (c *Client) Delete(key *v2.Key) {
+	req, err := http.NewRequest("DELETE", c.chunkURL(key), nil)
+	if err != nil {
+		panic(err.Error())
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		log.Printf("Could not delete %s: %s", key, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		log.Printf("Could not delete %s: %s", key, statusError("DELETE", resp))
+	}
+}
+
+func (c *Client) listPage(after string) (*ListPage, error) {
+	query := url.Values{}
+	query.Set("limit", fmt.Sprintf("%d", c.PageSize))
+	if after != "" {
+		query.Set("after", after)
+	}
+
+	resp, err := c.send("GET", c.BaseURL+CHUNK_PATH+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError("GET", resp)
+	}
+
+	page := &ListPage{}
+	err = json.NewDecoder(resp.Body).Decode(page)
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// Fetches one page of keys at a time.  Like the other KeyIterators, a failure to fetch a page panics.
+type clientKeyIterator struct {
+	client *Client
+	keys   []string
+	next   string
+}
+
+func (it *clientKeyIterator) fetchNext(after string) {
+	page, err := it.client.listPage(after)
+	if err != nil {
+		panic(err.Error())
+	}
+	it.keys = page.Keys
+	it.next = page.Next
+}
+
+func (it *clientKeyIterator) HasNext() bool {
+	return len(it.keys) > 0
+}
+
+func (it *clientKeyIterator) Next() *v2.Key {
+	key, err := parseKey(it.keys[0])
+	if err != nil {
+		panic(err.Error())
+	}
+	it.keys = it.keys[1:]
+
+	if len(it.keys) == 0 && it.next != "" {
+		it.fetchNext(it.next)
+	}
+	return key
+}
+
+func (c *Client) Iterate() v2.KeyIterator {
+	it := &clientKeyIterator{client: c}
+	it.fetchNext("")
+	return it
+}
@@ -0,0 +1,327 @@
+package chunkd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pgm/pliant/v2"
+)
+
+// Chunks are read and written at CHUNK_PATH followed by the hex encoded key.  A GET of CHUNK_PATH itself
+// lists the keys, in order, as a ListPage.  The query parameters "after" (a hex encoded key) and "limit"
+// select the page.  A POST of a HasRequest to CHUNK_PATH checks which of up to MAX_PAGE_SIZE keys exist.
+const CHUNK_PATH = "/chunks/"
+
+// Every request must carry this header, set to authToken of the secret shared by the server and its clients
+const AUTH_HEADER = "X-Pliant-Chunkd-Auth"
+
+var DEFAULT_PAGE_SIZE = 1000
+
+// the largest page the server will return, whatever limit was requested
+var MAX_PAGE_SIZE = 10000
+
+type ListPage struct {
+	Keys []string
+	// if non-empty, pass as "after" to get the next page
+	Next string
+}
+
+type HasRequest struct {
+	Keys []string
+}
+
+type HasResponse struct {
+	// whether each of the requested keys exists, in the same order
+	Found []bool
+}
+
+// Implemented by stores which can report the size of a chunk without reading it, such as DirChunkService.
+// HEAD requests use it when available.
+type SizedChunkService interface {
+	GetLength(key *v2.Key) (int64, error)
+}
+
+// Implemented by stores which can list their keys in sorted order starting after a given key, such as
+// DirChunkService.  Listing a page then only reads as far as the end of that page.
+type OrderedChunkService interface {
+	IterateAfter(after *v2.Key) v2.KeyIterator
+}
+
+// Serves the chunks in an IterableChunkService over HTTP.  Put must have copied the content of the
+// resource it is given by the time it returns, because the uploaded file is removed afterwards.
+// Requests are authenticated with a shared secret, but neither they nor the chunks are encrypted.
+type Server struct {
+	chunks v2.IterableChunkService
+	// uploads are written here before being passed to chunks.Put
+	tempDir string
+	secret  []byte
+}
+
+func NewServer(chunks v2.IterableChunkService, tempDir string, secret []byte) *Server {
+	return &Server{chunks: chunks, tempDir: tempDir, secret: secret}
+}
+
+// An HMAC of the method and the request URI (path and query), so a token only authorizes the one request
+// it was made for.
+func authToken(secret []byte, method string, requestURI string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + " " + requestURI))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func parseKey(s string) (*v2.Key, error) {
+	keyBytes, err := hex.DecodeString(s)
+	if err != nil || len(keyBytes) != len(v2.Key{}) {
+		return nil, fmt.Errorf("invalid key %q", s)
+	}
+	return v2.KeyFromBytes(keyBytes), nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, CHUNK_PATH) {
+		http.NotFound(w, r)
+		return
+	}
+
+	expected := authToken(s.secret, r.Method, r.URL.RequestURI())
+	if !hmac.Equal([]byte(r.Header.Get(AUTH_HEADER)), []byte(expected)) {
+		http.Error(w, "not authorized", http.StatusForbidden)
+		return
+	}
+
+	name := r.URL.Path[len(CHUNK_PATH):]
+	if name == "" {
+		switch r.Method {
+		case "GET":
+			s.list(w, r)
+		case "POST":
+			s.hasMany(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	key, err := parseKey(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		s.get(w, r, key)
+	case "HEAD":
+		s.head(w, r, key)
+	case "PUT":
+		s.put(w, r, key)
+	case "DELETE":
+		deleter, ok := s.chunks.(interface {
+			Delete(key *v2.Key)
+		})
+		if !ok {
+			http.Error(w, "chunks cannot be deleted from this store", http.StatusMethodNotAllowed)
+			return
+		}
+		deleter.Delete(key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, key *v2.Key) {
+	resource, err := s.chunks.Get(key)
+	if err == v2.NO_SUCH_CHUNK {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", resource.GetLength()))
+	reader := resource.GetReader()
+	if rCloser, ok := reader.(io.Closer); ok {
+		defer rCloser.Close()
+	}
+	io.Copy(w, reader)
+}
+
+func (s *Server) head(w http.ResponseWriter, r *http.Request, key *v2.Key) {
+	sized, ok := s.chunks.(SizedChunkService)
+	if !ok {
+		// without a cheaper way to find the length, fetch the chunk and discard the body
+		s.get(w, r, key)
+		return
+	}
+
+	length, err := sized.GetLength(key)
+	if err == v2.NO_SUCH_CHUNK {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", length))
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, key *v2.Key) {
+	f, err := ioutil.TempFile(s.tempDir, "upload")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r.Body)
+	closeErr := f.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if closeErr != nil {
+		http.Error(w, closeErr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resource, err := v2.NewFileResource(f.Name())
+	if err == nil {
+		err = s.chunks.Put(key, resource)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) hasMany(w http.ResponseWriter, r *http.Request) {
+	request := &HasRequest{}
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(request.Keys) > MAX_PAGE_SIZE {
+		http.Error(w, "too many keys", http.StatusBadRequest)
+		return
+	}
+
+	keys := make([]*v2.Key, len(request.Keys))
+	for i, name := range request.Keys {
+		keys[i], err = parseKey(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	response := &HasResponse{}
+	if checkable, ok := s.chunks.(v2.CheckableChunkService); ok {
+		response.Found, err = checkable.HasMany(keys)
+	} else {
+		response.Found = make([]bool, len(keys))
+		for i, key := range keys {
+			_, err = s.chunks.Get(key)
+			response.Found[i] = err == nil
+			if err == v2.NO_SUCH_CHUNK {
+				err = nil
+			} else if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	limit := DEFAULT_PAGE_SIZE
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if limit > MAX_PAGE_SIZE {
+		limit = MAX_PAGE_SIZE
+	}
+
+	var after *v2.Key
+	if value := r.URL.Query().Get("after"); value != "" {
+		var err error
+		after, err = parseKey(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var it v2.KeyIterator
+	if ordered, ok := s.chunks.(OrderedChunkService); ok {
+		it = ordered.IterateAfter(after)
+	} else {
+		it = sortedKeysAfter(s.chunks.Iterate(), after)
+	}
+
+	// read one key past the page to find out whether there is a next page
+	page := &ListPage{Keys: make([]string, 0, limit)}
+	for it.HasNext() {
+		key := it.Next()
+		if len(page.Keys) == limit {
+			page.Next = page.Keys[len(page.Keys)-1]
+			break
+		}
+		page.Keys = append(page.Keys, hex.EncodeToString(key.AsBytes()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// The wrapped service makes no promise about the order of its keys, so read them all and sort them to
+// make pages stable.
+func sortedKeysAfter(it v2.KeyIterator, after *v2.Key) v2.KeyIterator {
+	keys := make([]*v2.Key, 0)
+	for it.HasNext() {
+		key := it.Next()
+		if after == nil || bytes.Compare(key.AsBytes(), after.AsBytes()) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i].AsBytes(), keys[j].AsBytes()) < 0 })
+	return &keyListIterator{keys: keys}
+}
+
+type keyListIterator struct {
+	keys []*v2.Key
+}
+
+func (it *keyListIterator) HasNext() bool {
+	return len(it.keys) > 0
+}
+
+func (it *keyListIterator) Next() *v2.Key {
+	key := it.keys[0]
+	it.keys = it.keys[1:]
+	return key
+}
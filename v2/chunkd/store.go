@@ -0,0 +1,182 @@
+package chunkd
+
+import (
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pgm/pliant/v2"
+)
+
+// Stores each chunk as a file named by the hex encoded key, in a subdirectory named by the first two
+// characters of the key so that no single directory grows too large.
+type DirChunkService struct {
+	root string
+}
+
+func NewDirChunkService(root string) (*DirChunkService, error) {
+	err := os.MkdirAll(filepath.Join(root, "tmp"), 0770)
+	if err != nil {
+		return nil, err
+	}
+	return &DirChunkService{root: root}, nil
+}
+
+func (d *DirChunkService) path(key *v2.Key) string {
+	name := hex.EncodeToString(key.AsBytes())
+	return filepath.Join(d.root, name[:2], name)
+}
+
+// The returned resource reads the stored file directly, so it must not be removed by the caller.
+func (d *DirChunkService) Get(key *v2.Key) (v2.Resource, error) {
+	resource, err := v2.NewFileResource(d.path(key))
+	if os.IsNotExist(err) {
+		return nil, v2.NO_SUCH_CHUNK
+	} else if err != nil {
+		return nil, err
+	}
+	return resource, nil
+}
+
+// Writes the chunk to a temporary file and renames it into place, so a partially written chunk is
+// never visible to Get.
+func (d *DirChunkService) Put(key *v2.Key, resource v2.Resource) error {
+	dest := d.path(key)
+	err := os.MkdirAll(filepath.Dir(dest), 0770)
+	if err != nil {
+		return err
+	}
+
+	w, err := ioutil.TempFile(filepath.Join(d.root, "tmp"), "put")
+	if err != nil {
+		return err
+	}
+
+	r := resource.GetReader()
+	_, err = io.Copy(w, r)
+	if rCloser, ok := r.(io.Closer); ok {
+		rCloser.Close()
+	}
+	closeErr := w.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(w.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(w.Name())
+	}
+	return err
+}
+
+func (d *DirChunkService) Has(key *v2.Key) (bool, error) {
+	_, err := os.Stat(d.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *DirChunkService) HasMany(keys []*v2.Key) ([]bool, error) {
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		var err error
+		found[i], err = d.Has(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+func (d *DirChunkService) Delete(key *v2.Key) {
+	os.Remove(d.path(key))
+}
+
+// Returns the size of the stored chunk without opening it
+func (d *DirChunkService) GetLength(key *v2.Key) (int64, error) {
+	info, err := os.Stat(d.path(key))
+	if os.IsNotExist(err) {
+		return 0, v2.NO_SUCH_CHUNK
+	} else if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Returns the keys in sorted order, because both the subdirectories and the files within them are
+// listed in order of their names.
+func (d *DirChunkService) Iterate() v2.KeyIterator {
+	return d.IterateAfter(nil)
+}
+
+// Like Iterate, but only returns the keys which sort after the given key.  Subdirectories are read one at a
+// time as the iterator advances, and those which only hold earlier keys are never read.
+func (d *DirChunkService) IterateAfter(after *v2.Key) v2.KeyIterator {
+	afterName := ""
+	if after != nil {
+		afterName = hex.EncodeToString(after.AsBytes())
+	}
+
+	entries, err := ioutil.ReadDir(d.root)
+	if err != nil {
+		panic(err.Error())
+	}
+	dirs := make([]string, 0, len(entries))
+	for _, dir := range entries {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		if after != nil && dir.Name() < afterName[:2] {
+			continue
+		}
+		dirs = append(dirs, dir.Name())
+	}
+	return &dirKeyIterator{root: d.root, dirs: dirs, afterName: afterName}
+}
+
+type dirKeyIterator struct {
+	root string
+	// the subdirectories which have not been read yet
+	dirs []string
+	// the keys read from the current subdirectory which have not been returned yet
+	keys      []*v2.Key
+	afterName string
+}
+
+func (it *dirKeyIterator) fill() {
+	for len(it.keys) == 0 && len(it.dirs) > 0 {
+		files, err := ioutil.ReadDir(filepath.Join(it.root, it.dirs[0]))
+		if err != nil {
+			panic(err.Error())
+		}
+		it.dirs = it.dirs[1:]
+		for _, file := range files {
+			if file.Name() <= it.afterName {
+				continue
+			}
+			keyBytes, err := hex.DecodeString(file.Name())
+			if err != nil || len(keyBytes) != len(v2.Key{}) {
+				continue
+			}
+			it.keys = append(it.keys, v2.KeyFromBytes(keyBytes))
+		}
+	}
+}
+
+func (it *dirKeyIterator) HasNext() bool {
+	it.fill()
+	return len(it.keys) > 0
+}
+
+func (it *dirKeyIterator) Next() *v2.Key {
+	it.fill()
+	key := it.keys[0]
+	it.keys = it.keys[1:]
+	return key
+}
@@ -0,0 +1,215 @@
+package v2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const COMPRESS_NONE = "none"
+const COMPRESS_GZIP = "gzip"
+const COMPRESS_ZSTD = "zstd"
+
+var UNKNOWN_COMPRESSION = errors.New("Unknown compression")
+
+// chunks larger than this are stored uncompressed because they are compressed in memory
+var MAX_COMPRESSED_CHUNK_SIZE int64 = 64 * 1024 * 1024
+
+// Encoded chunks start with CHUNK_MAGIC, followed by a byte identifying the codec and the length of the
+// uncompressed content as a big-endian uint64.  Chunks without the header are stored as they are.
+const CHUNK_MAGIC = "\x89PLZ"
+const CHUNK_HEADER_SIZE = len(CHUNK_MAGIC) + 1 + 8
+
+const (
+	codecNone byte = iota
+	codecGzip
+	codecZstd
+)
+
+var compressionCodecs = map[string]byte{COMPRESS_NONE: codecNone, COMPRESS_GZIP: codecGzip, COMPRESS_ZSTD: codecZstd}
+
+func chunkHeader(codec byte, length int64) []byte {
+	header := make([]byte, CHUNK_HEADER_SIZE)
+	copy(header, CHUNK_MAGIC)
+	header[len(CHUNK_MAGIC)] = codec
+	binary.BigEndian.PutUint64(header[len(CHUNK_MAGIC)+1:], uint64(length))
+	return header
+}
+
+func closeReader(r io.Reader) {
+	if rCloser, ok := r.(io.Closer); ok {
+		rCloser.Close()
+	}
+}
+
+// reads the first n bytes of the resource, or fewer if it is shorter
+func readPrefix(resource Resource, n int) []byte {
+	r := resource.GetReader()
+	defer closeReader(r)
+
+	prefix := make([]byte, n)
+	read, _ := io.ReadFull(r, prefix)
+	return prefix[:read]
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error {
+	return r.close()
+}
+
+// the header followed by the unmodified content of raw
+type encodedResource struct {
+	header []byte
+	raw    Resource
+}
+
+func (r *encodedResource) GetLength() int64 {
+	return int64(len(r.header)) + r.raw.GetLength()
+}
+
+func (r *encodedResource) GetReader() io.Reader {
+	raw := r.raw.GetReader()
+	return &readCloser{Reader: io.MultiReader(bytes.NewReader(r.header), raw), close: func() error {
+		closeReader(raw)
+		return nil
+	}}
+}
+
+func (r *encodedResource) AsBytes() []byte {
+	return append(append([]byte{}, r.header...), r.raw.AsBytes()...)
+}
+
+// Encodes a chunk for storing on the remote.  The chunk is compressed with the named codec if that makes
+// it smaller, otherwise it is stored uncompressed.  With COMPRESS_NONE the chunk is returned as is,
+// unless its content could be mistaken for a header.
+func EncodeChunk(resource Resource, compression string) (Resource, error) {
+	codec, ok := compressionCodecs[compression]
+	if !ok {
+		return nil, UNKNOWN_COMPRESSION
+	}
+
+	length := resource.GetLength()
+	if codec != codecNone && length <= MAX_COMPRESSED_CHUNK_SIZE {
+		var buffer bytes.Buffer
+		buffer.Write(chunkHeader(codec, length))
+
+		var w io.WriteCloser
+		var err error
+		if codec == codecGzip {
+			w = gzip.NewWriter(&buffer)
+		} else {
+			w, err = zstd.NewWriter(&buffer)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		r := resource.GetReader()
+		_, err = io.Copy(w, r)
+		closeReader(r)
+		if err != nil {
+			return nil, err
+		}
+		err = w.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if int64(buffer.Len()) < length+int64(CHUNK_HEADER_SIZE) {
+			return NewMemResource(buffer.Bytes()), nil
+		}
+	}
+
+	if codec == codecNone && !bytes.Equal(readPrefix(resource, len(CHUNK_MAGIC)), []byte(CHUNK_MAGIC)) {
+		return resource, nil
+	}
+	return &encodedResource{header: chunkHeader(codecNone, length), raw: resource}, nil
+}
+
+// Returns the original content of a chunk written by EncodeChunk.  Chunks without a header are returned as
+// they are.  Otherwise the content is decoded into a file allocated by getDestFn, or into memory if getDestFn is
+// nil, and an ERR_CORRUPT error is returned if it cannot be decoded or is not the length given in the header.
+func DecodeChunk(resource Resource, getDestFn func() string) (Resource, error) {
+	header := readPrefix(resource, CHUNK_HEADER_SIZE)
+	if len(header) < CHUNK_HEADER_SIZE || !bytes.Equal(header[:len(CHUNK_MAGIC)], []byte(CHUNK_MAGIC)) {
+		return resource, nil
+	}
+
+	codec := header[len(CHUNK_MAGIC)]
+	if codec != codecNone && codec != codecGzip && codec != codecZstd {
+		return nil, UNKNOWN_COMPRESSION
+	}
+	length := int64(binary.BigEndian.Uint64(header[len(CHUNK_MAGIC)+1:]))
+
+	encoded := resource.GetReader()
+	defer closeReader(encoded)
+	_, err := io.CopyN(ioutil.Discard, encoded, int64(CHUNK_HEADER_SIZE))
+	if err != nil {
+		return nil, Errorf(ERR_CORRUPT, "Could not read chunk header: %s", err)
+	}
+
+	var content io.Reader = encoded
+	switch codec {
+	case codecGzip:
+		gz, err := gzip.NewReader(encoded)
+		if err != nil {
+			return nil, Errorf(ERR_CORRUPT, "Could not decode chunk: %s", err)
+		}
+		defer gz.Close()
+		content = gz
+	case codecZstd:
+		zr, err := zstd.NewReader(encoded)
+		if err != nil {
+			return nil, Errorf(ERR_CORRUPT, "Could not decode chunk: %s", err)
+		}
+		defer zr.Close()
+		content = zr
+	}
+
+	// reading one byte past the expected length is enough to tell that the chunk is too long
+	decoded, err := readIntoResource(io.LimitReader(content, length+1), getDestFn)
+	if err != nil {
+		return nil, Errorf(ERR_CORRUPT, "Could not decode chunk: %s", err)
+	}
+	if decoded.GetLength() != length {
+		if file, ok := decoded.(*FilesystemResource); ok {
+			os.Remove(file.filename)
+		}
+		return nil, Errorf(ERR_CORRUPT, "Decoded chunk is %d bytes rather than %d", decoded.GetLength(), length)
+	}
+	return decoded, nil
+}
+
+// Counts of the chunks pushed to the remote, and how much space they took up once encoded
+type CompressionStats struct {
+	Chunks           int64
+	CompressedChunks int64
+	// total size of the chunks before and after encoding
+	RawBytes    int64
+	StoredBytes int64
+}
+
+// Wraps a chunk service whose chunks were written by EncodeChunk so that Get returns the original content.
+// Used by things which read chunks from the remote without going through a ChunkCache (ie: GC).  Chunks are
+// decoded into memory.
+type DecodingChunkService struct {
+	IterableChunkService
+}
+
+func (s *DecodingChunkService) Get(key *Key) (Resource, error) {
+	resource, err := s.IterableChunkService.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeChunk(resource, nil)
+}
@@ -0,0 +1,127 @@
+package v2
+
+import (
+	"bytes"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type CompressSuite struct{}
+
+var _ = Suite(&CompressSuite{})
+
+var compressible = []byte(strings.Repeat("chr1\t12345\tA\tG\t.\tPASS\n", 200))
+
+func (*CompressSuite) TestEncodeDecode(c *C) {
+	for _, compression := range []string{COMPRESS_GZIP, COMPRESS_ZSTD} {
+		encoded, err := EncodeChunk(NewMemResource(compressible), compression)
+		c.Assert(err, IsNil)
+		c.Assert(encoded.GetLength() < int64(len(compressible)), Equals, true)
+
+		decoded, err := DecodeChunk(NewMemResource(encoded.AsBytes()), nil)
+		c.Assert(err, IsNil)
+		c.Assert(decoded.GetLength(), Equals, int64(len(compressible)))
+		c.Assert(decoded.AsBytes(), DeepEquals, compressible)
+	}
+}
+
+func (*CompressSuite) TestIncompressibleStoredRaw(c *C) {
+	data := []byte("x")
+	encoded, err := EncodeChunk(NewMemResource(data), COMPRESS_ZSTD)
+	c.Assert(err, IsNil)
+	c.Assert(encoded.GetLength(), Equals, int64(len(data)+CHUNK_HEADER_SIZE))
+
+	decoded, err := DecodeChunk(NewMemResource(encoded.AsBytes()), nil)
+	c.Assert(err, IsNil)
+	c.Assert(decoded.AsBytes(), DeepEquals, data)
+}
+
+func (*CompressSuite) TestUncompressedPassthrough(c *C) {
+	// chunks written without compression are left as they are
+	resource := NewMemResource([]byte("plain"))
+	encoded, err := EncodeChunk(resource, COMPRESS_NONE)
+	c.Assert(err, IsNil)
+	c.Assert(encoded, Equals, resource)
+	decoded, err := DecodeChunk(resource, nil)
+	c.Assert(err, IsNil)
+	c.Assert(decoded, Equals, resource)
+
+	// unless they would be mistaken for an encoded chunk
+	lookalike := append([]byte(CHUNK_MAGIC), bytes.Repeat([]byte{1}, 20)...)
+	encoded, err = EncodeChunk(NewMemResource(lookalike), COMPRESS_NONE)
+	c.Assert(err, IsNil)
+	decoded, err = DecodeChunk(NewMemResource(encoded.AsBytes()), nil)
+	c.Assert(err, IsNil)
+	c.Assert(decoded.AsBytes(), DeepEquals, lookalike)
+
+	_, err = EncodeChunk(resource, "lz4")
+	c.Assert(err, Equals, UNKNOWN_COMPRESSION)
+}
+
+func (*CompressSuite) TestChunkCacheCompression(c *C) {
+	remote := NewMemChunkService()
+	chunks := NewChunkCache(remote, newCache(c))
+	c.Assert(chunks.SetCompression(COMPRESS_ZSTD), IsNil)
+
+	key := computeContentKey(compressible)
+	c.Assert(chunks.Put(key, NewMemResource(compressible)), IsNil)
+	c.Assert(chunks.PushToRemote(key), IsNil)
+
+	// the key is still the hash of the uncompressed content, but the remote holds less
+	stored, _ := remote.Get(key)
+	c.Assert(stored.GetLength() < int64(len(compressible)), Equals, true)
+	stats := chunks.GetCompressionStats()
+	c.Assert(stats.Chunks, Equals, int64(1))
+	c.Assert(stats.CompressedChunks, Equals, int64(1))
+	c.Assert(stats.RawBytes, Equals, int64(len(compressible)))
+	c.Assert(stats.StoredBytes, Equals, stored.GetLength())
+
+	// another cache gets the original content back regardless of its own setting
+	other := NewChunkCache(remote, newCache(c))
+	resource, err := other.Get(key)
+	c.Assert(err, IsNil)
+	c.Assert(resource.AsBytes(), DeepEquals, compressible)
+}
+
+func (*CompressSuite) TestCorruptChunks(c *C) {
+	for _, compression := range []string{COMPRESS_GZIP, COMPRESS_ZSTD} {
+		encoded, err := EncodeChunk(NewMemResource(compressible), compression)
+		c.Assert(err, IsNil)
+		buffer := encoded.AsBytes()
+
+		// compressed data which has been damaged
+		damaged := append([]byte{}, buffer...)
+		for i := CHUNK_HEADER_SIZE; i < len(damaged); i++ {
+			damaged[i] ^= 0x55
+		}
+		_, err = DecodeChunk(NewMemResource(damaged), nil)
+		c.Assert(ErrorCodeOf(err), Equals, ERR_CORRUPT)
+
+		// truncated
+		_, err = DecodeChunk(NewMemResource(buffer[:len(buffer)/2]), nil)
+		c.Assert(ErrorCodeOf(err), Equals, ERR_CORRUPT)
+
+		// a header which does not match the content
+		for _, length := range []int{len(compressible) - 1, len(compressible) + 1} {
+			mislabelled := append(chunkHeader(buffer[len(CHUNK_MAGIC)], int64(length)), buffer[CHUNK_HEADER_SIZE:]...)
+			_, err = DecodeChunk(NewMemResource(mislabelled), nil)
+			c.Assert(ErrorCodeOf(err), Equals, ERR_CORRUPT)
+		}
+	}
+
+	// corrupt chunks are not cached, and are decoded again on the next Get
+	remote := NewMemChunkService()
+	chunks := NewChunkCache(remote, newCache(c))
+	encoded, err := EncodeChunk(NewMemResource(compressible), COMPRESS_GZIP)
+	c.Assert(err, IsNil)
+	buffer := encoded.AsBytes()
+	remote.Put(&Key{1}, NewMemResource(buffer[:len(buffer)-4]))
+	_, err = chunks.Get(&Key{1})
+	c.Assert(ErrorCodeOf(err), Equals, ERR_CORRUPT)
+
+	remote.Put(&Key{1}, encoded)
+	fetched, err := chunks.Get(&Key{1})
+	c.Assert(err, IsNil)
+	c.Assert(fetched.AsBytes(), DeepEquals, compressible)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 )
 
 type Key [32]byte
@@ -65,6 +66,8 @@ type Directory interface {
 	Get(name string) (*FileMetadata, error)
 	Put(name string, metadata *FileMetadata) (*Key, int64, error)
 	Remove(name string) (*Key, int64, error)
+	// Applies several changes, returning a single new Directory.  A nil metadata removes that name.
+	Update(changes map[string]*FileMetadata) (*Key, int64, error)
 	Iterate() Iterator
 	GetTotalSize() (int64, error)
 }
@@ -88,8 +91,14 @@ type FileResource struct {
 	filename string
 }
 
+// returned by conditional writes when the destination already exists
+var OBJECT_EXISTS error = NewError(ERR_EXISTS, "Object already exists")
+
+// returned by ChunkService.Get when the chunk does not exist
+var NO_SUCH_CHUNK error = NewError(ERR_NOT_FOUND, "No such chunk")
+
 type ChunkService interface {
-	// all methods are threadsafe
+	// all methods are threadsafe.  Get returns NO_SUCH_CHUNK if the chunk does not exist.
 	Get(key *Key) (Resource, error)
 	Put(key *Key, resource Resource) error
 }
@@ -104,10 +113,42 @@ type IterableChunkService interface {
 	Iterate() KeyIterator
 }
 
+// A chunk service which may hold on to chunks after Put returns (ie: PackingChunkService).  Chunks are only
+// guaranteed to be readable by others once Flush has succeeded.
+type FlushableChunkService interface {
+	ChunkService
+	Flush() error
+}
+
+// A chunk service which can tell whether it holds chunks without fetching them (ie: with a HEAD request on
+// S3).  Used to avoid uploading chunks which are already on the remote.
+type CheckableChunkService interface {
+	ChunkService
+	Has(key *Key) (bool, error)
+	// returns whether each of keys exists, in the same order as keys
+	HasMany(keys []*Key) ([]bool, error)
+}
+
 type TagService interface {
 	Put(name string, key *Key) error
 	Get(name string) (*Key, error)
-	ForEach(callback func(name string, key *Key))
+	Delete(name string) error
+	// Sets the tag only if it currently has the key expected (or does not exist, if expected is nil).
+	// Returns TAG_CHANGED otherwise.
+	CompareAndPut(name string, expected *Key, key *Key) error
+	// calls callback for each tag whose name starts with prefix, in sorted order
+	ForEach(prefix string, callback func(name string, key *Key)) error
+	// Waits up to timeout for tags starting with prefix to change after sinceSeq.  Returns the changes in
+	// the order they were made and the sequence number to pass to the next call.  If the changes after
+	// sinceSeq are no longer known, the current value of each matching tag is returned instead.
+	Watch(prefix string, sinceSeq uint64, timeout time.Duration) ([]TagChange, uint64, error)
+}
+
+type TagChange struct {
+	Seq  uint64
+	Name string
+	// nil if the tag was deleted
+	Key *Key
 }
 
 type Lease struct {
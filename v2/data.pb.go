@@ -9,6 +9,7 @@ It is generated from these files:
 	data.proto
 
 It has these top-level messages:
+	Xattr
 	FileMetadata
 	LeafRecordEntry
 	LeafRecord
@@ -20,9 +21,36 @@ It has these top-level messages:
 	LinkReq
 	UnlinkReq
 	SimpleResp
+	PushReq
+	PullReq
+	DeleteTagReq
+	ListRootsReq
+	RootEntry
+	ListRootsResp
+	WatchRootsReq
+	TagChangeEntry
+	WatchRootsResp
+	CompressionStatsResp
+	DiffReq
+	DiffRecord
+	DiffResp
+	MergeReq
+	MergeConflictRecord
+	MergeResp
+	ListFilesReq
+	ListFilesResp
+	MakeDirReq
+	StatReq
+	StatResp
+	BatchOpReq
+	ApplyBatchReq
 	Request
+	Response
 	CacheEntry
 	RootLog
+	RootLogCheckpoint
+	PackEntry
+	PackIndex
 */
 package v2
 
@@ -36,26 +64,62 @@ var _ = math.Inf
 type Request_Type int32
 
 const (
-	Request_GET_KEY        Request_Type = 1
-	Request_GET_LOCAL_PATH Request_Type = 2
-	Request_PUT_LOCAL_PATH Request_Type = 3
-	Request_LINK           Request_Type = 4
-	Request_UNLINK         Request_Type = 5
+	Request_GET_KEY               Request_Type = 1
+	Request_GET_LOCAL_PATH        Request_Type = 2
+	Request_PUT_LOCAL_PATH        Request_Type = 3
+	Request_LINK                  Request_Type = 4
+	Request_UNLINK                Request_Type = 5
+	Request_PUSH                  Request_Type = 6
+	Request_PULL                  Request_Type = 7
+	Request_DELETE_TAG            Request_Type = 8
+	Request_LIST_ROOTS            Request_Type = 9
+	Request_WATCH_ROOTS           Request_Type = 10
+	Request_GET_COMPRESSION_STATS Request_Type = 11
+	Request_DIFF                  Request_Type = 12
+	Request_MERGE                 Request_Type = 13
+	Request_LIST_FILES            Request_Type = 14
+	Request_MAKE_DIR              Request_Type = 15
+	Request_STAT                  Request_Type = 16
+	Request_APPLY_BATCH           Request_Type = 17
 )
 
 var Request_Type_name = map[int32]string{
-	1: "GET_KEY",
-	2: "GET_LOCAL_PATH",
-	3: "PUT_LOCAL_PATH",
-	4: "LINK",
-	5: "UNLINK",
+	1:  "GET_KEY",
+	2:  "GET_LOCAL_PATH",
+	3:  "PUT_LOCAL_PATH",
+	4:  "LINK",
+	5:  "UNLINK",
+	6:  "PUSH",
+	7:  "PULL",
+	8:  "DELETE_TAG",
+	9:  "LIST_ROOTS",
+	10: "WATCH_ROOTS",
+	11: "GET_COMPRESSION_STATS",
+	12: "DIFF",
+	13: "MERGE",
+	14: "LIST_FILES",
+	15: "MAKE_DIR",
+	16: "STAT",
+	17: "APPLY_BATCH",
 }
 var Request_Type_value = map[string]int32{
-	"GET_KEY":        1,
-	"GET_LOCAL_PATH": 2,
-	"PUT_LOCAL_PATH": 3,
-	"LINK":           4,
-	"UNLINK":         5,
+	"GET_KEY":               1,
+	"GET_LOCAL_PATH":        2,
+	"PUT_LOCAL_PATH":        3,
+	"LINK":                  4,
+	"UNLINK":                5,
+	"PUSH":                  6,
+	"PULL":                  7,
+	"DELETE_TAG":            8,
+	"LIST_ROOTS":            9,
+	"WATCH_ROOTS":           10,
+	"GET_COMPRESSION_STATS": 11,
+	"DIFF":                  12,
+	"MERGE":                 13,
+	"LIST_FILES":            14,
+	"MAKE_DIR":              15,
+	"STAT":                  16,
+	"APPLY_BATCH":           17,
 }
 
 func (x Request_Type) Enum() *Request_Type {
@@ -117,17 +181,20 @@ const (
 	RootLog_INVALID RootLog_EntryType = 0
 	RootLog_LEASE   RootLog_EntryType = 1
 	RootLog_LABEL   RootLog_EntryType = 2
+	RootLog_BATCH   RootLog_EntryType = 3
 )
 
 var RootLog_EntryType_name = map[int32]string{
 	0: "INVALID",
 	1: "LEASE",
 	2: "LABEL",
+	3: "BATCH",
 }
 var RootLog_EntryType_value = map[string]int32{
 	"INVALID": 0,
 	"LEASE":   1,
 	"LABEL":   2,
+	"BATCH":   3,
 }
 
 func (x RootLog_EntryType) Enum() *RootLog_EntryType {
@@ -147,13 +214,43 @@ func (x *RootLog_EntryType) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+type Xattr struct {
+	Name             *string `protobuf:"bytes,1,req,name=name" json:"name,omitempty"`
+	Value            []byte  `protobuf:"bytes,2,req,name=value" json:"value,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *Xattr) Reset()         { *m = Xattr{} }
+func (m *Xattr) String() string { return proto.CompactTextString(m) }
+func (*Xattr) ProtoMessage()    {}
+
+func (m *Xattr) GetName() string {
+	if m != nil && m.Name != nil {
+		return *m.Name
+	}
+	return ""
+}
+
+func (m *Xattr) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
 type FileMetadata struct {
-	Size             *int64 `protobuf:"varint,1,opt,name=size" json:"size,omitempty"`
-	Key              []byte `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
-	CreationTime     *int64 `protobuf:"varint,3,opt,name=creation_time" json:"creation_time,omitempty"`
-	IsDir            *bool  `protobuf:"varint,4,opt" json:"IsDir,omitempty"`
-	TotalSize        *int64 `protobuf:"varint,5,opt,name=totalSize" json:"totalSize,omitempty"`
-	XXX_unrecognized []byte `json:"-"`
+	Size             *int64   `protobuf:"varint,1,opt,name=size" json:"size,omitempty"`
+	Key              []byte   `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
+	CreationTime     *int64   `protobuf:"varint,3,opt,name=creation_time" json:"creation_time,omitempty"`
+	IsDir            *bool    `protobuf:"varint,4,opt" json:"IsDir,omitempty"`
+	TotalSize        *int64   `protobuf:"varint,5,opt,name=totalSize" json:"totalSize,omitempty"`
+	Mode             *uint32  `protobuf:"varint,6,opt,name=mode" json:"mode,omitempty"`
+	Mtime            *int64   `protobuf:"varint,7,opt,name=mtime" json:"mtime,omitempty"`
+	IsSymlink        *bool    `protobuf:"varint,8,opt" json:"IsSymlink,omitempty"`
+	SymlinkTarget    *string  `protobuf:"bytes,9,opt,name=symlinkTarget" json:"symlinkTarget,omitempty"`
+	Xattrs           []*Xattr `protobuf:"bytes,10,rep,name=xattrs" json:"xattrs,omitempty"`
+	Content          []byte   `protobuf:"bytes,11,opt,name=content" json:"content,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
 }
 
 func (m *FileMetadata) Reset()         { *m = FileMetadata{} }
@@ -195,6 +292,48 @@ func (m *FileMetadata) GetTotalSize() int64 {
 	return 0
 }
 
+func (m *FileMetadata) GetMode() uint32 {
+	if m != nil && m.Mode != nil {
+		return *m.Mode
+	}
+	return 0
+}
+
+func (m *FileMetadata) GetMtime() int64 {
+	if m != nil && m.Mtime != nil {
+		return *m.Mtime
+	}
+	return 0
+}
+
+func (m *FileMetadata) GetIsSymlink() bool {
+	if m != nil && m.IsSymlink != nil {
+		return *m.IsSymlink
+	}
+	return false
+}
+
+func (m *FileMetadata) GetSymlinkTarget() string {
+	if m != nil && m.SymlinkTarget != nil {
+		return *m.SymlinkTarget
+	}
+	return ""
+}
+
+func (m *FileMetadata) GetXattrs() []*Xattr {
+	if m != nil {
+		return m.Xattrs
+	}
+	return nil
+}
+
+func (m *FileMetadata) GetContent() []byte {
+	if m != nil {
+		return m.Content
+	}
+	return nil
+}
+
 type LeafRecordEntry struct {
 	Name             *string       `protobuf:"bytes,1,req,name=name" json:"name,omitempty"`
 	Metadata         *FileMetadata `protobuf:"bytes,2,req,name=metadata" json:"metadata,omitempty"`
@@ -315,9 +454,11 @@ func (m *GetLocalPathResp) GetPath() string {
 	return ""
 }
 
-// responses with SimpleResp
+// copies the file at localPath into path
 type PutLocalPathReq struct {
 	Path             *string `protobuf:"bytes,1,req,name=path" json:"path,omitempty"`
+	LocalPath        *string `protobuf:"bytes,2,req,name=localPath" json:"localPath,omitempty"`
+	Parents          *bool   `protobuf:"varint,3,opt,name=parents" json:"parents,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -332,11 +473,25 @@ func (m *PutLocalPathReq) GetPath() string {
 	return ""
 }
 
-// responses with SimpleResp
+func (m *PutLocalPathReq) GetLocalPath() string {
+	if m != nil && m.LocalPath != nil {
+		return *m.LocalPath
+	}
+	return ""
+}
+
+func (m *PutLocalPathReq) GetParents() bool {
+	if m != nil && m.Parents != nil {
+		return *m.Parents
+	}
+	return false
+}
+
 type LinkReq struct {
 	Key              *string `protobuf:"bytes,1,req,name=key" json:"key,omitempty"`
 	Path             *string `protobuf:"bytes,2,req,name=path" json:"path,omitempty"`
 	IsDir            *bool   `protobuf:"varint,3,req,name=isDir" json:"isDir,omitempty"`
+	Parents          *bool   `protobuf:"varint,4,opt,name=parents" json:"parents,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -365,7 +520,13 @@ func (m *LinkReq) GetIsDir() bool {
 	return false
 }
 
-// responses with SimpleResp
+func (m *LinkReq) GetParents() bool {
+	if m != nil && m.Parents != nil {
+		return *m.Parents
+	}
+	return false
+}
+
 type UnlinkReq struct {
 	Path             *string `protobuf:"bytes,1,req,name=path" json:"path,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
@@ -398,126 +559,1017 @@ func (m *SimpleResp) GetIsSuccess() bool {
 	return false
 }
 
-type Request struct {
-	Type             *Request_Type    `protobuf:"varint,1,req,name=type,enum=v2.Request_Type" json:"type,omitempty"`
-	GetKey           *GetKeyReq       `protobuf:"bytes,2,opt" json:"GetKey,omitempty"`
-	GetLocalPath     *GetLocalPathReq `protobuf:"bytes,3,opt" json:"GetLocalPath,omitempty"`
-	PutLocalPath     *PutLocalPathReq `protobuf:"bytes,4,opt" json:"PutLocalPath,omitempty"`
-	Link             *LinkReq         `protobuf:"bytes,5,opt" json:"Link,omitempty"`
-	Unlink           *UnlinkReq       `protobuf:"bytes,6,opt" json:"Unlink,omitempty"`
-	XXX_unrecognized []byte           `json:"-"`
+type PushReq struct {
+	Source           *string `protobuf:"bytes,1,req,name=source" json:"source,omitempty"`
+	Tag              *string `protobuf:"bytes,2,req,name=tag" json:"tag,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
 }
 
-func (m *Request) Reset()         { *m = Request{} }
-func (m *Request) String() string { return proto.CompactTextString(m) }
-func (*Request) ProtoMessage()    {}
+func (m *PushReq) Reset()         { *m = PushReq{} }
+func (m *PushReq) String() string { return proto.CompactTextString(m) }
+func (*PushReq) ProtoMessage()    {}
 
-func (m *Request) GetType() Request_Type {
-	if m != nil && m.Type != nil {
-		return *m.Type
+func (m *PushReq) GetSource() string {
+	if m != nil && m.Source != nil {
+		return *m.Source
 	}
-	return Request_GET_KEY
+	return ""
 }
 
-func (m *Request) GetGetKey() *GetKeyReq {
-	if m != nil {
-		return m.GetKey
+func (m *PushReq) GetTag() string {
+	if m != nil && m.Tag != nil {
+		return *m.Tag
 	}
-	return nil
+	return ""
 }
 
-func (m *Request) GetGetLocalPath() *GetLocalPathReq {
-	if m != nil {
-		return m.GetLocalPath
+type PullReq struct {
+	Tag              *string `protobuf:"bytes,1,req,name=tag" json:"tag,omitempty"`
+	Destination      *string `protobuf:"bytes,2,req,name=destination" json:"destination,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *PullReq) Reset()         { *m = PullReq{} }
+func (m *PullReq) String() string { return proto.CompactTextString(m) }
+func (*PullReq) ProtoMessage()    {}
+
+func (m *PullReq) GetTag() string {
+	if m != nil && m.Tag != nil {
+		return *m.Tag
 	}
-	return nil
+	return ""
 }
 
-func (m *Request) GetPutLocalPath() *PutLocalPathReq {
-	if m != nil {
-		return m.PutLocalPath
+func (m *PullReq) GetDestination() string {
+	if m != nil && m.Destination != nil {
+		return *m.Destination
 	}
-	return nil
+	return ""
 }
 
-func (m *Request) GetLink() *LinkReq {
-	if m != nil {
-		return m.Link
+type DeleteTagReq struct {
+	Tag              *string `protobuf:"bytes,1,req,name=tag" json:"tag,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *DeleteTagReq) Reset()         { *m = DeleteTagReq{} }
+func (m *DeleteTagReq) String() string { return proto.CompactTextString(m) }
+func (*DeleteTagReq) ProtoMessage()    {}
+
+func (m *DeleteTagReq) GetTag() string {
+	if m != nil && m.Tag != nil {
+		return *m.Tag
 	}
-	return nil
+	return ""
 }
 
-func (m *Request) GetUnlink() *UnlinkReq {
+type ListRootsReq struct {
+	Prefix           *string `protobuf:"bytes,1,opt,name=prefix" json:"prefix,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *ListRootsReq) Reset()         { *m = ListRootsReq{} }
+func (m *ListRootsReq) String() string { return proto.CompactTextString(m) }
+func (*ListRootsReq) ProtoMessage()    {}
+
+func (m *ListRootsReq) GetPrefix() string {
+	if m != nil && m.Prefix != nil {
+		return *m.Prefix
+	}
+	return ""
+}
+
+type RootEntry struct {
+	Name             *string `protobuf:"bytes,1,req,name=name" json:"name,omitempty"`
+	Key              *string `protobuf:"bytes,2,req,name=key" json:"key,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *RootEntry) Reset()         { *m = RootEntry{} }
+func (m *RootEntry) String() string { return proto.CompactTextString(m) }
+func (*RootEntry) ProtoMessage()    {}
+
+func (m *RootEntry) GetName() string {
+	if m != nil && m.Name != nil {
+		return *m.Name
+	}
+	return ""
+}
+
+func (m *RootEntry) GetKey() string {
+	if m != nil && m.Key != nil {
+		return *m.Key
+	}
+	return ""
+}
+
+type ListRootsResp struct {
+	Roots            []*RootEntry `protobuf:"bytes,1,rep,name=roots" json:"roots,omitempty"`
+	XXX_unrecognized []byte       `json:"-"`
+}
+
+func (m *ListRootsResp) Reset()         { *m = ListRootsResp{} }
+func (m *ListRootsResp) String() string { return proto.CompactTextString(m) }
+func (*ListRootsResp) ProtoMessage()    {}
+
+func (m *ListRootsResp) GetRoots() []*RootEntry {
 	if m != nil {
-		return m.Unlink
+		return m.Roots
 	}
 	return nil
 }
 
-type CacheEntry struct {
-	Filename         *string                `protobuf:"bytes,1,req" json:"Filename,omitempty"`
-	Source           *CacheEntry_SourceType `protobuf:"varint,2,req,enum=v2.CacheEntry_SourceType" json:"Source,omitempty"`
-	XXX_unrecognized []byte                 `json:"-"`
+type WatchRootsReq struct {
+	Prefix           *string `protobuf:"bytes,1,opt,name=prefix" json:"prefix,omitempty"`
+	SinceSeq         *uint64 `protobuf:"varint,2,opt,name=sinceSeq" json:"sinceSeq,omitempty"`
+	TimeoutSeconds   *int32  `protobuf:"varint,3,opt,name=timeoutSeconds" json:"timeoutSeconds,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
 }
 
-func (m *CacheEntry) Reset()         { *m = CacheEntry{} }
-func (m *CacheEntry) String() string { return proto.CompactTextString(m) }
-func (*CacheEntry) ProtoMessage()    {}
+func (m *WatchRootsReq) Reset()         { *m = WatchRootsReq{} }
+func (m *WatchRootsReq) String() string { return proto.CompactTextString(m) }
+func (*WatchRootsReq) ProtoMessage()    {}
 
-func (m *CacheEntry) GetFilename() string {
-	if m != nil && m.Filename != nil {
-		return *m.Filename
+func (m *WatchRootsReq) GetPrefix() string {
+	if m != nil && m.Prefix != nil {
+		return *m.Prefix
 	}
 	return ""
 }
 
-func (m *CacheEntry) GetSource() CacheEntry_SourceType {
-	if m != nil && m.Source != nil {
-		return *m.Source
+func (m *WatchRootsReq) GetSinceSeq() uint64 {
+	if m != nil && m.SinceSeq != nil {
+		return *m.SinceSeq
 	}
-	return CacheEntry_INVALID
+	return 0
 }
 
-type RootLog struct {
-	Type             *RootLog_EntryType `protobuf:"varint,1,req,enum=v2.RootLog_EntryType" json:"Type,omitempty"`
-	Name             *string            `protobuf:"bytes,2,opt" json:"Name,omitempty"`
-	Key              []byte             `protobuf:"bytes,3,opt" json:"Key,omitempty"`
-	Expiry           *uint64            `protobuf:"varint,4,opt" json:"Expiry,omitempty"`
-	XXX_unrecognized []byte             `json:"-"`
+func (m *WatchRootsReq) GetTimeoutSeconds() int32 {
+	if m != nil && m.TimeoutSeconds != nil {
+		return *m.TimeoutSeconds
+	}
+	return 0
 }
 
-func (m *RootLog) Reset()         { *m = RootLog{} }
-func (m *RootLog) String() string { return proto.CompactTextString(m) }
-func (*RootLog) ProtoMessage()    {}
+type TagChangeEntry struct {
+	Seq              *uint64 `protobuf:"varint,1,req,name=seq" json:"seq,omitempty"`
+	Name             *string `protobuf:"bytes,2,req,name=name" json:"name,omitempty"`
+	Key              *string `protobuf:"bytes,3,opt,name=key" json:"key,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
 
-func (m *RootLog) GetType() RootLog_EntryType {
-	if m != nil && m.Type != nil {
-		return *m.Type
+func (m *TagChangeEntry) Reset()         { *m = TagChangeEntry{} }
+func (m *TagChangeEntry) String() string { return proto.CompactTextString(m) }
+func (*TagChangeEntry) ProtoMessage()    {}
+
+func (m *TagChangeEntry) GetSeq() uint64 {
+	if m != nil && m.Seq != nil {
+		return *m.Seq
 	}
-	return RootLog_INVALID
+	return 0
 }
 
-func (m *RootLog) GetName() string {
+func (m *TagChangeEntry) GetName() string {
 	if m != nil && m.Name != nil {
 		return *m.Name
 	}
 	return ""
 }
 
-func (m *RootLog) GetKey() []byte {
+func (m *TagChangeEntry) GetKey() string {
+	if m != nil && m.Key != nil {
+		return *m.Key
+	}
+	return ""
+}
+
+type WatchRootsResp struct {
+	Changes          []*TagChangeEntry `protobuf:"bytes,1,rep,name=changes" json:"changes,omitempty"`
+	Seq              *uint64           `protobuf:"varint,2,req,name=seq" json:"seq,omitempty"`
+	XXX_unrecognized []byte            `json:"-"`
+}
+
+func (m *WatchRootsResp) Reset()         { *m = WatchRootsResp{} }
+func (m *WatchRootsResp) String() string { return proto.CompactTextString(m) }
+func (*WatchRootsResp) ProtoMessage()    {}
+
+func (m *WatchRootsResp) GetChanges() []*TagChangeEntry {
 	if m != nil {
-		return m.Key
+		return m.Changes
 	}
 	return nil
 }
 
-func (m *RootLog) GetExpiry() uint64 {
-	if m != nil && m.Expiry != nil {
-		return *m.Expiry
+func (m *WatchRootsResp) GetSeq() uint64 {
+	if m != nil && m.Seq != nil {
+		return *m.Seq
+	}
+	return 0
+}
+
+type CompressionStatsResp struct {
+	Chunks           *int64 `protobuf:"varint,1,req,name=chunks" json:"chunks,omitempty"`
+	CompressedChunks *int64 `protobuf:"varint,2,req,name=compressedChunks" json:"compressedChunks,omitempty"`
+	RawBytes         *int64 `protobuf:"varint,3,req,name=rawBytes" json:"rawBytes,omitempty"`
+	StoredBytes      *int64 `protobuf:"varint,4,req,name=storedBytes" json:"storedBytes,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *CompressionStatsResp) Reset()         { *m = CompressionStatsResp{} }
+func (m *CompressionStatsResp) String() string { return proto.CompactTextString(m) }
+func (*CompressionStatsResp) ProtoMessage()    {}
+
+func (m *CompressionStatsResp) GetChunks() int64 {
+	if m != nil && m.Chunks != nil {
+		return *m.Chunks
+	}
+	return 0
+}
+
+func (m *CompressionStatsResp) GetCompressedChunks() int64 {
+	if m != nil && m.CompressedChunks != nil {
+		return *m.CompressedChunks
 	}
 	return 0
 }
 
+func (m *CompressionStatsResp) GetRawBytes() int64 {
+	if m != nil && m.RawBytes != nil {
+		return *m.RawBytes
+	}
+	return 0
+}
+
+func (m *CompressionStatsResp) GetStoredBytes() int64 {
+	if m != nil && m.StoredBytes != nil {
+		return *m.StoredBytes
+	}
+	return 0
+}
+
+// a and b may each be either a path or a tag
+type DiffReq struct {
+	A                *string `protobuf:"bytes,1,req,name=a" json:"a,omitempty"`
+	B                *string `protobuf:"bytes,2,req,name=b" json:"b,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *DiffReq) Reset()         { *m = DiffReq{} }
+func (m *DiffReq) String() string { return proto.CompactTextString(m) }
+func (*DiffReq) ProtoMessage()    {}
+
+func (m *DiffReq) GetA() string {
+	if m != nil && m.A != nil {
+		return *m.A
+	}
+	return ""
+}
+
+func (m *DiffReq) GetB() string {
+	if m != nil && m.B != nil {
+		return *m.B
+	}
+	return ""
+}
+
+type DiffRecord struct {
+	Path             *string `protobuf:"bytes,1,req,name=path" json:"path,omitempty"`
+	Change           *string `protobuf:"bytes,2,req,name=change" json:"change,omitempty"`
+	IsDir            *bool   `protobuf:"varint,3,req,name=isDir" json:"isDir,omitempty"`
+	OldKey           *string `protobuf:"bytes,4,opt,name=oldKey" json:"oldKey,omitempty"`
+	NewKey           *string `protobuf:"bytes,5,opt,name=newKey" json:"newKey,omitempty"`
+	OldSize          *int64  `protobuf:"varint,6,opt,name=oldSize" json:"oldSize,omitempty"`
+	NewSize          *int64  `protobuf:"varint,7,opt,name=newSize" json:"newSize,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *DiffRecord) Reset()         { *m = DiffRecord{} }
+func (m *DiffRecord) String() string { return proto.CompactTextString(m) }
+func (*DiffRecord) ProtoMessage()    {}
+
+func (m *DiffRecord) GetPath() string {
+	if m != nil && m.Path != nil {
+		return *m.Path
+	}
+	return ""
+}
+
+func (m *DiffRecord) GetChange() string {
+	if m != nil && m.Change != nil {
+		return *m.Change
+	}
+	return ""
+}
+
+func (m *DiffRecord) GetIsDir() bool {
+	if m != nil && m.IsDir != nil {
+		return *m.IsDir
+	}
+	return false
+}
+
+func (m *DiffRecord) GetOldKey() string {
+	if m != nil && m.OldKey != nil {
+		return *m.OldKey
+	}
+	return ""
+}
+
+func (m *DiffRecord) GetNewKey() string {
+	if m != nil && m.NewKey != nil {
+		return *m.NewKey
+	}
+	return ""
+}
+
+func (m *DiffRecord) GetOldSize() int64 {
+	if m != nil && m.OldSize != nil {
+		return *m.OldSize
+	}
+	return 0
+}
+
+func (m *DiffRecord) GetNewSize() int64 {
+	if m != nil && m.NewSize != nil {
+		return *m.NewSize
+	}
+	return 0
+}
+
+type DiffResp struct {
+	Entries          []*DiffRecord `protobuf:"bytes,1,rep,name=entries" json:"entries,omitempty"`
+	XXX_unrecognized []byte        `json:"-"`
+}
+
+func (m *DiffResp) Reset()         { *m = DiffResp{} }
+func (m *DiffResp) String() string { return proto.CompactTextString(m) }
+func (*DiffResp) ProtoMessage()    {}
+
+func (m *DiffResp) GetEntries() []*DiffRecord {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+type MergeReq struct {
+	Base             *string `protobuf:"bytes,1,req,name=base" json:"base,omitempty"`
+	Ours             *string `protobuf:"bytes,2,req,name=ours" json:"ours,omitempty"`
+	Theirs           *string `protobuf:"bytes,3,req,name=theirs" json:"theirs,omitempty"`
+	Destination      *string `protobuf:"bytes,4,opt,name=destination" json:"destination,omitempty"`
+	Tag              *string `protobuf:"bytes,5,opt,name=tag" json:"tag,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *MergeReq) Reset()         { *m = MergeReq{} }
+func (m *MergeReq) String() string { return proto.CompactTextString(m) }
+func (*MergeReq) ProtoMessage()    {}
+
+func (m *MergeReq) GetBase() string {
+	if m != nil && m.Base != nil {
+		return *m.Base
+	}
+	return ""
+}
+
+func (m *MergeReq) GetOurs() string {
+	if m != nil && m.Ours != nil {
+		return *m.Ours
+	}
+	return ""
+}
+
+func (m *MergeReq) GetTheirs() string {
+	if m != nil && m.Theirs != nil {
+		return *m.Theirs
+	}
+	return ""
+}
+
+func (m *MergeReq) GetDestination() string {
+	if m != nil && m.Destination != nil {
+		return *m.Destination
+	}
+	return ""
+}
+
+func (m *MergeReq) GetTag() string {
+	if m != nil && m.Tag != nil {
+		return *m.Tag
+	}
+	return ""
+}
+
+type MergeConflictRecord struct {
+	Path             *string `protobuf:"bytes,1,req,name=path" json:"path,omitempty"`
+	BaseKey          *string `protobuf:"bytes,2,opt,name=baseKey" json:"baseKey,omitempty"`
+	OursKey          *string `protobuf:"bytes,3,opt,name=oursKey" json:"oursKey,omitempty"`
+	TheirsKey        *string `protobuf:"bytes,4,opt,name=theirsKey" json:"theirsKey,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *MergeConflictRecord) Reset()         { *m = MergeConflictRecord{} }
+func (m *MergeConflictRecord) String() string { return proto.CompactTextString(m) }
+func (*MergeConflictRecord) ProtoMessage()    {}
+
+func (m *MergeConflictRecord) GetPath() string {
+	if m != nil && m.Path != nil {
+		return *m.Path
+	}
+	return ""
+}
+
+func (m *MergeConflictRecord) GetBaseKey() string {
+	if m != nil && m.BaseKey != nil {
+		return *m.BaseKey
+	}
+	return ""
+}
+
+func (m *MergeConflictRecord) GetOursKey() string {
+	if m != nil && m.OursKey != nil {
+		return *m.OursKey
+	}
+	return ""
+}
+
+func (m *MergeConflictRecord) GetTheirsKey() string {
+	if m != nil && m.TheirsKey != nil {
+		return *m.TheirsKey
+	}
+	return ""
+}
+
+type MergeResp struct {
+	Key              *string                `protobuf:"bytes,1,req,name=key" json:"key,omitempty"`
+	Conflicts        []*MergeConflictRecord `protobuf:"bytes,2,rep,name=conflicts" json:"conflicts,omitempty"`
+	XXX_unrecognized []byte                 `json:"-"`
+}
+
+func (m *MergeResp) Reset()         { *m = MergeResp{} }
+func (m *MergeResp) String() string { return proto.CompactTextString(m) }
+func (*MergeResp) ProtoMessage()    {}
+
+func (m *MergeResp) GetKey() string {
+	if m != nil && m.Key != nil {
+		return *m.Key
+	}
+	return ""
+}
+
+func (m *MergeResp) GetConflicts() []*MergeConflictRecord {
+	if m != nil {
+		return m.Conflicts
+	}
+	return nil
+}
+
+type ListFilesReq struct {
+	Path             *string `protobuf:"bytes,1,req,name=path" json:"path,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *ListFilesReq) Reset()         { *m = ListFilesReq{} }
+func (m *ListFilesReq) String() string { return proto.CompactTextString(m) }
+func (*ListFilesReq) ProtoMessage()    {}
+
+func (m *ListFilesReq) GetPath() string {
+	if m != nil && m.Path != nil {
+		return *m.Path
+	}
+	return ""
+}
+
+type ListFilesResp struct {
+	Entries          []*LeafRecordEntry `protobuf:"bytes,1,rep,name=entries" json:"entries,omitempty"`
+	XXX_unrecognized []byte             `json:"-"`
+}
+
+func (m *ListFilesResp) Reset()         { *m = ListFilesResp{} }
+func (m *ListFilesResp) String() string { return proto.CompactTextString(m) }
+func (*ListFilesResp) ProtoMessage()    {}
+
+func (m *ListFilesResp) GetEntries() []*LeafRecordEntry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+type MakeDirReq struct {
+	Path             *string `protobuf:"bytes,1,req,name=path" json:"path,omitempty"`
+	Parents          *bool   `protobuf:"varint,2,opt,name=parents" json:"parents,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *MakeDirReq) Reset()         { *m = MakeDirReq{} }
+func (m *MakeDirReq) String() string { return proto.CompactTextString(m) }
+func (*MakeDirReq) ProtoMessage()    {}
+
+func (m *MakeDirReq) GetPath() string {
+	if m != nil && m.Path != nil {
+		return *m.Path
+	}
+	return ""
+}
+
+func (m *MakeDirReq) GetParents() bool {
+	if m != nil && m.Parents != nil {
+		return *m.Parents
+	}
+	return false
+}
+
+type StatReq struct {
+	Path             *string `protobuf:"bytes,1,req,name=path" json:"path,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *StatReq) Reset()         { *m = StatReq{} }
+func (m *StatReq) String() string { return proto.CompactTextString(m) }
+func (*StatReq) ProtoMessage()    {}
+
+func (m *StatReq) GetPath() string {
+	if m != nil && m.Path != nil {
+		return *m.Path
+	}
+	return ""
+}
+
+type StatResp struct {
+	Metadata         *FileMetadata `protobuf:"bytes,1,opt,name=metadata" json:"metadata,omitempty"`
+	XXX_unrecognized []byte        `json:"-"`
+}
+
+func (m *StatResp) Reset()         { *m = StatResp{} }
+func (m *StatResp) String() string { return proto.CompactTextString(m) }
+func (*StatResp) ProtoMessage()    {}
+
+func (m *StatResp) GetMetadata() *FileMetadata {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
+type BatchOpReq struct {
+	Op               *string `protobuf:"bytes,1,req,name=op" json:"op,omitempty"`
+	Path             *string `protobuf:"bytes,2,req,name=path" json:"path,omitempty"`
+	Key              *string `protobuf:"bytes,3,opt,name=key" json:"key,omitempty"`
+	IsDir            *bool   `protobuf:"varint,4,opt,name=isDir" json:"isDir,omitempty"`
+	LocalPath        *string `protobuf:"bytes,5,opt,name=localPath" json:"localPath,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *BatchOpReq) Reset()         { *m = BatchOpReq{} }
+func (m *BatchOpReq) String() string { return proto.CompactTextString(m) }
+func (*BatchOpReq) ProtoMessage()    {}
+
+func (m *BatchOpReq) GetOp() string {
+	if m != nil && m.Op != nil {
+		return *m.Op
+	}
+	return ""
+}
+
+func (m *BatchOpReq) GetPath() string {
+	if m != nil && m.Path != nil {
+		return *m.Path
+	}
+	return ""
+}
+
+func (m *BatchOpReq) GetKey() string {
+	if m != nil && m.Key != nil {
+		return *m.Key
+	}
+	return ""
+}
+
+func (m *BatchOpReq) GetIsDir() bool {
+	if m != nil && m.IsDir != nil {
+		return *m.IsDir
+	}
+	return false
+}
+
+func (m *BatchOpReq) GetLocalPath() string {
+	if m != nil && m.LocalPath != nil {
+		return *m.LocalPath
+	}
+	return ""
+}
+
+type ApplyBatchReq struct {
+	Ops              []*BatchOpReq `protobuf:"bytes,1,rep,name=ops" json:"ops,omitempty"`
+	XXX_unrecognized []byte        `json:"-"`
+}
+
+func (m *ApplyBatchReq) Reset()         { *m = ApplyBatchReq{} }
+func (m *ApplyBatchReq) String() string { return proto.CompactTextString(m) }
+func (*ApplyBatchReq) ProtoMessage()    {}
+
+func (m *ApplyBatchReq) GetOps() []*BatchOpReq {
+	if m != nil {
+		return m.Ops
+	}
+	return nil
+}
+
+type Request struct {
+	Type             *Request_Type    `protobuf:"varint,1,req,name=type,enum=v2.Request_Type" json:"type,omitempty"`
+	GetKey           *GetKeyReq       `protobuf:"bytes,2,opt" json:"GetKey,omitempty"`
+	GetLocalPath     *GetLocalPathReq `protobuf:"bytes,3,opt" json:"GetLocalPath,omitempty"`
+	PutLocalPath     *PutLocalPathReq `protobuf:"bytes,4,opt" json:"PutLocalPath,omitempty"`
+	Link             *LinkReq         `protobuf:"bytes,5,opt" json:"Link,omitempty"`
+	Unlink           *UnlinkReq       `protobuf:"bytes,6,opt" json:"Unlink,omitempty"`
+	Push             *PushReq         `protobuf:"bytes,7,opt" json:"Push,omitempty"`
+	Pull             *PullReq         `protobuf:"bytes,8,opt" json:"Pull,omitempty"`
+	DeleteTag        *DeleteTagReq    `protobuf:"bytes,9,opt" json:"DeleteTag,omitempty"`
+	ListRoots        *ListRootsReq    `protobuf:"bytes,10,opt" json:"ListRoots,omitempty"`
+	WatchRoots       *WatchRootsReq   `protobuf:"bytes,11,opt" json:"WatchRoots,omitempty"`
+	Diff             *DiffReq         `protobuf:"bytes,12,opt" json:"Diff,omitempty"`
+	Merge            *MergeReq        `protobuf:"bytes,13,opt" json:"Merge,omitempty"`
+	ListFiles        *ListFilesReq    `protobuf:"bytes,14,opt" json:"ListFiles,omitempty"`
+	MakeDir          *MakeDirReq      `protobuf:"bytes,15,opt" json:"MakeDir,omitempty"`
+	Stat             *StatReq         `protobuf:"bytes,16,opt" json:"Stat,omitempty"`
+	ApplyBatch       *ApplyBatchReq   `protobuf:"bytes,17,opt" json:"ApplyBatch,omitempty"`
+	XXX_unrecognized []byte           `json:"-"`
+}
+
+func (m *Request) Reset()         { *m = Request{} }
+func (m *Request) String() string { return proto.CompactTextString(m) }
+func (*Request) ProtoMessage()    {}
+
+func (m *Request) GetType() Request_Type {
+	if m != nil && m.Type != nil {
+		return *m.Type
+	}
+	return Request_GET_KEY
+}
+
+func (m *Request) GetGetKey() *GetKeyReq {
+	if m != nil {
+		return m.GetKey
+	}
+	return nil
+}
+
+func (m *Request) GetGetLocalPath() *GetLocalPathReq {
+	if m != nil {
+		return m.GetLocalPath
+	}
+	return nil
+}
+
+func (m *Request) GetPutLocalPath() *PutLocalPathReq {
+	if m != nil {
+		return m.PutLocalPath
+	}
+	return nil
+}
+
+func (m *Request) GetLink() *LinkReq {
+	if m != nil {
+		return m.Link
+	}
+	return nil
+}
+
+func (m *Request) GetUnlink() *UnlinkReq {
+	if m != nil {
+		return m.Unlink
+	}
+	return nil
+}
+
+func (m *Request) GetPush() *PushReq {
+	if m != nil {
+		return m.Push
+	}
+	return nil
+}
+
+func (m *Request) GetPull() *PullReq {
+	if m != nil {
+		return m.Pull
+	}
+	return nil
+}
+
+func (m *Request) GetDeleteTag() *DeleteTagReq {
+	if m != nil {
+		return m.DeleteTag
+	}
+	return nil
+}
+
+func (m *Request) GetListRoots() *ListRootsReq {
+	if m != nil {
+		return m.ListRoots
+	}
+	return nil
+}
+
+func (m *Request) GetWatchRoots() *WatchRootsReq {
+	if m != nil {
+		return m.WatchRoots
+	}
+	return nil
+}
+
+func (m *Request) GetDiff() *DiffReq {
+	if m != nil {
+		return m.Diff
+	}
+	return nil
+}
+
+func (m *Request) GetMerge() *MergeReq {
+	if m != nil {
+		return m.Merge
+	}
+	return nil
+}
+
+func (m *Request) GetListFiles() *ListFilesReq {
+	if m != nil {
+		return m.ListFiles
+	}
+	return nil
+}
+
+func (m *Request) GetMakeDir() *MakeDirReq {
+	if m != nil {
+		return m.MakeDir
+	}
+	return nil
+}
+
+func (m *Request) GetStat() *StatReq {
+	if m != nil {
+		return m.Stat
+	}
+	return nil
+}
+
+func (m *Request) GetApplyBatch() *ApplyBatchReq {
+	if m != nil {
+		return m.ApplyBatch
+	}
+	return nil
+}
+
+// Only the field for the type of request is set, and only if isSuccess.  Requests which return nothing
+// are answered with just isSuccess.
+type Response struct {
+	IsSuccess        *bool                 `protobuf:"varint,1,req,name=isSuccess" json:"isSuccess,omitempty"`
+	ErrorCode        *string               `protobuf:"bytes,2,opt,name=errorCode" json:"errorCode,omitempty"`
+	Error            *string               `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+	GetKey           *GetKeyResp           `protobuf:"bytes,4,opt" json:"GetKey,omitempty"`
+	GetLocalPath     *GetLocalPathResp     `protobuf:"bytes,5,opt" json:"GetLocalPath,omitempty"`
+	ListRoots        *ListRootsResp        `protobuf:"bytes,6,opt" json:"ListRoots,omitempty"`
+	WatchRoots       *WatchRootsResp       `protobuf:"bytes,7,opt" json:"WatchRoots,omitempty"`
+	CompressionStats *CompressionStatsResp `protobuf:"bytes,8,opt" json:"CompressionStats,omitempty"`
+	Diff             *DiffResp             `protobuf:"bytes,9,opt" json:"Diff,omitempty"`
+	Merge            *MergeResp            `protobuf:"bytes,10,opt" json:"Merge,omitempty"`
+	ListFiles        *ListFilesResp        `protobuf:"bytes,11,opt" json:"ListFiles,omitempty"`
+	Stat             *StatResp             `protobuf:"bytes,12,opt" json:"Stat,omitempty"`
+	XXX_unrecognized []byte                `json:"-"`
+}
+
+func (m *Response) Reset()         { *m = Response{} }
+func (m *Response) String() string { return proto.CompactTextString(m) }
+func (*Response) ProtoMessage()    {}
+
+func (m *Response) GetIsSuccess() bool {
+	if m != nil && m.IsSuccess != nil {
+		return *m.IsSuccess
+	}
+	return false
+}
+
+func (m *Response) GetErrorCode() string {
+	if m != nil && m.ErrorCode != nil {
+		return *m.ErrorCode
+	}
+	return ""
+}
+
+func (m *Response) GetError() string {
+	if m != nil && m.Error != nil {
+		return *m.Error
+	}
+	return ""
+}
+
+func (m *Response) GetGetKey() *GetKeyResp {
+	if m != nil {
+		return m.GetKey
+	}
+	return nil
+}
+
+func (m *Response) GetGetLocalPath() *GetLocalPathResp {
+	if m != nil {
+		return m.GetLocalPath
+	}
+	return nil
+}
+
+func (m *Response) GetListRoots() *ListRootsResp {
+	if m != nil {
+		return m.ListRoots
+	}
+	return nil
+}
+
+func (m *Response) GetWatchRoots() *WatchRootsResp {
+	if m != nil {
+		return m.WatchRoots
+	}
+	return nil
+}
+
+func (m *Response) GetCompressionStats() *CompressionStatsResp {
+	if m != nil {
+		return m.CompressionStats
+	}
+	return nil
+}
+
+func (m *Response) GetDiff() *DiffResp {
+	if m != nil {
+		return m.Diff
+	}
+	return nil
+}
+
+func (m *Response) GetMerge() *MergeResp {
+	if m != nil {
+		return m.Merge
+	}
+	return nil
+}
+
+func (m *Response) GetListFiles() *ListFilesResp {
+	if m != nil {
+		return m.ListFiles
+	}
+	return nil
+}
+
+func (m *Response) GetStat() *StatResp {
+	if m != nil {
+		return m.Stat
+	}
+	return nil
+}
+
+type CacheEntry struct {
+	Filename         *string                `protobuf:"bytes,1,req" json:"Filename,omitempty"`
+	Source           *CacheEntry_SourceType `protobuf:"varint,2,req,enum=v2.CacheEntry_SourceType" json:"Source,omitempty"`
+	XXX_unrecognized []byte                 `json:"-"`
+}
+
+func (m *CacheEntry) Reset()         { *m = CacheEntry{} }
+func (m *CacheEntry) String() string { return proto.CompactTextString(m) }
+func (*CacheEntry) ProtoMessage()    {}
+
+func (m *CacheEntry) GetFilename() string {
+	if m != nil && m.Filename != nil {
+		return *m.Filename
+	}
+	return ""
+}
+
+func (m *CacheEntry) GetSource() CacheEntry_SourceType {
+	if m != nil && m.Source != nil {
+		return *m.Source
+	}
+	return CacheEntry_INVALID
+}
+
+type RootLog struct {
+	Type             *RootLog_EntryType `protobuf:"varint,1,req,enum=v2.RootLog_EntryType" json:"Type,omitempty"`
+	Name             *string            `protobuf:"bytes,2,opt" json:"Name,omitempty"`
+	Key              []byte             `protobuf:"bytes,3,opt" json:"Key,omitempty"`
+	Expiry           *uint64            `protobuf:"varint,4,opt" json:"Expiry,omitempty"`
+	Labels           []*RootLog         `protobuf:"bytes,5,rep" json:"Labels,omitempty"`
+	XXX_unrecognized []byte             `json:"-"`
+}
+
+func (m *RootLog) Reset()         { *m = RootLog{} }
+func (m *RootLog) String() string { return proto.CompactTextString(m) }
+func (*RootLog) ProtoMessage()    {}
+
+func (m *RootLog) GetType() RootLog_EntryType {
+	if m != nil && m.Type != nil {
+		return *m.Type
+	}
+	return RootLog_INVALID
+}
+
+func (m *RootLog) GetName() string {
+	if m != nil && m.Name != nil {
+		return *m.Name
+	}
+	return ""
+}
+
+func (m *RootLog) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *RootLog) GetExpiry() uint64 {
+	if m != nil && m.Expiry != nil {
+		return *m.Expiry
+	}
+	return 0
+}
+
+func (m *RootLog) GetLabels() []*RootLog {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+type RootLogCheckpoint struct {
+	Seq              *uint64    `protobuf:"varint,1,req" json:"Seq,omitempty"`
+	Entries          []*RootLog `protobuf:"bytes,2,rep" json:"Entries,omitempty"`
+	XXX_unrecognized []byte     `json:"-"`
+}
+
+func (m *RootLogCheckpoint) Reset()         { *m = RootLogCheckpoint{} }
+func (m *RootLogCheckpoint) String() string { return proto.CompactTextString(m) }
+func (*RootLogCheckpoint) ProtoMessage()    {}
+
+func (m *RootLogCheckpoint) GetSeq() uint64 {
+	if m != nil && m.Seq != nil {
+		return *m.Seq
+	}
+	return 0
+}
+
+func (m *RootLogCheckpoint) GetEntries() []*RootLog {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+type PackEntry struct {
+	Key              []byte `protobuf:"bytes,1,req" json:"Key,omitempty"`
+	Offset           *int64 `protobuf:"varint,2,req" json:"Offset,omitempty"`
+	Length           *int64 `protobuf:"varint,3,req" json:"Length,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *PackEntry) Reset()         { *m = PackEntry{} }
+func (m *PackEntry) String() string { return proto.CompactTextString(m) }
+func (*PackEntry) ProtoMessage()    {}
+
+func (m *PackEntry) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *PackEntry) GetOffset() int64 {
+	if m != nil && m.Offset != nil {
+		return *m.Offset
+	}
+	return 0
+}
+
+func (m *PackEntry) GetLength() int64 {
+	if m != nil && m.Length != nil {
+		return *m.Length
+	}
+	return 0
+}
+
+type PackIndex struct {
+	Entries          []*PackEntry `protobuf:"bytes,1,rep" json:"Entries,omitempty"`
+	XXX_unrecognized []byte       `json:"-"`
+}
+
+func (m *PackIndex) Reset()         { *m = PackIndex{} }
+func (m *PackIndex) String() string { return proto.CompactTextString(m) }
+func (*PackIndex) ProtoMessage()    {}
+
+func (m *PackIndex) GetEntries() []*PackEntry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("v2.Request_Type", Request_Type_name, Request_Type_value)
 	proto.RegisterEnum("v2.CacheEntry_SourceType", CacheEntry_SourceType_name, CacheEntry_SourceType_value)
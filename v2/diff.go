@@ -0,0 +1,119 @@
+package v2
+
+const DIFF_ADDED = "added"
+const DIFF_REMOVED = "removed"
+const DIFF_MODIFIED = "modified"
+
+type DiffEntry struct {
+	// the path relative to the directories being compared
+	Path string
+	// one of DIFF_ADDED, DIFF_REMOVED or DIFF_MODIFIED
+	Change string
+	IsDir  bool
+	// empty if the entry was added
+	OldKey string
+	// empty if the entry was removed
+	NewKey  string
+	OldSize int64
+	NewSize int64
+}
+
+func joinDiffPath(prefix string, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+func newDiffEntry(path string, change string, old *FileMetadata, new *FileMetadata) DiffEntry {
+	entry := DiffEntry{Path: path, Change: change}
+	if old != nil {
+		entry.IsDir = old.GetIsDir()
+		entry.OldKey = KeyFromBytes(old.GetKey()).String()
+		entry.OldSize = old.GetTotalSize()
+	}
+	if new != nil {
+		entry.IsDir = new.GetIsDir()
+		entry.NewKey = KeyFromBytes(new.GetKey()).String()
+		entry.NewSize = new.GetTotalSize()
+	}
+	return entry
+}
+
+// implemented by directories which can report that they could not be read, rather than panicking
+type checkedIterable interface {
+	CheckedIterate() (Iterator, error)
+}
+
+func iterateDir(dirService DirectoryService, key *Key) (Iterator, error) {
+	dir := dirService.GetDirectory(key)
+	if checked, ok := dir.(checkedIterable); ok {
+		return checked.CheckedIterate()
+	}
+	return dir.Iterate(), nil
+}
+
+// walks both directories in name order, descending into subdirectories whose keys differ
+func diffDirs(dirService DirectoryService, prefix string, a *Key, b *Key, entries []DiffEntry) ([]DiffEntry, error) {
+	if *a == *b {
+		return entries, nil
+	}
+
+	aIt, err := iterateDir(dirService, a)
+	if err != nil {
+		return nil, err
+	}
+	bIt, err := iterateDir(dirService, b)
+	if err != nil {
+		return nil, err
+	}
+
+	var aName, bName string
+	var aMeta, bMeta *FileMetadata
+	next := func(it Iterator) (string, *FileMetadata) {
+		if it.HasNext() {
+			return it.Next()
+		}
+		return "", nil
+	}
+	aName, aMeta = next(aIt)
+	bName, bMeta = next(bIt)
+
+	for aMeta != nil || bMeta != nil {
+		if bMeta == nil || (aMeta != nil && aName < bName) {
+			entries = append(entries, newDiffEntry(joinDiffPath(prefix, aName), DIFF_REMOVED, aMeta, nil))
+			aName, aMeta = next(aIt)
+		} else if aMeta == nil || bName < aName {
+			entries = append(entries, newDiffEntry(joinDiffPath(prefix, bName), DIFF_ADDED, nil, bMeta))
+			bName, bMeta = next(bIt)
+		} else {
+			path := joinDiffPath(prefix, aName)
+			aKey := KeyFromBytes(aMeta.GetKey())
+			bKey := KeyFromBytes(bMeta.GetKey())
+			if aMeta.GetIsDir() && bMeta.GetIsDir() {
+				if !sameAttributes(aMeta, bMeta) {
+					entries = append(entries, newDiffEntry(path, DIFF_MODIFIED, aMeta, bMeta))
+				}
+				entries, err = diffDirs(dirService, path, aKey, bKey, entries)
+				if err != nil {
+					return nil, err
+				}
+			} else if *aKey != *bKey || aMeta.GetIsDir() != bMeta.GetIsDir() || !sameAttributes(aMeta, bMeta) {
+				entries = append(entries, newDiffEntry(path, DIFF_MODIFIED, aMeta, bMeta))
+			}
+			aName, aMeta = next(aIt)
+			bName, bMeta = next(bIt)
+		}
+	}
+
+	return entries, nil
+}
+
+// Returns the entries which differ between the directories a and b, depth first in name order.  An entry
+// whose content is the same but whose mode, mtime, symlink target or xattrs differ is reported as modified.
+// Subdirectories with the same key are skipped without being read.  A directory which was added or
+// removed is reported as a single entry rather than one entry per file within it.  Returns an error if
+// any directory which needed comparing could not be read.
+func Diff(dirService DirectoryService, a *Key, b *Key) ([]DiffEntry, error) {
+	return diffDirs(dirService, "", a, b, make([]DiffEntry, 0))
+}
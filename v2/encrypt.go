@@ -0,0 +1,322 @@
+package v2
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// each chunk is encrypted with a key derived from the master key and the chunk's own key, so identical
+// chunks produce identical ciphertext and are only stored once
+const ENCRYPT_CONVERGENT = "convergent"
+
+// every chunk is encrypted with the same key derived from the master key, and a random nonce
+const ENCRYPT_BUCKET = "bucket"
+
+var UNKNOWN_ENCRYPTION_MODE = errors.New("Unknown encryption mode")
+var INVALID_ENCRYPTION_KEY = errors.New("Encryption key must be 32 bytes, hex encoded")
+var NOT_ENCRYPTED error = NewError(ERR_CORRUPT, "Chunk is not encrypted")
+var DECRYPTION_FAILED error = NewError(ERR_CORRUPT, "Chunk could not be decrypted")
+
+const ENCRYPTION_KEY_SIZE = 32
+
+// Encrypted chunks start with ENCRYPTED_MAGIC, a byte identifying the mode, and an 8 byte nonce prefix.  The
+// content follows as a series of AES-GCM sealed segments of ENCRYPTED_SEGMENT_SIZE bytes, the last of which
+// may be shorter and is marked as last so that truncation is detected.
+const ENCRYPTED_MAGIC = "\x89PLE"
+const ENCRYPTED_HEADER_SIZE = len(ENCRYPTED_MAGIC) + 1 + 8
+const ENCRYPTED_SEGMENT_SIZE = 64 * 1024
+
+const (
+	modeConvergent byte = iota + 1
+	modeBucket
+)
+
+var encryptionModes = map[string]byte{ENCRYPT_CONVERGENT: modeConvergent, ENCRYPT_BUCKET: modeBucket}
+
+// Reads a hex encoded 32 byte key from a file, such as one created with "openssl rand -hex 32"
+func LoadEncryptionKey(filename string) ([]byte, error) {
+	buffer, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(buffer)))
+	if err != nil || len(key) != ENCRYPTION_KEY_SIZE {
+		return nil, INVALID_ENCRYPTION_KEY
+	}
+	return key, nil
+}
+
+func deriveKey(secret []byte, label []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(label)
+	return mac.Sum(nil)
+}
+
+// A chunk service which encrypts chunks before passing them to the remote, so the remote only ever holds
+// ciphertext.  Chunks are stored under a keyed hash of their key, so the names of the objects do not reveal
+// the hash of the plaintext either.  Every key used is derived from a single master key, which is needed
+// to read the chunks back regardless of the mode they were written with.  Without it the reachable chunks
+// cannot be identified either, so the master must be told the chunks are encrypted (tagsvc.Config.EncryptedChunks),
+// which stops it from running GC.
+type EncryptingChunkService struct {
+	remote ChunkService
+	mode   byte
+	// decrypted chunks are written to files allocated by getDestFn, or held in memory if it is nil
+	getDestFn func() string
+
+	nameKey       []byte
+	bucketKey     []byte
+	convergentKey []byte
+}
+
+func NewEncryptingChunkService(remote ChunkService, masterKey []byte, mode string, getDestFn func() string) (*EncryptingChunkService, error) {
+	if len(masterKey) != ENCRYPTION_KEY_SIZE {
+		return nil, INVALID_ENCRYPTION_KEY
+	}
+	m, ok := encryptionModes[mode]
+	if !ok {
+		return nil, UNKNOWN_ENCRYPTION_MODE
+	}
+
+	return &EncryptingChunkService{remote: remote,
+		mode:          m,
+		getDestFn:     getDestFn,
+		nameKey:       deriveKey(masterKey, []byte("name")),
+		bucketKey:     deriveKey(masterKey, []byte("bucket")),
+		convergentKey: deriveKey(masterKey, []byte("convergent"))}, nil
+}
+
+// the key the encrypted chunk is stored under on the remote
+func (s *EncryptingChunkService) remoteKey(key *Key) *Key {
+	return KeyFromBytes(deriveKey(s.nameKey, key.AsBytes()))
+}
+
+func (s *EncryptingChunkService) newAEAD(mode byte, key *Key) (cipher.AEAD, error) {
+	dataKey := s.bucketKey
+	if mode == modeConvergent {
+		dataKey = deriveKey(s.convergentKey, key.AsBytes())
+	}
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *EncryptingChunkService) Put(key *Key, resource Resource) error {
+	aead, err := s.newAEAD(s.mode, key)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, ENCRYPTED_HEADER_SIZE)
+	copy(header, ENCRYPTED_MAGIC)
+	header[len(ENCRYPTED_MAGIC)] = s.mode
+	if s.mode == modeBucket {
+		// with a convergent key the key is never reused for different content, so the prefix can be fixed
+		_, err = rand.Read(header[len(ENCRYPTED_MAGIC)+1:])
+		if err != nil {
+			return err
+		}
+	}
+
+	remoteKey := s.remoteKey(key)
+	return s.remote.Put(remoteKey, &encryptedResource{plain: resource, aead: aead, header: header, remoteKey: remoteKey})
+}
+
+// Every segment is decrypted and authenticated before Get returns, so tampered or truncated ciphertext is
+// reported here as DECRYPTION_FAILED rather than when the chunk is read.
+func (s *EncryptingChunkService) Get(key *Key) (Resource, error) {
+	remoteKey := s.remoteKey(key)
+	resource, err := s.remote.Get(remoteKey)
+	if err != nil {
+		return nil, err
+	}
+
+	r := resource.GetReader()
+	plain, err := s.decrypt(key, remoteKey, r)
+	closeReader(r)
+	// the downloaded ciphertext is no longer needed
+	if downloaded, ok := resource.(*FilesystemResource); ok {
+		os.Remove(downloaded.filename)
+	}
+	return plain, err
+}
+
+func (s *EncryptingChunkService) decrypt(key *Key, remoteKey *Key, r io.Reader) (Resource, error) {
+	header := make([]byte, ENCRYPTED_HEADER_SIZE)
+	_, err := io.ReadFull(r, header)
+	if err != nil || !bytes.Equal(header[:len(ENCRYPTED_MAGIC)], []byte(ENCRYPTED_MAGIC)) {
+		return nil, NOT_ENCRYPTED
+	}
+
+	mode := header[len(ENCRYPTED_MAGIC)]
+	if mode != modeConvergent && mode != modeBucket {
+		return nil, UNKNOWN_ENCRYPTION_MODE
+	}
+	aead, err := s.newAEAD(mode, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return readIntoResource(&segmentReader{source: r, aead: aead, header: header, remoteKey: remoteKey, encrypt: false}, s.getDestFn)
+}
+
+func (s *EncryptingChunkService) Has(key *Key) (bool, error) {
+	found, err := s.HasMany([]*Key{key})
+	if err != nil {
+		return false, err
+	}
+	return found[0], nil
+}
+
+func (s *EncryptingChunkService) HasMany(keys []*Key) ([]bool, error) {
+	remoteKeys := make([]*Key, len(keys))
+	for i, key := range keys {
+		remoteKeys[i] = s.remoteKey(key)
+	}
+	return hasManyOrNone(s.remote, remoteKeys)
+}
+
+// Passes Flush through to the remote, if it buffers chunks
+func (s *EncryptingChunkService) Flush() error {
+	if remote, ok := s.remote.(FlushableChunkService); ok {
+		return remote.Flush()
+	}
+	return nil
+}
+
+func segmentNonce(header []byte, index uint32) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, header[len(ENCRYPTED_MAGIC)+1:])
+	binary.BigEndian.PutUint32(nonce[8:], index)
+	return nonce
+}
+
+// binds each segment to the object it belongs to, and marks the last one
+func segmentAdditionalData(remoteKey *Key, last bool) []byte {
+	ad := append([]byte{}, remoteKey.AsBytes()...)
+	if last {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
+
+// the length of a chunk once encrypted.  Every chunk has at least one segment, even if empty.
+func encryptedLength(length int64) int64 {
+	segments := (length + ENCRYPTED_SEGMENT_SIZE - 1) / ENCRYPTED_SEGMENT_SIZE
+	if segments == 0 {
+		segments = 1
+	}
+	return int64(ENCRYPTED_HEADER_SIZE) + length + segments*16
+}
+
+type encryptedResource struct {
+	plain     Resource
+	aead      cipher.AEAD
+	header    []byte
+	remoteKey *Key
+}
+
+func (r *encryptedResource) GetLength() int64 {
+	return encryptedLength(r.plain.GetLength())
+}
+
+func (r *encryptedResource) GetReader() io.Reader {
+	return &segmentReader{source: r.plain.GetReader(), aead: r.aead, header: r.header, remoteKey: r.remoteKey, encrypt: true, pending: r.header}
+}
+
+func (r *encryptedResource) AsBytes() []byte {
+	reader := r.GetReader()
+	defer closeReader(reader)
+
+	buffer, err := ioutil.ReadAll(reader)
+	if err != nil {
+		panic(err.Error())
+	}
+	return buffer
+}
+
+// Encrypts or decrypts source one segment at a time
+type segmentReader struct {
+	source    io.Reader
+	aead      cipher.AEAD
+	header    []byte
+	remoteKey *Key
+	encrypt   bool
+
+	index   uint32
+	done    bool
+	pending []byte
+	// one byte of lookahead, used to tell whether the current segment is the last
+	next []byte
+}
+
+// reads up to size bytes of the next segment from source, and whether it is the last
+func (r *segmentReader) readSegment(size int) ([]byte, bool, error) {
+	buffer := make([]byte, size+1)
+	copy(buffer, r.next)
+	n, err := io.ReadFull(r.source, buffer[len(r.next):])
+	n += len(r.next)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		r.next = nil
+		return buffer[:n], true, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	r.next = buffer[size:]
+	return buffer[:size], false, nil
+}
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		var segment []byte
+		var last bool
+		var err error
+		if r.encrypt {
+			segment, last, err = r.readSegment(ENCRYPTED_SEGMENT_SIZE)
+		} else {
+			segment, last, err = r.readSegment(ENCRYPTED_SEGMENT_SIZE + 16)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		nonce := segmentNonce(r.header, r.index)
+		ad := segmentAdditionalData(r.remoteKey, last)
+		if r.encrypt {
+			r.pending = r.aead.Seal(nil, nonce, segment, ad)
+		} else {
+			r.pending, err = r.aead.Open(nil, nonce, segment, ad)
+			if err != nil {
+				return 0, DECRYPTION_FAILED
+			}
+		}
+		r.index++
+		r.done = last
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *segmentReader) Close() error {
+	closeReader(r.source)
+	return nil
+}
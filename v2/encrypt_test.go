@@ -0,0 +1,136 @@
+package v2
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+)
+
+type EncryptSuite struct{}
+
+var _ = Suite(&EncryptSuite{})
+
+func testEncryptionKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, ENCRYPTION_KEY_SIZE)
+}
+
+func (*EncryptSuite) TestRoundTrip(c *C) {
+	for _, mode := range []string{ENCRYPT_CONVERGENT, ENCRYPT_BUCKET} {
+		remote := NewMemChunkService()
+		encrypted, err := NewEncryptingChunkService(remote, testEncryptionKey(1), mode, nil)
+		c.Assert(err, IsNil)
+
+		for _, size := range []int{0, 1, ENCRYPTED_SEGMENT_SIZE, ENCRYPTED_SEGMENT_SIZE + 1, 2*ENCRYPTED_SEGMENT_SIZE + 5} {
+			data := bytes.Repeat([]byte("x"), size)
+			key := computeContentKey(data)
+			c.Assert(encrypted.Put(key, NewMemResource(data)), IsNil)
+
+			// the remote holds the ciphertext under a different name
+			_, plainNameUsed := remote.chunks[*key]
+			c.Assert(plainNameUsed, Equals, false)
+			stored, _ := remote.Get(encrypted.remoteKey(key))
+			c.Assert(stored.GetLength(), Equals, encryptedLength(int64(size)))
+			c.Assert(bytes.Contains(stored.AsBytes(), []byte("xxxxxxxx")), Equals, false)
+
+			resource, err := encrypted.Get(key)
+			c.Assert(err, IsNil)
+			c.Assert(resource.GetLength(), Equals, int64(size))
+			c.Assert(resource.AsBytes(), DeepEquals, data)
+		}
+	}
+}
+
+func (*EncryptSuite) TestModes(c *C) {
+	data := []byte("patient data")
+	key := computeContentKey(data)
+	ciphertext := func(mode string) []byte {
+		remote := NewMemChunkService()
+		encrypted, _ := NewEncryptingChunkService(remote, testEncryptionKey(1), mode, nil)
+		encrypted.Put(key, NewMemResource(data))
+		stored, _ := remote.Get(encrypted.remoteKey(key))
+		return stored.AsBytes()
+	}
+
+	c.Assert(ciphertext(ENCRYPT_CONVERGENT), DeepEquals, ciphertext(ENCRYPT_CONVERGENT))
+	c.Assert(ciphertext(ENCRYPT_BUCKET), Not(DeepEquals), ciphertext(ENCRYPT_BUCKET))
+
+	_, err := NewEncryptingChunkService(NewMemChunkService(), testEncryptionKey(1), "rot13", nil)
+	c.Assert(err, Equals, UNKNOWN_ENCRYPTION_MODE)
+	_, err = NewEncryptingChunkService(NewMemChunkService(), []byte("short"), ENCRYPT_BUCKET, nil)
+	c.Assert(err, Equals, INVALID_ENCRYPTION_KEY)
+}
+
+func (*EncryptSuite) TestTamperingDetected(c *C) {
+	remote := NewMemChunkService()
+	encrypted, _ := NewEncryptingChunkService(remote, testEncryptionKey(1), ENCRYPT_BUCKET, nil)
+	data := []byte("patient data")
+	key := computeContentKey(data)
+	encrypted.Put(key, NewMemResource(data))
+
+	remoteKey := encrypted.remoteKey(key)
+	stored, _ := remote.Get(remoteKey)
+	tampered := stored.AsBytes()
+	tampered[len(tampered)-1] ^= 1
+	remote.Put(remoteKey, NewMemResource(tampered))
+
+	_, err := encrypted.Get(key)
+	c.Assert(err, Equals, DECRYPTION_FAILED)
+
+	// a truncated chunk is detected too
+	remote.Put(remoteKey, NewMemResource(tampered[:len(tampered)-1]))
+	_, err = encrypted.Get(key)
+	c.Assert(err, Equals, DECRYPTION_FAILED)
+}
+
+func (*EncryptSuite) TestTamperingDetectedByChunkCache(c *C) {
+	remote := NewMemChunkService()
+	cache := newCache(c)
+	encrypted, _ := NewEncryptingChunkService(remote, testEncryptionKey(1), ENCRYPT_CONVERGENT, cache.AllocateTempFilename)
+	key := computeContentKey(compressible)
+	c.Assert(encrypted.Put(key, NewMemResource(compressible)), IsNil)
+
+	remoteKey := encrypted.remoteKey(key)
+	stored, _ := remote.Get(remoteKey)
+	tampered := stored.AsBytes()
+	tampered[ENCRYPTED_HEADER_SIZE] ^= 1
+	remote.Put(remoteKey, NewMemResource(tampered))
+
+	chunks := NewChunkCache(encrypted, cache)
+	_, err := chunks.Get(key)
+	c.Assert(err, Equals, DECRYPTION_FAILED)
+
+	// the failure is not cached, so the chunk can be read once the remote is repaired
+	remote.Put(remoteKey, stored)
+	resource, err := chunks.Get(key)
+	c.Assert(err, IsNil)
+	c.Assert(resource.AsBytes(), DeepEquals, compressible)
+}
+
+func (*EncryptSuite) TestLoadEncryptionKey(c *C) {
+	filename := c.MkDir() + "/key"
+	ioutil.WriteFile(filename, []byte("0101010101010101010101010101010101010101010101010101010101010101\n"), 0600)
+	key, err := LoadEncryptionKey(filename)
+	c.Assert(err, IsNil)
+	c.Assert(key, DeepEquals, testEncryptionKey(1))
+
+	ioutil.WriteFile(filename, []byte("0101"), 0600)
+	_, err = LoadEncryptionKey(filename)
+	c.Assert(err, Equals, INVALID_ENCRYPTION_KEY)
+}
+
+func (*EncryptSuite) TestChunkCacheWithEncryption(c *C) {
+	remote := NewMemChunkService()
+	encrypted, _ := NewEncryptingChunkService(remote, testEncryptionKey(1), ENCRYPT_CONVERGENT, nil)
+	chunks := NewChunkCache(encrypted, newCache(c))
+	chunks.SetCompression(COMPRESS_GZIP)
+
+	key := computeContentKey(compressible)
+	chunks.Put(key, NewMemResource(compressible))
+	c.Assert(chunks.PushToRemote(key), IsNil)
+
+	other := NewChunkCache(encrypted, newCache(c))
+	resource, err := other.Get(key)
+	c.Assert(err, IsNil)
+	c.Assert(resource.AsBytes(), DeepEquals, compressible)
+}
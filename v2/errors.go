@@ -0,0 +1,99 @@
+package v2
+
+import (
+	"fmt"
+	"net/rpc"
+	"strings"
+	"sync"
+)
+
+// Identifies the kind of failure, so that callers on the other side of an RPC (ie: pliantfuse) can react
+// to it without comparing messages.
+type ErrorCode string
+
+const (
+	ERR_NOT_FOUND          ErrorCode = "not_found"
+	ERR_EXISTS             ErrorCode = "exists"
+	ERR_NOT_DIR            ErrorCode = "not_dir"
+	ERR_CONFLICT           ErrorCode = "conflict"
+	ERR_REMOTE_UNAVAILABLE ErrorCode = "remote_unavailable"
+	ERR_CORRUPT            ErrorCode = "corrupt"
+)
+
+// An error with a code.  net/rpc only carries the text of an error, so the wire format is the text returned by
+// Error, "<code>: <message>", and ParseError turns it back into an *Error on the calling side.  Only the text up
+// to the first ": " is taken as the code, so the message itself may contain ": ".
+type Error struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *Error) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+var knownErrorsLock sync.Mutex
+
+// the errors created with NewError, by their text, so that ParseError can return the same value
+var knownErrors = make(map[string]*Error)
+
+// Creates a sentinel error.  ParseError returns this same value when it receives an error with the same
+// code and message, so callers can compare against it on either side of an RPC.
+func NewError(code ErrorCode, message string) *Error {
+	err := &Error{Code: code, Message: message}
+
+	knownErrorsLock.Lock()
+	knownErrors[err.Error()] = err
+	knownErrorsLock.Unlock()
+
+	return err
+}
+
+// Creates a one-off error with a code, ie: for messages which name a path
+func Errorf(code ErrorCode, format string, args ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Marks an error from talking to the master or the bucket as ERR_REMOTE_UNAVAILABLE, unless it already has a code
+func RemoteUnavailable(err error) error {
+	if err == nil || ErrorCodeOf(err) != "" {
+		return err
+	}
+	return &Error{Code: ERR_REMOTE_UNAVAILABLE, Message: err.Error()}
+}
+
+// Returns the code of an error, or "" if it has none
+func ErrorCodeOf(err error) ErrorCode {
+	if coded, ok := ParseError(err).(*Error); ok {
+		return coded.Code
+	}
+	return ""
+}
+
+var errorCodes = []ErrorCode{ERR_NOT_FOUND, ERR_EXISTS, ERR_NOT_DIR, ERR_CONFLICT, ERR_REMOTE_UNAVAILABLE, ERR_CORRUPT}
+
+// Recovers the *Error from an error returned by an RPC.  Errors without a code are returned as they are.  The
+// text must start with one of the codes in errorCodes to be parsed, so an uncoded error is only mistaken for a
+// coded one if its text happens to start with "<code>: ".
+func ParseError(err error) error {
+	serverErr, ok := err.(rpc.ServerError)
+	if !ok {
+		return err
+	}
+
+	text := string(serverErr)
+	knownErrorsLock.Lock()
+	known := knownErrors[text]
+	knownErrorsLock.Unlock()
+	if known != nil {
+		return known
+	}
+
+	for _, code := range errorCodes {
+		prefix := string(code) + ": "
+		if strings.HasPrefix(text, prefix) {
+			return &Error{Code: code, Message: text[len(prefix):]}
+		}
+	}
+	return err
+}
@@ -0,0 +1,38 @@
+package v2
+
+import (
+	"errors"
+	"net/rpc"
+
+	. "gopkg.in/check.v1"
+)
+
+type ErrorsSuite struct{}
+
+var _ = Suite(&ErrorsSuite{})
+
+func (*ErrorsSuite) TestParseError(c *C) {
+	// sentinels come back as the same value
+	c.Assert(ParseError(rpc.ServerError(NO_SUCH_PATH.Error())), Equals, NO_SUCH_PATH)
+	c.Assert(ErrorCodeOf(rpc.ServerError(TAG_CHANGED.Error())), Equals, ERR_CONFLICT)
+
+	err := ParseError(rpc.ServerError(Errorf(ERR_NOT_DIR, "%s is not a directory", "a/b").Error()))
+	c.Assert(err, DeepEquals, &Error{Code: ERR_NOT_DIR, Message: "a/b is not a directory"})
+
+	// only the first ": " separates the code from the message
+	err = ParseError(rpc.ServerError(Errorf(ERR_CORRUPT, "chunk %s: %s", "abc", NO_SUCH_PATH).Error()))
+	c.Assert(err, DeepEquals, &Error{Code: ERR_CORRUPT, Message: "chunk abc: not_found: No such path"})
+
+	unknownCode := rpc.ServerError("timeout: read tcp: i/o timeout")
+	c.Assert(ParseError(unknownCode), Equals, unknownCode)
+
+	plain := rpc.ServerError("something else")
+	c.Assert(ParseError(plain), Equals, plain)
+	c.Assert(ErrorCodeOf(plain), Equals, ErrorCode(""))
+}
+
+func (*ErrorsSuite) TestRemoteUnavailable(c *C) {
+	c.Assert(RemoteUnavailable(nil), IsNil)
+	c.Assert(RemoteUnavailable(NO_SUCH_CHUNK), Equals, NO_SUCH_CHUNK)
+	c.Assert(ErrorCodeOf(RemoteUnavailable(errors.New("connection refused"))), Equals, ERR_REMOTE_UNAVAILABLE)
+}
@@ -0,0 +1,185 @@
+package v2
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// The paths served by Gateway:
+//
+//	GET /files/<path> returns the content of a file, honoring Range headers
+//	PUT /files/<path> stores the request body at path.  With "?parents=true", missing directories are created.
+//	GET /ls/<path> returns the ListFilesRecords of a directory as JSON
+//	POST /push and POST /pull take a PushArgs or PullArgs as JSON
+const (
+	GATEWAY_FILES_PATH = "/files/"
+	GATEWAY_LS_PATH    = "/ls/"
+	GATEWAY_PUSH_PATH  = "/push"
+	GATEWAY_PULL_PATH  = "/pull"
+)
+
+// the HTTP status returned for each kind of error
+var statusByCode = map[ErrorCode]int{
+	ERR_NOT_FOUND:          http.StatusNotFound,
+	ERR_EXISTS:             http.StatusConflict,
+	ERR_NOT_DIR:            http.StatusBadRequest,
+	ERR_CONFLICT:           http.StatusConflict,
+	ERR_REMOTE_UNAVAILABLE: http.StatusServiceUnavailable,
+	ERR_CORRUPT:            http.StatusInternalServerError,
+}
+
+// An HTTP interface to the same operations as AtomicClient, for tools which cannot use net/rpc or FUSE.
+// Like the JSON-RPC server, there is no authentication, so it should only be reachable from trusted hosts.
+type Gateway struct {
+	ac *AtomicClient
+	// uploads are written here before being added to the cache
+	tempDir string
+}
+
+func NewGateway(atomic Atomic, tempDir string) *Gateway {
+	return &Gateway{ac: &AtomicClient{atomic: atomic}, tempDir: tempDir}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status, ok := statusByCode[ErrorCodeOf(err)]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func writeJson(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, GATEWAY_FILES_PATH):
+		switch r.Method {
+		case "GET", "HEAD":
+			g.getFile(w, r, path[len(GATEWAY_FILES_PATH):])
+		case "PUT":
+			g.putFile(w, r, path[len(GATEWAY_FILES_PATH):])
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case strings.HasPrefix(path, GATEWAY_LS_PATH) && r.Method == "GET":
+		var records []ListFilesRecord
+		err := g.ac.ListFiles(path[len(GATEWAY_LS_PATH):], &records)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJson(w, records)
+	case path == GATEWAY_PUSH_PATH && r.Method == "POST":
+		args := &PushArgs{}
+		if !decodeArgs(w, r, args) {
+			return
+		}
+		var result string
+		writeResult(w, g.ac.Push(args, &result))
+	case path == GATEWAY_PULL_PATH && r.Method == "POST":
+		args := &PullArgs{}
+		if !decodeArgs(w, r, args) {
+			return
+		}
+		var result string
+		writeResult(w, g.ac.Pull(args, &result))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func decodeArgs(w http.ResponseWriter, r *http.Request, args interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(args)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeResult(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *Gateway) getFile(w http.ResponseWriter, r *http.Request, path string) {
+	metadata, err := g.ac.atomic.GetMetadata(NewPath(path))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if metadata == nil {
+		writeError(w, NO_SUCH_PATH)
+		return
+	}
+	if metadata.GetIsDir() {
+		http.Error(w, path+" is a directory", http.StatusBadRequest)
+		return
+	}
+
+	resource := g.ac.atomic.GetFileResource(metadata)
+	if resource == nil {
+		writeError(w, Errorf(ERR_CORRUPT, "Resource missing: %s", KeyFromBytes(metadata.GetKey()).String()))
+		return
+	}
+
+	// cached files can be read from any offset, but inline content is held in memory and needs wrapping
+	reader := resource.GetReader()
+	defer closeReader(reader)
+	content, ok := reader.(io.ReadSeeker)
+	if !ok {
+		content = bytes.NewReader(resource.AsBytes())
+	}
+
+	var modTime time.Time
+	if metadata.GetMtime() != 0 {
+		modTime = time.Unix(0, metadata.GetMtime())
+	}
+	http.ServeContent(w, r, path, modTime, content)
+}
+
+func (g *Gateway) putFile(w http.ResponseWriter, r *http.Request, path string) {
+	f, err := ioutil.TempFile(g.tempDir, "upload")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r.Body)
+	closeErr := f.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if closeErr != nil {
+		writeError(w, closeErr)
+		return
+	}
+
+	resource, err := g.ac.atomic.CreateResourceForLocalFile(f.Name())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	parents := r.URL.Query().Get("parents") == "true"
+	err = g.ac.atomic.Batch([]*BatchOp{{Op: BATCH_PUT, Path: NewPath(path), Resource: resource, Parents: parents}})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
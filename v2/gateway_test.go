@@ -0,0 +1,87 @@
+package v2
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type GatewaySuite struct{}
+
+var _ = Suite(&GatewaySuite{})
+
+func newGatewayServer(c *C, remote ChunkService, tags TagService) *httptest.Server {
+	as := newTestAtomicState(c, remote, tags)
+	return httptest.NewServer(NewGateway(as, c.MkDir()))
+}
+
+func gatewayRequest(c *C, method string, url string, body string, header map[string]string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	c.Assert(err, IsNil)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	return resp, string(content)
+}
+
+func (*GatewaySuite) TestFiles(c *C) {
+	server := newGatewayServer(c, NewMemChunkService(), NewMemTagService())
+	defer server.Close()
+
+	resp, _ := gatewayRequest(c, "PUT", server.URL+"/files/a/b", "0123456789", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusNotFound)
+
+	resp, _ = gatewayRequest(c, "PUT", server.URL+"/files/a/b?parents=true", "0123456789", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusCreated)
+
+	resp, body := gatewayRequest(c, "GET", server.URL+"/files/a/b", "", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(body, Equals, "0123456789")
+
+	resp, body = gatewayRequest(c, "GET", server.URL+"/files/a/b", "", map[string]string{"Range": "bytes=2-4"})
+	c.Assert(resp.StatusCode, Equals, http.StatusPartialContent)
+	c.Assert(body, Equals, "234")
+
+	resp, _ = gatewayRequest(c, "GET", server.URL+"/files/a/missing", "", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusNotFound)
+
+	resp, body = gatewayRequest(c, "GET", server.URL+"/ls/a", "", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	var records []ListFilesRecord
+	c.Assert(json.Unmarshal([]byte(body), &records), IsNil)
+	c.Assert(len(records), Equals, 1)
+	c.Assert(records[0].Name, Equals, "b")
+	c.Assert(records[0].Size, Equals, int64(10))
+}
+
+func (*GatewaySuite) TestPushAndPull(c *C) {
+	remote := NewMemChunkService()
+	tags := NewMemTagService()
+	server1 := newGatewayServer(c, remote, tags)
+	defer server1.Close()
+	server2 := newGatewayServer(c, remote, tags)
+	defer server2.Close()
+
+	resp, _ := gatewayRequest(c, "PUT", server1.URL+"/files/a/b?parents=true", "test", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusCreated)
+	resp, _ = gatewayRequest(c, "POST", server1.URL+"/push", `{"Source": "a", "Tag": "tag"}`, nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusNoContent)
+
+	resp, _ = gatewayRequest(c, "POST", server2.URL+"/pull", `{"Tag": "missing", "Destination": "z"}`, nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusNotFound)
+	resp, _ = gatewayRequest(c, "POST", server2.URL+"/pull", `{"Tag": "tag", "Destination": "z"}`, nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusNoContent)
+
+	resp, body := gatewayRequest(c, "GET", server2.URL+"/files/z/b", "", nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(body, Equals, "test")
+}
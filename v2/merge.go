@@ -0,0 +1,151 @@
+package v2
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// A path which was changed differently in ours and theirs.  Keys are empty where the entry does not exist.
+type MergeConflict struct {
+	Path      string
+	BaseKey   string
+	OursKey   string
+	TheirsKey string
+}
+
+func sameEntry(a *FileMetadata, b *FileMetadata) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.GetIsDir() == b.GetIsDir() && bytes.Equal(a.GetKey(), b.GetKey()) && sameAttributes(a, b)
+}
+
+func entryKeyString(metadata *FileMetadata) string {
+	if metadata == nil {
+		return ""
+	}
+	return KeyFromBytes(metadata.GetKey()).String()
+}
+
+func readDirEntries(dirService DirectoryService, key *Key) (map[string]*FileMetadata, error) {
+	it, err := iterateDir(dirService, key)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]*FileMetadata)
+	for it.HasNext() {
+		name, metadata := it.Next()
+		entries[name] = metadata
+	}
+	return entries, nil
+}
+
+func mergedDirMetadata(dirService DirectoryService, chunks ChunkService, key *Key) (*FileMetadata, error) {
+	var length int64
+	if *key != *EMPTY_DIR_KEY {
+		resource, err := chunks.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		length = resource.GetLength()
+	}
+	childrenSize, err := dirService.GetDirectory(key).GetTotalSize()
+	if err != nil {
+		return nil, err
+	}
+	return &FileMetadata{TotalSize: proto.Int64(childrenSize + length), Size: proto.Int64(length), Key: key.AsBytes(), IsDir: proto.Bool(true), CreationTime: proto.Int64(time.Now().Unix())}, nil
+}
+
+func mergeDirs(dirService DirectoryService, chunks ChunkService, path string, base *Key, ours *Key, theirs *Key, conflicts []MergeConflict) (*Key, []MergeConflict, error) {
+	if *ours == *theirs || *base == *theirs {
+		return ours, conflicts, nil
+	}
+	if *base == *ours {
+		return theirs, conflicts, nil
+	}
+
+	dirEntries := make([]map[string]*FileMetadata, 3)
+	for i, key := range []*Key{base, ours, theirs} {
+		var err error
+		dirEntries[i], err = readDirEntries(dirService, key)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	baseEntries, oursEntries, theirsEntries := dirEntries[0], dirEntries[1], dirEntries[2]
+
+	names := make(map[string]bool)
+	for _, entries := range []map[string]*FileMetadata{baseEntries, oursEntries, theirsEntries} {
+		for name := range entries {
+			names[name] = true
+		}
+	}
+
+	// the changes to make to ours
+	changes := make(map[string]*FileMetadata)
+	for name := range names {
+		b := baseEntries[name]
+		o := oursEntries[name]
+		t := theirsEntries[name]
+		childPath := joinDiffPath(path, name)
+
+		if sameEntry(o, t) || sameEntry(b, t) {
+			continue
+		} else if sameEntry(b, o) {
+			changes[name] = t
+		} else if o != nil && t != nil && o.GetIsDir() && t.GetIsDir() {
+			// both sides changed the same directory, so merge its contents
+			childBase := EMPTY_DIR_KEY
+			if b != nil && b.GetIsDir() {
+				childBase = KeyFromBytes(b.GetKey())
+			}
+			var merged *Key
+			var err error
+			merged, conflicts, err = mergeDirs(dirService, chunks, childPath, childBase, KeyFromBytes(o.GetKey()), KeyFromBytes(t.GetKey()), conflicts)
+			if err != nil {
+				return nil, nil, err
+			}
+			if *merged != *KeyFromBytes(o.GetKey()) {
+				changes[name], err = mergedDirMetadata(dirService, chunks, merged)
+				if err != nil {
+					return nil, nil, err
+				}
+				copyAttributes(changes[name], o)
+			}
+		} else {
+			// keep ours, and let the caller decide what to do
+			conflicts = append(conflicts, MergeConflict{Path: childPath, BaseKey: entryKeyString(b), OursKey: entryKeyString(o), TheirsKey: entryKeyString(t)})
+		}
+	}
+
+	if len(changes) == 0 {
+		return ours, conflicts, nil
+	}
+
+	merged, _, err := dirService.GetDirectory(ours).Update(changes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return merged, conflicts, nil
+}
+
+// Combines the changes made to the directory base in ours with those made in theirs.  Where a path was
+// changed in both, subdirectories are merged recursively, and anything else is reported as a conflict
+// and left as it is in ours.  Returns the key of the merged directory, with conflicts sorted by path.
+func Merge(dirService DirectoryService, chunks ChunkService, base *Key, ours *Key, theirs *Key) (*Key, []MergeConflict, error) {
+	merged, conflicts, err := mergeDirs(dirService, chunks, "", base, ours, theirs, make([]MergeConflict, 0))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Sort(byConflictPath(conflicts))
+	return merged, conflicts, nil
+}
+
+type byConflictPath []MergeConflict
+
+func (a byConflictPath) Len() int           { return len(a) }
+func (a byConflictPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byConflictPath) Less(i, j int) bool { return a[i].Path < a[j].Path }
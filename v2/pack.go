@@ -0,0 +1,660 @@
+package v2
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Blob storage needed to hold packs and their indexes.  Names are relative to wherever the store keeps
+// its objects (ie: the prefix of the chunk bucket)
+type PackStore interface {
+	// returns nil if no object with that name exists
+	GetObject(name string) ([]byte, error)
+	PutObject(name string, data []byte) error
+	// reads length bytes of the object starting at offset
+	GetObjectRange(name string, offset int64, length int64) ([]byte, error)
+	DeleteObject(name string) error
+	// returns the names of all objects whose name starts with prefix
+	ListObjects(prefix string) ([]string, error)
+}
+
+var NO_SUCH_OBJECT error = NewError(ERR_NOT_FOUND, "No such object")
+
+const PACK_PREFIX = "packs/"
+const PACK_SUFFIX = ".pack"
+const PACK_INDEX_SUFFIX = ".idx"
+
+// holds a PackIndex of the chunks deleted from a pack since it was written
+const PACK_TOMBSTONE_SUFFIX = ".del"
+
+// chunks no larger than this are bundled into packs.  Larger chunks are written to the remote as they are.
+var MAX_PACKED_CHUNK_SIZE int64 = 256 * 1024
+
+// a pack is written once the chunks waiting for it add up to at least this many bytes
+var TARGET_PACK_SIZE int64 = 16 * 1024 * 1024
+
+// a chunk which is not in any known pack only causes the indexes to be listed again if they were last
+// loaded longer ago than this
+var PACK_INDEX_TTL = 30 * time.Second
+
+func packObjectName(pack string) string {
+	return PACK_PREFIX + pack + PACK_SUFFIX
+}
+
+func packIndexName(pack string) string {
+	return PACK_PREFIX + pack + PACK_INDEX_SUFFIX
+}
+
+func packTombstoneName(pack string) string {
+	return PACK_PREFIX + pack + PACK_TOMBSTONE_SUFFIX
+}
+
+type packLocation struct {
+	pack   string
+	offset int64
+	length int64
+}
+
+type packInfo struct {
+	entries []*PackEntry
+	size    int64
+
+	// chunks which have been deleted, but still take up space in the pack until it is repacked
+	deleted map[Key]bool
+	// set when deleted has changed since the tombstones were last written
+	tombstonesChanged bool
+}
+
+// A chunk service which bundles small chunks into packs so that pushing many small files does not
+// require one request per file.  Each pack is stored as a single object, along with an index object
+// listing the key, offset and length of each chunk within it, and individual chunks are read back with
+// a range request.  Chunks larger than MaxPackedChunkSize are passed straight through to the remote.
+//
+// Small chunks are held in memory until enough have accumulated to fill a pack, so they are not
+// readable by anyone else until Flush has been called.
+type PackingChunkService struct {
+	lock   sync.Mutex
+	remote IterableChunkService
+	store  PackStore
+
+	MaxPackedChunkSize int64
+	TargetPackSize     int64
+	IndexTTL           time.Duration
+
+	// when the store was last listed by LoadIndexes
+	indexesLoaded time.Time
+
+	// the pack holding each chunk which has been written to a pack
+	locations map[Key]packLocation
+	// the index of every known pack, by pack name
+	packs map[string]*packInfo
+
+	// chunks waiting to be written to the next pack, in the order they were put
+	pendingKeys  []*Key
+	pending      map[Key][]byte
+	pendingBytes int64
+}
+
+func NewPackingChunkService(remote IterableChunkService, store PackStore) (*PackingChunkService, error) {
+	p := &PackingChunkService{remote: remote,
+		store:              store,
+		MaxPackedChunkSize: MAX_PACKED_CHUNK_SIZE,
+		TargetPackSize:     TARGET_PACK_SIZE,
+		IndexTTL:           PACK_INDEX_TTL,
+		locations:          make(map[Key]packLocation),
+		packs:              make(map[string]*packInfo),
+		pendingKeys:        make([]*Key, 0, 100),
+		pending:            make(map[Key][]byte)}
+
+	err := p.LoadIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// must be called with lock held
+func (p *PackingChunkService) unsafeLocate(name string, pack *packInfo) {
+	for _, entry := range pack.entries {
+		key := KeyFromBytes(entry.GetKey())
+		if pack.deleted[*key] {
+			continue
+		}
+		if _, located := p.locations[*key]; !located {
+			p.locations[*key] = packLocation{pack: name, offset: entry.GetOffset(), length: entry.GetLength()}
+		}
+	}
+}
+
+// must be called with lock held
+func (p *PackingChunkService) unsafeForgetPack(name string) {
+	pack := p.packs[name]
+	delete(p.packs, name)
+	for _, entry := range pack.entries {
+		key := KeyFromBytes(entry.GetKey())
+		if p.locations[*key].pack == name {
+			delete(p.locations, *key)
+		}
+	}
+}
+
+// Reads the index of every pack in the store which has not been seen before, and forgets any pack
+// which no longer exists because it was repacked by someone else.
+func (p *PackingChunkService) LoadIndexes() error {
+	listed := time.Now()
+	names, err := p.store.ListObjects(PACK_PREFIX)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.indexesLoaded = listed
+
+	hasTombstones := make(map[string]bool)
+	for _, objectName := range names {
+		if strings.HasSuffix(objectName, PACK_TOMBSTONE_SUFFIX) {
+			hasTombstones[strings.TrimSuffix(strings.TrimPrefix(objectName, PACK_PREFIX), PACK_TOMBSTONE_SUFFIX)] = true
+		}
+	}
+
+	present := make(map[string]bool)
+	for _, objectName := range names {
+		if !strings.HasSuffix(objectName, PACK_INDEX_SUFFIX) {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(objectName, PACK_PREFIX), PACK_INDEX_SUFFIX)
+		present[name] = true
+		if _, known := p.packs[name]; known {
+			continue
+		}
+
+		buffer, err := p.store.GetObject(objectName)
+		if err != nil {
+			return err
+		}
+		if buffer == nil {
+			// deleted since it was listed
+			continue
+		}
+		var index PackIndex
+		err = proto.Unmarshal(buffer, &index)
+		if err != nil {
+			return err
+		}
+
+		pack := &packInfo{entries: index.GetEntries(), deleted: make(map[Key]bool)}
+		for _, entry := range pack.entries {
+			pack.size += entry.GetLength()
+		}
+		if hasTombstones[name] {
+			err = p.readTombstones(name, pack)
+			if err != nil {
+				return err
+			}
+		}
+		p.packs[name] = pack
+		p.unsafeLocate(name, pack)
+	}
+
+	forgotten := false
+	for name := range p.packs {
+		if !present[name] {
+			p.unsafeForgetPack(name)
+			forgotten = true
+		}
+	}
+	if forgotten {
+		// some of the forgotten chunks may also be in a pack which still exists
+		for name, pack := range p.packs {
+			p.unsafeLocate(name, pack)
+		}
+	}
+
+	return nil
+}
+
+func (p *PackingChunkService) readTombstones(name string, pack *packInfo) error {
+	buffer, err := p.store.GetObject(packTombstoneName(name))
+	if err != nil || buffer == nil {
+		return err
+	}
+	var tombstones PackIndex
+	err = proto.Unmarshal(buffer, &tombstones)
+	if err != nil {
+		return err
+	}
+	for _, entry := range tombstones.GetEntries() {
+		pack.deleted[*KeyFromBytes(entry.GetKey())] = true
+	}
+	return nil
+}
+
+// must be called with lock held
+func (p *PackingChunkService) unsafeWriteTombstones() error {
+	for name, pack := range p.packs {
+		if !pack.tombstonesChanged {
+			continue
+		}
+		entries := make([]*PackEntry, 0, len(pack.deleted))
+		for _, entry := range pack.entries {
+			if pack.deleted[*KeyFromBytes(entry.GetKey())] {
+				entries = append(entries, entry)
+			}
+		}
+		tombstones, err := proto.Marshal(&PackIndex{Entries: entries})
+		if err != nil {
+			panic(err.Error())
+		}
+		err = p.store.PutObject(packTombstoneName(name), tombstones)
+		if err != nil {
+			return err
+		}
+		pack.tombstonesChanged = false
+	}
+	return nil
+}
+
+// Calls LoadIndexes if the indexes were last loaded more than IndexTTL ago
+func (p *PackingChunkService) loadIndexesIfStale() error {
+	p.lock.Lock()
+	stale := time.Since(p.indexesLoaded) >= p.IndexTTL
+	p.lock.Unlock()
+
+	if !stale {
+		return nil
+	}
+	return p.LoadIndexes()
+}
+
+func (p *PackingChunkService) readPacked(key *Key) ([]byte, bool, error) {
+	p.lock.Lock()
+	data, isPending := p.pending[*key]
+	location, isPacked := p.locations[*key]
+	p.lock.Unlock()
+
+	if isPending {
+		return data, true, nil
+	}
+	if !isPacked {
+		return nil, false, nil
+	}
+
+	data, err := p.store.GetObjectRange(packObjectName(location.pack), location.offset, location.length)
+	return data, true, err
+}
+
+func (p *PackingChunkService) Get(key *Key) (Resource, error) {
+	data, found, err := p.readPacked(key)
+	if err != nil {
+		// the pack may have been repacked by someone else since the indexes were last read
+		err = p.LoadIndexes()
+		if err != nil {
+			return nil, err
+		}
+		data, found, err = p.readPacked(key)
+	} else if !found {
+		// the chunk may have been packed by someone else since the indexes were last read
+		err = p.loadIndexesIfStale()
+		if err != nil {
+			return nil, err
+		}
+		data, found, err = p.readPacked(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return p.remote.Get(key)
+	}
+
+	return NewMemResource(data), nil
+}
+
+func (p *PackingChunkService) Has(key *Key) (bool, error) {
+	found, err := p.HasMany([]*Key{key})
+	if err != nil {
+		return false, err
+	}
+	return found[0], nil
+}
+
+// Chunks are looked up in the pack indexes first, and any which are not packed are checked on the remote.
+// The indexes are reloaded first if they are stale, as other minions may have packed the same chunks.
+func (p *PackingChunkService) HasMany(keys []*Key) ([]bool, error) {
+	err := p.loadIndexesIfStale()
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]bool, len(keys))
+	unpacked := make([]*Key, 0, len(keys))
+	p.lock.Lock()
+	for i, key := range keys {
+		_, isPacked := p.locations[*key]
+		_, isPending := p.pending[*key]
+		found[i] = isPacked || isPending
+		if !found[i] {
+			unpacked = append(unpacked, key)
+		}
+	}
+	p.lock.Unlock()
+
+	if len(unpacked) == 0 {
+		return found, nil
+	}
+	onRemote, err := hasManyOrNone(p.remote, unpacked)
+	if err != nil {
+		return nil, err
+	}
+	for i := range found {
+		if !found[i] {
+			found[i] = onRemote[0]
+			onRemote = onRemote[1:]
+		}
+	}
+	return found, nil
+}
+
+func (p *PackingChunkService) Put(key *Key, resource Resource) error {
+	if resource.GetLength() > p.MaxPackedChunkSize {
+		return p.remote.Put(key, resource)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, isPacked := p.locations[*key]; isPacked {
+		return nil
+	}
+	if _, isPending := p.pending[*key]; isPending {
+		return nil
+	}
+
+	p.unsafeAddPending(key, resource.AsBytes())
+	if p.pendingBytes >= p.TargetPackSize {
+		return p.unsafeWritePack()
+	}
+
+	return nil
+}
+
+// must be called with lock held
+func (p *PackingChunkService) unsafeAddPending(key *Key, data []byte) {
+	p.pendingKeys = append(p.pendingKeys, key)
+	p.pending[*key] = data
+	p.pendingBytes += int64(len(data))
+}
+
+// Writes any chunks which are waiting to be packed, and records which packed chunks have been deleted.
+// After this returns, every chunk which was Put is readable from the remote.
+func (p *PackingChunkService) Flush() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	err := p.unsafeWritePack()
+	if err != nil {
+		return err
+	}
+	return p.unsafeWriteTombstones()
+}
+
+// must be called with lock held
+func (p *PackingChunkService) unsafeWritePack() error {
+	if len(p.pending) == 0 {
+		return nil
+	}
+
+	var buffer bytes.Buffer
+	pack := &packInfo{entries: make([]*PackEntry, 0, len(p.pending)), deleted: make(map[Key]bool)}
+	for _, key := range p.pendingKeys {
+		data, ok := p.pending[*key]
+		if !ok {
+			// deleted before it was written
+			continue
+		}
+		pack.entries = append(pack.entries, &PackEntry{Key: key.AsBytes(), Offset: proto.Int64(int64(buffer.Len())), Length: proto.Int64(int64(len(data)))})
+		buffer.Write(data)
+	}
+	pack.size = int64(buffer.Len())
+
+	index, err := proto.Marshal(&PackIndex{Entries: pack.entries})
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// the index is written last, so a pack is never listed before all of its content is readable
+	name := computeContentKey(buffer.Bytes()).String()
+	err = p.store.PutObject(packObjectName(name), buffer.Bytes())
+	if err != nil {
+		return err
+	}
+	err = p.store.PutObject(packIndexName(name), index)
+	if err != nil {
+		return err
+	}
+
+	p.packs[name] = pack
+	for _, entry := range pack.entries {
+		key := KeyFromBytes(entry.GetKey())
+		p.locations[*key] = packLocation{pack: name, offset: entry.GetOffset(), length: entry.GetLength()}
+	}
+
+	p.pendingKeys = make([]*Key, 0, 100)
+	p.pending = make(map[Key][]byte)
+	p.pendingBytes = 0
+
+	return nil
+}
+
+type packingKeyIterator struct {
+	keys   []*Key
+	index  int
+	remote KeyIterator
+}
+
+func (it *packingKeyIterator) HasNext() bool {
+	return it.index < len(it.keys) || it.remote.HasNext()
+}
+
+func (it *packingKeyIterator) Next() *Key {
+	if it.index < len(it.keys) {
+		key := it.keys[it.index]
+		it.index++
+		return key
+	}
+	return it.remote.Next()
+}
+
+// Iterates over the packed and pending chunks, followed by those stored on the remote directly.
+func (p *PackingChunkService) Iterate() KeyIterator {
+	p.lock.Lock()
+	keys := make([]*Key, 0, len(p.locations)+len(p.pending))
+	for key := range p.locations {
+		k := key
+		keys = append(keys, &k)
+	}
+	for key := range p.pending {
+		k := key
+		keys = append(keys, &k)
+	}
+	p.lock.Unlock()
+
+	return &packingKeyIterator{keys: keys, remote: p.remote.Iterate()}
+}
+
+type chunkDeleter interface {
+	Delete(key *Key)
+}
+
+// Removes a chunk.  A chunk stored in a pack is marked as deleted, and the space it occupies is not
+// reclaimed until the pack is rewritten by Repack.  The deletion is only seen by others once Flush or
+// Repack has written the pack's tombstones.
+func (p *PackingChunkService) Delete(key *Key) {
+	p.lock.Lock()
+	if data, isPending := p.pending[*key]; isPending {
+		delete(p.pending, *key)
+		p.pendingBytes -= int64(len(data))
+		p.lock.Unlock()
+		return
+	}
+	if location, isPacked := p.locations[*key]; isPacked {
+		delete(p.locations, *key)
+		pack := p.packs[location.pack]
+		pack.deleted[*key] = true
+		pack.tombstonesChanged = true
+		p.lock.Unlock()
+		return
+	}
+	p.lock.Unlock()
+
+	if deleter, ok := p.remote.(chunkDeleter); ok {
+		deleter.Delete(key)
+	}
+}
+
+// Rewrites each pack where less than minLiveFraction of its bytes belong to chunks which have not
+// been deleted.  The live chunks are copied into new packs and the old packs are deleted, and the deletions
+// from the remaining packs are written out.  Intended to be run after a GC has deleted the unreachable
+// chunks.  Returns the number of packs removed.
+func (p *PackingChunkService) Repack(minLiveFraction float64) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	names := make([]string, 0, len(p.packs))
+	for name := range p.packs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	repacked := make([]string, 0)
+	for _, name := range names {
+		pack := p.packs[name]
+
+		live := make([]*PackEntry, 0, len(pack.entries))
+		var liveBytes int64
+		for _, entry := range pack.entries {
+			if p.locations[*KeyFromBytes(entry.GetKey())].pack == name {
+				live = append(live, entry)
+				liveBytes += entry.GetLength()
+			}
+		}
+		if float64(liveBytes) >= minLiveFraction*float64(pack.size) {
+			continue
+		}
+
+		for _, entry := range live {
+			data, err := p.store.GetObjectRange(packObjectName(name), entry.GetOffset(), entry.GetLength())
+			if err != nil {
+				return 0, err
+			}
+			p.unsafeAddPending(KeyFromBytes(entry.GetKey()), data)
+			if p.pendingBytes >= p.TargetPackSize {
+				err = p.unsafeWritePack()
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+		repacked = append(repacked, name)
+	}
+
+	// the live chunks must be readable from their new packs before the old ones go away
+	err := p.unsafeWritePack()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, name := range repacked {
+		hadTombstones := len(p.packs[name].deleted) > 0
+		p.unsafeForgetPack(name)
+		err = p.store.DeleteObject(packIndexName(name))
+		if err != nil {
+			return 0, err
+		}
+		err = p.store.DeleteObject(packObjectName(name))
+		if err != nil {
+			return 0, err
+		}
+		if hadTombstones {
+			err = p.store.DeleteObject(packTombstoneName(name))
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	err = p.unsafeWriteTombstones()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(repacked), nil
+}
+
+type MemPackStore struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func NewMemPackStore() *MemPackStore {
+	return &MemPackStore{objects: make(map[string][]byte)}
+}
+
+func (s *MemPackStore) GetObject(name string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.objects[name], nil
+}
+
+func (s *MemPackStore) PutObject(name string, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.objects[name] = data
+	return nil
+}
+
+func (s *MemPackStore) GetObjectRange(name string, offset int64, length int64) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data, ok := s.objects[name]
+	if !ok {
+		return nil, NO_SUCH_OBJECT
+	}
+	if offset+length > int64(len(data)) {
+		return nil, errors.New("Range extends past end of object")
+	}
+	return data[offset : offset+length], nil
+}
+
+func (s *MemPackStore) DeleteObject(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.objects, name)
+	return nil
+}
+
+func (s *MemPackStore) ListObjects(prefix string) ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	names := make([]string, 0, len(s.objects))
+	for name := range s.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
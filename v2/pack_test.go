@@ -0,0 +1,243 @@
+package v2
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type PackSuite struct{}
+
+var _ = Suite(&PackSuite{})
+
+func countObjects(store *MemPackStore, suffix string) int {
+	names, _ := store.ListObjects(PACK_PREFIX)
+	count := 0
+	for _, name := range names {
+		if strings.HasSuffix(name, suffix) {
+			count++
+		}
+	}
+	return count
+}
+
+func putChunk(c *C, chunks ChunkService, content string) *Key {
+	data := []byte(content)
+	key := computeContentKey(data)
+	c.Assert(chunks.Put(key, NewMemResource(data)), IsNil)
+	return key
+}
+
+func (*PackSuite) TestPackSmallChunks(c *C) {
+	remote := NewMemChunkService()
+	store := NewMemPackStore()
+	packed, err := NewPackingChunkService(remote, store)
+	c.Assert(err, IsNil)
+	packed.MaxPackedChunkSize = 10
+
+	a := putChunk(c, packed, "a")
+	b := putChunk(c, packed, "bb")
+	large := putChunk(c, packed, "larger than ten bytes")
+
+	// only the large chunk was written, the small ones are waiting to fill a pack
+	c.Assert(len(remote.chunks), Equals, 1)
+	c.Assert(countObjects(store, PACK_SUFFIX), Equals, 0)
+	resource, err := packed.Get(b)
+	c.Assert(err, IsNil)
+	c.Assert(string(resource.AsBytes()), Equals, "bb")
+
+	c.Assert(packed.Flush(), IsNil)
+	c.Assert(countObjects(store, PACK_SUFFIX), Equals, 1)
+	c.Assert(countObjects(store, PACK_INDEX_SUFFIX), Equals, 1)
+
+	// a second client finds the chunks via the index
+	other, err := NewPackingChunkService(remote, store)
+	c.Assert(err, IsNil)
+	for key, content := range map[*Key]string{a: "a", b: "bb", large: "larger than ten bytes"} {
+		resource, err := other.Get(key)
+		c.Assert(err, IsNil)
+		c.Assert(string(resource.AsBytes()), Equals, content)
+	}
+
+	// packs written after a client started are found when it next misses, once its indexes are stale
+	d := putChunk(c, packed, "d")
+	c.Assert(packed.Flush(), IsNil)
+	other.IndexTTL = 0
+	resource, err = other.Get(d)
+	c.Assert(err, IsNil)
+	c.Assert(string(resource.AsBytes()), Equals, "d")
+
+	keys := make(map[Key]bool)
+	it := other.Iterate()
+	for it.HasNext() {
+		keys[*it.Next()] = true
+	}
+	c.Assert(len(keys), Equals, 4)
+}
+
+func (*PackSuite) TestHasMany(c *C) {
+	store := NewMemPackStore()
+	packed, err := NewPackingChunkService(NewMemChunkService(), store)
+	c.Assert(err, IsNil)
+	packed.MaxPackedChunkSize = 10
+
+	pending := putChunk(c, packed, "a")
+	large := putChunk(c, packed, "larger than ten bytes")
+	missing := computeContentKey([]byte("missing"))
+
+	found, err := packed.HasMany([]*Key{pending, large, missing})
+	c.Assert(err, IsNil)
+	c.Assert(found, DeepEquals, []bool{true, true, false})
+
+	// another writer sees the chunk once the pack has been written
+	c.Assert(packed.Flush(), IsNil)
+	other, _ := NewPackingChunkService(NewMemChunkService(), store)
+	has, err := other.Has(pending)
+	c.Assert(err, IsNil)
+	c.Assert(has, Equals, true)
+}
+
+// counts the number of times the packs are listed
+type listCountingPackStore struct {
+	*MemPackStore
+	lists int
+}
+
+func (s *listCountingPackStore) ListObjects(prefix string) ([]string, error) {
+	s.lists++
+	return s.MemPackStore.ListObjects(prefix)
+}
+
+func (*PackSuite) TestMissesDoNotListUntilStale(c *C) {
+	store := &listCountingPackStore{MemPackStore: NewMemPackStore()}
+	remote := NewMemChunkService()
+	remote.Put(&Key{1}, NewMemResource([]byte("unpacked")))
+	packed, err := NewPackingChunkService(remote, store)
+	c.Assert(err, IsNil)
+	c.Assert(store.lists, Equals, 1)
+
+	for i := 0; i < 3; i++ {
+		resource, err := packed.Get(&Key{1})
+		c.Assert(err, IsNil)
+		c.Assert(string(resource.AsBytes()), Equals, "unpacked")
+		_, err = packed.Get(&Key{2})
+		c.Assert(err, NotNil)
+	}
+	c.Assert(store.lists, Equals, 1)
+
+	packed.IndexTTL = 0
+	packed.Get(&Key{2})
+	c.Assert(store.lists, Equals, 2)
+}
+
+func (*PackSuite) TestPackFillsTarget(c *C) {
+	store := NewMemPackStore()
+	packed, err := NewPackingChunkService(NewMemChunkService(), store)
+	c.Assert(err, IsNil)
+	packed.TargetPackSize = 4
+
+	putChunk(c, packed, "aa")
+	c.Assert(countObjects(store, PACK_SUFFIX), Equals, 0)
+	putChunk(c, packed, "bb")
+	c.Assert(countObjects(store, PACK_SUFFIX), Equals, 1)
+}
+
+func (*PackSuite) TestRepack(c *C) {
+	remote := NewMemChunkService()
+	store := NewMemPackStore()
+	packed, err := NewPackingChunkService(remote, store)
+	c.Assert(err, IsNil)
+
+	a := putChunk(c, packed, "aaaa")
+	b := putChunk(c, packed, "bbbb")
+	keep := putChunk(c, packed, "keep")
+	c.Assert(packed.Flush(), IsNil)
+	full := putChunk(c, packed, "full")
+	c.Assert(packed.Flush(), IsNil)
+	c.Assert(countObjects(store, PACK_SUFFIX), Equals, 2)
+
+	packed.Delete(a)
+	packed.Delete(b)
+
+	removed, err := packed.Repack(0.5)
+	c.Assert(err, IsNil)
+	c.Assert(removed, Equals, 1)
+	c.Assert(countObjects(store, PACK_SUFFIX), Equals, 2)
+	c.Assert(countObjects(store, PACK_INDEX_SUFFIX), Equals, 2)
+
+	// nothing left to repack
+	removed, err = packed.Repack(0.5)
+	c.Assert(err, IsNil)
+	c.Assert(removed, Equals, 0)
+
+	other, err := NewPackingChunkService(remote, store)
+	c.Assert(err, IsNil)
+	for key, content := range map[*Key]string{keep: "keep", full: "full"} {
+		resource, err := other.Get(key)
+		c.Assert(err, IsNil)
+		c.Assert(string(resource.AsBytes()), Equals, content)
+	}
+	_, stillPacked := other.locations[*a]
+	c.Assert(stillPacked, Equals, false)
+}
+
+func (*PackSuite) TestDeleteSurvivesReload(c *C) {
+	remote := NewMemChunkService()
+	store := NewMemPackStore()
+	packed, err := NewPackingChunkService(remote, store)
+	c.Assert(err, IsNil)
+
+	a := putChunk(c, packed, "aaaa")
+	b := putChunk(c, packed, "bbbb")
+	keep := putChunk(c, packed, "keep")
+	c.Assert(packed.Flush(), IsNil)
+	packed.Delete(a)
+	c.Assert(packed.Flush(), IsNil)
+
+	// a deleted chunk stays deleted for a client which reads the indexes afterwards
+	reloaded, err := NewPackingChunkService(remote, store)
+	c.Assert(err, IsNil)
+	_, err = reloaded.Get(a)
+	c.Assert(err, NotNil)
+	found, err := reloaded.HasMany([]*Key{a, b, keep})
+	c.Assert(err, IsNil)
+	c.Assert(found, DeepEquals, []bool{false, true, true})
+
+	// deletions made by the reloaded client count towards repacking, along with those read back
+	reloaded.Delete(b)
+	removed, err := reloaded.Repack(0.5)
+	c.Assert(err, IsNil)
+	c.Assert(removed, Equals, 1)
+	c.Assert(countObjects(store, PACK_TOMBSTONE_SUFFIX), Equals, 0)
+
+	final, err := NewPackingChunkService(remote, store)
+	c.Assert(err, IsNil)
+	found, err = final.HasMany([]*Key{a, b, keep})
+	c.Assert(err, IsNil)
+	c.Assert(found, DeepEquals, []bool{false, false, true})
+	resource, err := final.Get(keep)
+	c.Assert(err, IsNil)
+	c.Assert(string(resource.AsBytes()), Equals, "keep")
+}
+
+func (*PackSuite) TestPushFlushesPacks(c *C) {
+	cache := newCache(c)
+	store := NewMemPackStore()
+	packed, err := NewPackingChunkService(NewMemChunkService(), store)
+	c.Assert(err, IsNil)
+	chunks := NewChunkCache(packed, cache)
+	ds := NewLeafDirService(chunks)
+	as := NewAtomicState(ds, chunks, cache, NewMemTagService(), NewMemRootMap())
+	ac := &AtomicClient{atomic: as}
+
+	var result string
+	ac.MakeDir(&MakeDirArgs{Path: "a"}, &result)
+	_, err = as.Put(NewPath("a/f1"), NewMemResource([]byte("one")))
+	c.Assert(err, IsNil)
+	_, err = as.Put(NewPath("a/f2"), NewMemResource([]byte("two")))
+	c.Assert(err, IsNil)
+
+	c.Assert(ac.Push(&PushArgs{Source: "a", Tag: "t"}, &result), IsNil)
+	c.Assert(countObjects(store, PACK_SUFFIX), Equals, 1)
+	c.Assert(len(packed.pending), Equals, 0)
+}
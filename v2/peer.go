@@ -0,0 +1,242 @@
+package v2
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Keeps track of which minions have which chunks cached.  Implemented by tagsvc.Client, which asks the master.
+type PeerDirectory interface {
+	FindPeers(key *Key) ([]string, error)
+	AdvertiseChunks(address string, keys []*Key) error
+}
+
+const PEER_CHUNK_PATH = "/chunks/"
+
+// requests for a chunk carry the hex encoded HMAC-SHA256 of the key, computed with the secret shared by the minions
+const PEER_AUTH_HEADER = "X-Pliant-Peer-Auth"
+
+// how often newly cached keys are advertised.  Must be well under tagsvc.PEER_TTL.
+var PEER_ADVERTISE_INTERVAL = 30 * time.Second
+
+// maximum number of keys sent in a single advertisement
+var PEER_ADVERTISE_BATCH = 10000
+
+// how long to wait for a peer to connect and start responding.  Peers are only worth trying if they answer
+// quickly, since the chunk can always be fetched from the remote instead.
+var PEER_CONNECT_TIMEOUT = 2 * time.Second
+
+// how long a whole download from a peer may take
+var PEER_FETCH_TIMEOUT = 30 * time.Second
+
+func peerChunkURL(address string, key *Key) string {
+	return "http://" + address + PEER_CHUNK_PATH + hex.EncodeToString(key.AsBytes())
+}
+
+// The token is specific to the key, so one seen on the wire cannot be used to fetch any other chunk
+func peerAuthToken(secret []byte, key *Key) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(key.AsBytes())
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Serves the chunks held in a ChunkCache to other minions.  Only chunks already in the local cache are
+// served; a request never causes a fetch from the remote.  Requests must be authenticated with secret, which
+// must be the same on every minion.  The content itself is not encrypted, so chunks are readable by anyone who can
+// watch the traffic between minions.
+type PeerServer struct {
+	local  cacheDB
+	secret []byte
+}
+
+func NewPeerServer(chunks *ChunkCache, secret []byte) *PeerServer {
+	return &PeerServer{local: chunks.local, secret: secret}
+}
+
+func (s *PeerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" || !strings.HasPrefix(r.URL.Path, PEER_CHUNK_PATH) {
+		http.NotFound(w, r)
+		return
+	}
+	keyBytes, err := hex.DecodeString(r.URL.Path[len(PEER_CHUNK_PATH):])
+	if err != nil || len(keyBytes) != len(Key{}) {
+		http.Error(w, "invalid key", http.StatusBadRequest)
+		return
+	}
+	key := KeyFromBytes(keyBytes)
+
+	expected := peerAuthToken(s.secret, key)
+	if !hmac.Equal([]byte(r.Header.Get(PEER_AUTH_HEADER)), []byte(expected)) {
+		http.Error(w, "not authorized", http.StatusForbidden)
+		return
+	}
+
+	entry := s.local.Get(key)
+	if entry == nil || entry.resource == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	reader := entry.resource.GetReader()
+	defer closeReader(reader)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", entry.resource.GetLength()))
+	io.Copy(w, reader)
+}
+
+type peerFetcher struct {
+	directory PeerDirectory
+	// the address this minion's PeerServer is reachable at
+	address   string
+	secret    []byte
+	getDestFn func() string
+	client    *http.Client
+
+	// keys cached since the last advertisement.  Guarded by the ChunkCache's lock.
+	unadvertised []*Key
+	// set once the directory has rejected an advertisement.  Guarded by the ChunkCache's lock.
+	refused bool
+}
+
+// Makes the cache try peers listed in directory before fetching a chunk from the remote, and periodically
+// advertises the keys it holds.  address is where this cache's PeerServer can be reached by other minions, and
+// secret is the one the PeerServers were created with.  Chunks from peers are downloaded to files allocated by
+// getDestFn, and verified against their key.
+func (c *ChunkCache) EnablePeers(directory PeerDirectory, address string, secret []byte, getDestFn func() string) {
+	p := &peerFetcher{directory: directory, address: address, secret: secret, getDestFn: getDestFn,
+		client: &http.Client{Timeout: PEER_FETCH_TIMEOUT, Transport: &http.Transport{
+			Dial:                  (&net.Dialer{Timeout: PEER_CONNECT_TIMEOUT}).Dial,
+			ResponseHeaderTimeout: PEER_CONNECT_TIMEOUT}},
+		unadvertised: make([]*Key, 0)}
+
+	if lister, ok := c.local.(interface {
+		Keys() []*Key
+	}); ok {
+		p.unadvertised = lister.Keys()
+	}
+
+	c.lock.Lock()
+	c.peers = p
+	c.lock.Unlock()
+
+	go c.advertiseLoop(p)
+}
+
+// must be called with lock held
+func (c *ChunkCache) unsafeRecordCached(key *Key) {
+	if c.peers != nil && !c.peers.refused {
+		c.peers.unadvertised = append(c.peers.unadvertised, key)
+	}
+}
+
+func (c *ChunkCache) advertiseLoop(p *peerFetcher) {
+	for {
+		c.lock.Lock()
+		keys := p.unadvertised
+		p.unadvertised = make([]*Key, 0)
+		c.lock.Unlock()
+
+		// an empty advertisement still tells the directory we are alive
+		for first := true; first || len(keys) > 0; first = false {
+			batch := keys
+			if len(batch) > PEER_ADVERTISE_BATCH {
+				batch = batch[:PEER_ADVERTISE_BATCH]
+			}
+			keys = keys[len(batch):]
+
+			err := p.directory.AdvertiseChunks(p.address, batch)
+			if err != nil && ErrorCodeOf(err) != ERR_REMOTE_UNAVAILABLE {
+				// the directory refused, and will keep refusing, so stop collecting keys to advertise
+				log.Printf("Chunks will not be advertised to other minions: %s", err)
+				c.lock.Lock()
+				p.refused = true
+				p.unadvertised = nil
+				c.lock.Unlock()
+				return
+			} else if err != nil {
+				log.Printf("Could not advertise chunks: %s", err)
+				c.lock.Lock()
+				p.unadvertised = append(p.unadvertised, batch...)
+				p.unadvertised = append(p.unadvertised, keys...)
+				c.lock.Unlock()
+				break
+			}
+		}
+
+		time.Sleep(PEER_ADVERTISE_INTERVAL)
+	}
+}
+
+// downloads the chunk from a peer, returning an error if it could not be fetched or its content does not
+// match the key
+func (p *peerFetcher) fetchFrom(address string, key *Key) (Resource, error) {
+	req, err := http.NewRequest("GET", peerChunkURL(address, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(PEER_AUTH_HEADER, peerAuthToken(p.secret, key))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, NO_SUCH_CHUNK
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s failed with status %d", address, resp.StatusCode)
+	}
+
+	destFile := p.getDestFn()
+	w, err := os.Create(destFile)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(w, hash), resp.Body)
+	closeErr := w.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && *KeyFromBytes(hash.Sum(nil)) != *key {
+		err = fmt.Errorf("chunk from %s did not match key %s", address, key)
+	}
+	if err != nil {
+		os.Remove(destFile)
+		return nil, err
+	}
+
+	return NewFileResource(destFile)
+}
+
+// returns the chunk from the first peer which has it, or NO_SUCH_CHUNK if none do
+func (p *peerFetcher) fetch(key *Key) (Resource, error) {
+	addresses, err := p.directory.FindPeers(key)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, address := range addresses {
+		if address == p.address {
+			continue
+		}
+		resource, err := p.fetchFrom(address, key)
+		if err == nil {
+			return resource, nil
+		}
+		if err != NO_SUCH_CHUNK {
+			log.Printf("Could not fetch %s from peer %s: %s", key, address, err)
+		}
+	}
+
+	return nil, NO_SUCH_CHUNK
+}
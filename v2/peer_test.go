@@ -0,0 +1,223 @@
+package v2
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type PeerSuite struct{}
+
+var _ = Suite(&PeerSuite{})
+
+type memPeerDirectory struct {
+	lock    sync.Mutex
+	holders map[Key][]string
+}
+
+func (d *memPeerDirectory) FindPeers(key *Key) ([]string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.holders[*key], nil
+}
+
+func (d *memPeerDirectory) AdvertiseChunks(address string, keys []*Key) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, key := range keys {
+		d.holders[*key] = append(d.holders[*key], address)
+	}
+	return nil
+}
+
+func (d *memPeerDirectory) peersOf(key *Key) []string {
+	peers, _ := d.FindPeers(key)
+	return peers
+}
+
+func (*PeerSuite) TestFetchFromPeer(c *C) {
+	directory := &memPeerDirectory{holders: make(map[Key][]string)}
+
+	cacheA := newCache(c)
+	peerA := NewChunkCache(NewMemChunkService(), cacheA)
+	data := []byte("reference genome")
+	key := computeContentKey(data)
+	peerA.Put(key, NewMemResource(data))
+
+	server := httptest.NewServer(NewPeerServer(peerA, []byte("secret")))
+	defer server.Close()
+	addressA := strings.TrimPrefix(server.URL, "http://")
+	peerA.EnablePeers(directory, addressA, []byte("secret"), cacheA.AllocateTempFilename)
+
+	// A advertises what it already has
+	deadline := time.Now().Add(5 * time.Second)
+	for len(directory.peersOf(key)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(directory.peersOf(key), DeepEquals, []string{addressA})
+
+	// B's remote does not have the chunk, so it can only have come from A
+	remoteB := NewMemChunkService()
+	cacheB := newCache(c)
+	peerB := NewChunkCache(remoteB, cacheB)
+	peerB.EnablePeers(directory, "b:1", []byte("secret"), cacheB.AllocateTempFilename)
+
+	resource, err := peerB.Get(key)
+	c.Assert(err, IsNil)
+	c.Assert(resource.AsBytes(), DeepEquals, data)
+}
+
+func (*PeerSuite) TestCorruptPeerIgnored(c *C) {
+	directory := &memPeerDirectory{holders: make(map[Key][]string)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not what was asked for"))
+	}))
+	defer server.Close()
+
+	data := []byte("reference genome")
+	key := computeContentKey(data)
+	directory.AdvertiseChunks(strings.TrimPrefix(server.URL, "http://"), []*Key{key})
+
+	remote := NewMemChunkService()
+	remote.Put(key, NewMemResource(data))
+	cache := newCache(c)
+	chunks := NewChunkCache(remote, cache)
+	chunks.EnablePeers(directory, "b:1", []byte("secret"), cache.AllocateTempFilename)
+
+	resource, err := chunks.Get(key)
+	c.Assert(err, IsNil)
+	c.Assert(resource.AsBytes(), DeepEquals, data)
+}
+
+func (*PeerSuite) TestPeerServerOnlyServesCached(c *C) {
+	chunks := NewChunkCache(NewMemChunkService(), newCache(c))
+	server := httptest.NewServer(NewPeerServer(chunks, []byte("secret")))
+	defer server.Close()
+
+	key := computeContentKey([]byte("x"))
+	req, _ := http.NewRequest("GET", peerChunkURL(strings.TrimPrefix(server.URL, "http://"), key), nil)
+	req.Header.Set(PEER_AUTH_HEADER, peerAuthToken([]byte("secret"), key))
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusNotFound)
+
+	resp, err = http.Get(server.URL + PEER_CHUNK_PATH + "zz")
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusBadRequest)
+}
+
+func (*PeerSuite) TestPeerServerRequiresSecret(c *C) {
+	chunks := NewChunkCache(NewMemChunkService(), newCache(c))
+	data := []byte("reference genome")
+	key := computeContentKey(data)
+	chunks.Put(key, NewMemResource(data))
+	server := httptest.NewServer(NewPeerServer(chunks, []byte("secret")))
+	defer server.Close()
+	url := peerChunkURL(strings.TrimPrefix(server.URL, "http://"), key)
+
+	for _, token := range []string{"", peerAuthToken([]byte("wrong"), key), peerAuthToken([]byte("secret"), computeContentKey([]byte("x")))} {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set(PEER_AUTH_HEADER, token)
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, Equals, http.StatusForbidden)
+	}
+
+	// a minion with a different secret falls back to the remote
+	directory := &memPeerDirectory{holders: make(map[Key][]string)}
+	directory.AdvertiseChunks(strings.TrimPrefix(server.URL, "http://"), []*Key{key})
+	cache := newCache(c)
+	other := NewChunkCache(NewMemChunkService(), cache)
+	other.EnablePeers(directory, "b:1", []byte("wrong"), cache.AllocateTempFilename)
+	_, err := other.Get(key)
+	c.Assert(err, Equals, NO_SUCH_CHUNK)
+}
+
+// a directory whose FindPeers blocks until released
+type blockingPeerDirectory struct {
+	memPeerDirectory
+	started chan bool
+	release chan bool
+}
+
+func (d *blockingPeerDirectory) FindPeers(key *Key) ([]string, error) {
+	d.started <- true
+	<-d.release
+	return nil, nil
+}
+
+func (*PeerSuite) TestCacheUsableWhileFetching(c *C) {
+	directory := &blockingPeerDirectory{memPeerDirectory: memPeerDirectory{holders: make(map[Key][]string)},
+		started: make(chan bool, 1), release: make(chan bool)}
+	remote := NewMemChunkService()
+	remote.Put(&Key{1}, NewMemResource([]byte("remote")))
+	cache := newCache(c)
+	chunks := NewChunkCache(remote, cache)
+	chunks.Put(&Key{2}, NewMemResource([]byte("local")))
+	chunks.EnablePeers(directory, "b:1", []byte("secret"), cache.AllocateTempFilename)
+
+	fetched := make(chan Resource)
+	go func() {
+		resource, err := chunks.Get(&Key{1})
+		c.Check(err, IsNil)
+		fetched <- resource
+	}()
+	<-directory.started
+
+	// the fetch of key 1 is waiting on the directory, but cached chunks can still be read and written
+	resource, err := chunks.Get(&Key{2})
+	c.Assert(err, IsNil)
+	c.Assert(resource.AsBytes(), DeepEquals, []byte("local"))
+	c.Assert(chunks.Put(&Key{3}, NewMemResource([]byte("new"))), IsNil)
+
+	close(directory.release)
+	c.Assert((<-fetched).AsBytes(), DeepEquals, []byte("remote"))
+}
+
+// a directory which rejects every advertisement, as the master does for read-only minions
+type refusingPeerDirectory struct {
+	memPeerDirectory
+	refusals chan bool
+}
+
+func (d *refusingPeerDirectory) AdvertiseChunks(address string, keys []*Key) error {
+	d.refusals <- true
+	return errors.New("Permission denied")
+}
+
+func (*PeerSuite) TestRefusedAdvertisementsStop(c *C) {
+	directory := &refusingPeerDirectory{memPeerDirectory: memPeerDirectory{holders: make(map[Key][]string)},
+		refusals: make(chan bool, 10)}
+	cache := newCache(c)
+	chunks := NewChunkCache(NewMemChunkService(), cache)
+	chunks.Put(&Key{1}, NewMemResource([]byte("cached")))
+	chunks.EnablePeers(directory, "b:1", []byte("secret"), cache.AllocateTempFilename)
+	<-directory.refusals
+
+	// keys cached afterwards are not queued for an advertisement which will never be sent
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		chunks.lock.Lock()
+		refused := chunks.peers.refused
+		chunks.lock.Unlock()
+		if refused || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(chunks.Put(&Key{2}, NewMemResource([]byte("new"))), IsNil)
+	chunks.lock.Lock()
+	c.Assert(chunks.peers.refused, Equals, true)
+	c.Assert(len(chunks.peers.unadvertised), Equals, 0)
+	chunks.lock.Unlock()
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"net/rpc"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/codegangsta/cli"
 	"github.com/pgm/pliant/v2"
+	"github.com/pgm/pliant/v2/chunkd"
 	"github.com/pgm/pliant/v2/s3"
 	"github.com/pgm/pliant/v2/tagsvc"
 	gcfg "gopkg.in/gcfg.v1"
@@ -58,7 +65,7 @@ func main() {
 	app.Usage = "pliant client"
 	app.Flags = []cli.Flag{
 		&cli.StringFlag{Name: "addr", Value: SERVER_BINDING, Usage: "The path to bind for communication"},
-		&cli.StringFlag{Name: "jsonaddr", Value: "", Usage: "The path to bind for communication"},
+		&cli.StringFlag{Name: "jsonaddr", Value: "", Usage: "The loopback address (ie: 127.0.0.1:6001) or Unix socket path to serve JSON-RPC over a websocket at /conn"},
 	}
 	app.Commands = []cli.Command{
 		{
@@ -76,12 +83,13 @@ func main() {
 		{
 			Name:  "mkdir",
 			Usage: "make an empty directory",
+			Flags: []cli.Flag{cli.BoolFlag{Name: "p", Usage: "if set, create any missing parent directories, and do nothing if the directory exists"}},
 			Action: func(c *cli.Context) {
 				ac := connectToServer(c.GlobalString("addr"))
 				var key string
 				expectArgs(c, false, "path")
 				path := c.Args().First()
-				panicIfError(ac.Call("AtomicClient.MakeDir", path, &key))
+				panicIfError(ac.Call("AtomicClient.MakeDir", &v2.MakeDirArgs{Path: path, Parents: c.Bool("p")}, &key))
 				println(key)
 			},
 		},
@@ -99,6 +107,27 @@ func main() {
 						AuthSecret           string
 						CachePath            string
 						PliantServiceAddress string
+						MasterCAFile         string
+						Identity             string
+						// files of at most this many bytes are stored inline in their directory
+						InlineThreshold int64
+						// codec used to compress chunks pushed to the remote: none, gzip or zstd
+						Compression string
+						// if set, chunks are encrypted with the hex encoded key in this file before being pushed
+						EncryptionKeyFile string
+						// convergent (the default) or bucket
+						EncryptionMode string
+						// if set, cached chunks are served to other minions at this host:port, and chunks are
+						// fetched from other minions before going to the bucket.  Requires PeerSecret, and cannot be used
+						// with EncryptionKeyFile because chunks are sent between minions unencrypted.
+						PeerAddress string
+						// shared by all minions which fetch chunks from each other
+						PeerSecret string
+						// if set, the HTTP gateway (file content, listings, push and pull) is served at this host:port
+						HttpAddress string
+						// if set, the length-prefixed protobuf protocol described in data.proto is served at this path
+						// (a Unix socket) or loopback host:port.  There is no authentication, so other hosts are refused.
+						ProtobufAddress string
 					}
 				}{}
 
@@ -116,7 +145,17 @@ func main() {
 				bindAddr := cfg.Minion.PliantServiceAddress
 
 				// contact the master and get the config
-				tagsvcClient := tagsvc.NewClient(cfg.Minion.MasterAddress, []byte(cfg.Minion.AuthSecret))
+				var tlsConfig *tls.Config
+				if cfg.Minion.MasterCAFile != "" {
+					tlsConfig, err = tagsvc.LoadClientTLSConfig(cfg.Minion.MasterCAFile)
+					if err != nil {
+						log.Fatalf("Could not load %s: %s", cfg.Minion.MasterCAFile, err)
+					}
+				}
+				tagsvcClient, err := tagsvc.Dial(cfg.Minion.MasterAddress, cfg.Minion.Identity, []byte(cfg.Minion.AuthSecret), tlsConfig)
+				if err != nil {
+					log.Fatalf("Could not connect to master: %s", err)
+				}
 				config, err := tagsvcClient.GetConfig()
 				if err != nil {
 					panic(err.Error())
@@ -139,10 +178,83 @@ func main() {
 
 				cache, _ := v2.NewFilesystemCacheDB(root, db)
 				tags := tagsvc.NewTagService(tagsvcClient)
-				chunkService := s3.NewS3ChunkService(config.AccessKeyId, config.SecretAccessKey, config.Endpoint, config.Bucket, config.Prefix, cache.AllocateTempFilename)
-				chunks := v2.NewChunkCache(chunkService, cache)
+				var chunkService *s3.S3ChunkService
+				if config.PresignedAccess {
+					chunkService = s3.NewPresignedS3ChunkService(config.Bucket, config.Prefix, tagsvcClient, cache.AllocateTempFilename)
+				} else {
+					chunkService = s3.NewS3ChunkServiceWithOptions(config.AccessKeyId, config.SecretAccessKey, config.S3Options(), config.Bucket, config.Prefix, cache.AllocateTempFilename)
+				}
+				var remote v2.ChunkService = chunkService
+				if config.ChunkServer != "" {
+					if config.PackChunks || config.PresignedAccess {
+						log.Fatalf("ChunkServer cannot be used with PackChunks or PresignedAccess")
+					}
+					remote = chunkd.NewClient(config.ChunkServer, []byte(config.ChunkServerSecret), cache.AllocateTempFilename)
+				} else if config.PackChunks {
+					if config.PresignedAccess {
+						log.Fatalf("PackChunks cannot be used with PresignedAccess")
+					}
+					remote, err = v2.NewPackingChunkService(chunkService, chunkService)
+					if err != nil {
+						log.Fatalf("Could not read pack indexes: %s", err)
+					}
+				}
+				if (cfg.Minion.EncryptionKeyFile != "") != config.EncryptedChunks {
+					// the master only leaves encrypted chunks alone during GC if it knows they are encrypted
+					log.Fatalf("EncryptionKeyFile must be set on minions exactly when EncryptedChunks is set on the master")
+				}
+				if cfg.Minion.EncryptionKeyFile != "" {
+					key, err := v2.LoadEncryptionKey(cfg.Minion.EncryptionKeyFile)
+					if err != nil {
+						log.Fatalf("Could not load %s: %s", cfg.Minion.EncryptionKeyFile, err)
+					}
+					mode := cfg.Minion.EncryptionMode
+					if mode == "" {
+						mode = v2.ENCRYPT_CONVERGENT
+					}
+					remote, err = v2.NewEncryptingChunkService(remote, key, mode, cache.AllocateTempFilename)
+					if err != nil {
+						log.Fatalf("Invalid EncryptionMode %q: %s", mode, err)
+					}
+				}
+				chunks := v2.NewChunkCache(remote, cache)
+				if cfg.Minion.Compression != "" {
+					err = chunks.SetCompression(cfg.Minion.Compression)
+					if err != nil {
+						log.Fatalf("Invalid Compression %q: %s", cfg.Minion.Compression, err)
+					}
+				}
+				if cfg.Minion.PeerAddress != "" {
+					if cfg.Minion.PeerSecret == "" {
+						log.Fatalf("PeerAddress requires PeerSecret")
+					}
+					if cfg.Minion.EncryptionKeyFile != "" {
+						log.Fatalf("PeerAddress cannot be used with EncryptionKeyFile")
+					}
+					secret := []byte(cfg.Minion.PeerSecret)
+					chunks.EnablePeers(tagsvcClient, cfg.Minion.PeerAddress, secret, cache.AllocateTempFilename)
+					go func() {
+						log.Fatal(http.ListenAndServe(cfg.Minion.PeerAddress, v2.NewPeerServer(chunks, secret)))
+					}()
+				}
 				ds := v2.NewLeafDirService(chunks)
 				as := v2.NewAtomicState(ds, chunks, cache, tags, v2.NewDbRootMap(db))
+				as.SetInlineThreshold(cfg.Minion.InlineThreshold)
+				if cfg.Minion.HttpAddress != "" {
+					gateway := v2.NewGateway(as, cfg.Minion.CachePath)
+					go func() {
+						log.Fatal(http.ListenAndServe(cfg.Minion.HttpAddress, gateway))
+					}()
+				}
+				if cfg.Minion.ProtobufAddress != "" {
+					// remove the socket left behind if the minion was killed
+					if info, err := os.Lstat(cfg.Minion.ProtobufAddress); err == nil && info.Mode()&os.ModeSocket != 0 {
+						os.Remove(cfg.Minion.ProtobufAddress)
+					}
+					go func() {
+						log.Fatal(v2.StartProtobufServer(cfg.Minion.ProtobufAddress, as))
+					}()
+				}
 				panicIfError(v2.StartServer(bindAddr, jsonBindAddr, as))
 			},
 		},
@@ -154,14 +266,36 @@ func main() {
 					S3 struct {
 						AccessKeyId     string
 						SecretAccessKey string
-						Endpoint        string
-						Bucket          string
-						Prefix          string
+						// host:port of a non-AWS service, ie: minio
+						Endpoint string
+						Region   string
+						// set for services which don't support virtual-hosted buckets
+						PathStyle  bool
+						DisableSSL bool
+						// size in bytes of each part of a multipart transfer, and the number transferred at once
+						PartSize    int64
+						Concurrency int
+						Bucket      string
+						Prefix      string
 					}
 					Settings struct {
 						Port        int
 						PersistPath string
 						AuthSecret  string
+						RemoteLog   bool
+						TLSCertFile string
+						TLSKeyFile  string
+						ACLFile     string
+						// if set, minions are given presigned URLs instead of the S3 credentials
+						PresignedAccess bool
+						// if set, small chunks are bundled into packs
+						PackChunks bool
+						// if set, minions store chunks on the chunkd at this URL instead of in the bucket
+						ChunkServer string
+						// the content of the chunkd's secret file
+						ChunkServerSecret string
+						// must be set if minions use EncryptionKeyFile.  GC is then refused.
+						EncryptedChunks bool
 					}
 				}{}
 
@@ -178,13 +312,27 @@ func main() {
 				fd.Close()
 
 				config := &tagsvc.Config{AccessKeyId: cfg.S3.AccessKeyId,
-					SecretAccessKey: cfg.S3.SecretAccessKey,
-					Endpoint:        cfg.S3.Endpoint,
-					Bucket:          cfg.S3.Bucket,
-					Prefix:          cfg.S3.Prefix,
-					MasterPort:      cfg.Settings.Port,
-					PersistPath:     cfg.Settings.PersistPath,
-					AuthSecret:      cfg.Settings.AuthSecret}
+					SecretAccessKey:   cfg.S3.SecretAccessKey,
+					Endpoint:          cfg.S3.Endpoint,
+					Region:            cfg.S3.Region,
+					PathStyle:         cfg.S3.PathStyle,
+					DisableSSL:        cfg.S3.DisableSSL,
+					PartSize:          cfg.S3.PartSize,
+					Concurrency:       cfg.S3.Concurrency,
+					Bucket:            cfg.S3.Bucket,
+					Prefix:            cfg.S3.Prefix,
+					MasterPort:        cfg.Settings.Port,
+					PersistPath:       cfg.Settings.PersistPath,
+					AuthSecret:        cfg.Settings.AuthSecret,
+					RemoteLog:         cfg.Settings.RemoteLog,
+					TLSCertFile:       cfg.Settings.TLSCertFile,
+					TLSKeyFile:        cfg.Settings.TLSKeyFile,
+					ACLFile:           cfg.Settings.ACLFile,
+					PresignedAccess:   cfg.Settings.PresignedAccess,
+					PackChunks:        cfg.Settings.PackChunks,
+					ChunkServer:       cfg.Settings.ChunkServer,
+					ChunkServerSecret: cfg.Settings.ChunkServerSecret,
+					EncryptedChunks:   cfg.Settings.EncryptedChunks}
 				_, err = tagsvc.StartServer(config)
 				if err != nil {
 					log.Fatalf("StartServer failed %s", err)
@@ -193,6 +341,32 @@ func main() {
 				select {}
 			},
 		},
+		{
+			Name:  "chunkd",
+			Usage: "serves the chunks stored in a directory over HTTP, for use as a minion's remote instead of S3",
+			Flags: []cli.Flag{cli.StringFlag{Name: "listen", Value: "127.0.0.1:8090", Usage: "the host:port to serve on"},
+				cli.StringFlag{Name: "secretfile", Usage: "a file holding the secret which clients must authenticate with"}},
+			Action: func(c *cli.Context) {
+				expectArgs(c, false, "directory")
+				if c.String("secretfile") == "" {
+					log.Fatalf("chunkd requires --secretfile")
+				}
+				secret, err := ioutil.ReadFile(c.String("secretfile"))
+				if err != nil {
+					log.Fatalf("Could not read %s: %s", c.String("secretfile"), err)
+				}
+				secret = bytes.TrimSpace(secret)
+				if len(secret) == 0 {
+					log.Fatalf("%s is empty", c.String("secretfile"))
+				}
+				store, err := chunkd.NewDirChunkService(c.Args().Get(0))
+				if err != nil {
+					log.Fatalf("Could not open %s: %s", c.Args().Get(0), err)
+				}
+				log.Printf("Serving chunks on %s", c.String("listen"))
+				log.Fatal(http.ListenAndServe(c.String("listen"), chunkd.NewServer(store, filepath.Join(c.Args().Get(0), "tmp"), secret)))
+			},
+		},
 		{
 			Name:  "gc",
 			Usage: "Runs GC",
@@ -203,6 +377,7 @@ func main() {
 		{
 			Name:  "link",
 			Usage: "link the given key into the specified path",
+			Flags: []cli.Flag{cli.BoolFlag{Name: "p", Usage: "if set, create any missing parent directories"}},
 			Action: func(c *cli.Context) {
 				ac := connectToServer(c.GlobalString("addr"))
 				var result string
@@ -212,7 +387,7 @@ func main() {
 				path := c.Args().Get(1)
 				isDir := true
 
-				panicIfError(ac.Call("AtomicClient.Link", &v2.LinkArgs{Key: key, Path: path, IsDir: isDir}, &result))
+				panicIfError(ac.Call("AtomicClient.Link", &v2.LinkArgs{Key: key, Path: path, IsDir: isDir, Parents: c.Bool("p")}, &result))
 
 				println(result)
 			},
@@ -247,9 +422,28 @@ func main() {
 				println(result)
 			},
 		},
+		{
+			Name:  "export",
+			Usage: "export path localpath: copy a file or directory out to localpath, restoring modes, mtimes, xattrs and symlinks",
+			Action: func(c *cli.Context) {
+				ac := connectToServer(c.GlobalString("addr"))
+				var result string
+
+				expectArgs(c, false, "path", "localpath")
+				path := c.Args().Get(0)
+
+				absLocalPath, err := filepath.Abs(c.Args().Get(1))
+				if err != nil {
+					panic(err.Error())
+				}
+
+				panicIfError(ac.Call("AtomicClient.Export", &v2.ExportArgs{Path: path, LocalPath: absLocalPath}, &result))
+			},
+		},
 		{
 			Name:  "put",
 			Usage: "put local file into specified path",
+			Flags: []cli.Flag{cli.BoolFlag{Name: "p", Usage: "if set, create any missing parent directories"}},
 			Action: func(c *cli.Context) {
 				ac := connectToServer(c.GlobalString("addr"))
 				var result string
@@ -263,7 +457,7 @@ func main() {
 					panic(err.Error())
 				}
 
-				panicIfError(ac.Call("AtomicClient.PutLocalPath", &v2.PutLocalPathArgs{LocalPath: absLocalPath, DestPath: remotepath}, &result))
+				panicIfError(ac.Call("AtomicClient.PutLocalPath", &v2.PutLocalPathArgs{LocalPath: absLocalPath, DestPath: remotepath, Parents: c.Bool("p")}, &result))
 
 				println(result)
 			},
@@ -290,13 +484,88 @@ func main() {
 						var prefix string
 						if rec.IsDir {
 							prefix = "d"
+						} else if rec.IsSymlink {
+							prefix = "l"
 						} else {
 							prefix = "-"
 						}
-						creationTime := time.Unix(rec.CreationTime, 0)
-						fmt.Printf("%s % 12d  % 12d  %s  %s\n", prefix, rec.Size, rec.TotalSize, creationTime.Local().Format(time.UnixDate), rec.Name)
+						if rec.Mode != 0 {
+							prefix += os.FileMode(rec.Mode).Perm().String()[1:]
+						}
+						modTime := time.Unix(rec.CreationTime, 0)
+						if rec.Mtime != 0 {
+							modTime = time.Unix(0, rec.Mtime)
+						}
+						name := rec.Name
+						if rec.IsSymlink {
+							name += " -> " + rec.SymlinkTarget
+						}
+						fmt.Printf("%s % 12d  % 12d  %s  %s\n", prefix, rec.Size, rec.TotalSize, modTime.Local().Format(time.UnixDate), name)
+					}
+				}
+			},
+		},
+		{
+			Name:  "diff",
+			Usage: "diff a b: list what changed between two directories, each given as a path or a tag",
+			Flags: []cli.Flag{cli.BoolFlag{Name: "json", Usage: "if set, print the changes as a JSON list"}},
+			Action: func(c *cli.Context) {
+				ac := connectToServer(c.GlobalString("addr"))
+				var result []v2.DiffEntry
+
+				expectArgs(c, false, "a", "b")
+				a := c.Args().Get(0)
+				b := c.Args().Get(1)
+
+				panicIfError(ac.Call("AtomicClient.Diff", &v2.DiffArgs{A: a, B: b}, &result))
+
+				if c.Bool("json") {
+					buffer, err := json.MarshalIndent(result, "", "  ")
+					panicIfError(err)
+					fmt.Printf("%s\n", buffer)
+					return
+				}
+
+				for _, entry := range result {
+					var prefix string
+					if entry.Change == v2.DIFF_ADDED {
+						prefix = "A"
+					} else if entry.Change == v2.DIFF_REMOVED {
+						prefix = "D"
+					} else {
+						prefix = "M"
+					}
+					if entry.IsDir {
+						fmt.Printf("%s  %s/\n", prefix, entry.Path)
+					} else {
+						fmt.Printf("%s  %s\n", prefix, entry.Path)
+					}
+				}
+			},
+		},
+		{
+			Name:  "merge",
+			Usage: "merge base ours theirs: combine the changes made to base in ours and in theirs, each given as a path or a tag",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "dest", Usage: "if set, and there were no conflicts, link the merged directory to this path"},
+				cli.StringFlag{Name: "push", Usage: "if set, and there were no conflicts, push the merged directory to this tag, provided the tag still points to ours"},
+			},
+			Action: func(c *cli.Context) {
+				ac := connectToServer(c.GlobalString("addr"))
+				var result v2.MergeResult
+
+				expectArgs(c, false, "base", "ours", "theirs")
+				args := &v2.MergeArgs{Base: c.Args().Get(0), Ours: c.Args().Get(1), Theirs: c.Args().Get(2), Destination: c.String("dest"), Tag: c.String("push")}
+
+				panicIfError(ac.Call("AtomicClient.Merge", args, &result))
+
+				if len(result.Conflicts) > 0 {
+					for _, conflict := range result.Conflicts {
+						fmt.Printf("C  %s\n", conflict.Path)
 					}
+					log.Fatalf("%d conflicts, nothing was linked or pushed", len(result.Conflicts))
 				}
+				fmt.Printf("%s\n", result.Key)
 			},
 		},
 		{
@@ -328,13 +597,33 @@ func main() {
 				panicIfError(ac.Call("AtomicClient.Pull", &v2.PullArgs{Tag: label, Destination: path}, &result))
 			},
 		},
+		{
+			Name:  "tag",
+			Usage: "manage tags",
+			Subcommands: []cli.Command{
+				{
+					Name:  "rm",
+					Usage: "remove the given tag",
+					Action: func(c *cli.Context) {
+						ac := connectToServer(c.GlobalString("addr"))
+
+						var result string
+						expectArgs(c, false, "label")
+						label := c.Args().Get(0)
+
+						panicIfError(ac.Call("AtomicClient.DeleteTag", label, &result))
+					},
+				},
+			},
+		},
 		{
 			Name:  "roots",
-			Usage: "list roots",
+			Usage: "list roots, optionally only those starting with the given prefix",
 			Action: func(c *cli.Context) {
 				ac := connectToServer(c.GlobalString("addr"))
 
-				var prefix string = ""
+				expectArgs(c, true)
+				var prefix string = c.Args().First()
 				var result []v2.ListRootsRecord
 
 				panicIfError(ac.Call("AtomicClient.ListRoots", &prefix, &result))
@@ -344,6 +633,69 @@ func main() {
 				}
 			},
 		},
+		{
+			Name:  "stats",
+			Usage: "show how much space the chunks pushed by this minion took up on the remote",
+			Action: func(c *cli.Context) {
+				ac := connectToServer(c.GlobalString("addr"))
+
+				expectArgs(c, false)
+				var nothing string
+				var stats v2.CompressionStats
+				panicIfError(ac.Call("AtomicClient.GetCompressionStats", &nothing, &stats))
+
+				ratio := 1.0
+				if stats.RawBytes > 0 {
+					ratio = float64(stats.StoredBytes) / float64(stats.RawBytes)
+				}
+				fmt.Printf("chunks pushed:\t%d (%d compressed)\n", stats.Chunks, stats.CompressedChunks)
+				fmt.Printf("bytes before encoding:\t%d\n", stats.RawBytes)
+				fmt.Printf("bytes stored:\t%d (%.1f%%)\n", stats.StoredBytes, ratio*100)
+			},
+		},
+		{
+			Name:  "watch",
+			Usage: "watch prefix -- command: run command with the new key as its last argument each time a tag starting with prefix changes",
+			Action: func(c *cli.Context) {
+				ac := connectToServer(c.GlobalString("addr"))
+
+				args := []string(c.Args())
+				if len(args) > 1 && args[1] == "--" {
+					args = append(args[:1], args[2:]...)
+				}
+				if len(args) < 2 {
+					log.Fatalf("Expected arguments: prefix, command")
+				}
+				prefix := args[0]
+				command := args[1:]
+
+				// find out where the log is now, so we only react to changes made from here on
+				var reply v2.WatchRootsReply
+				panicIfError(ac.Call("AtomicClient.WatchRoots", &v2.WatchRootsArgs{Prefix: prefix, SinceSeq: 0, TimeoutSeconds: 0}, &reply))
+				seq := reply.Seq
+
+				for {
+					reply = v2.WatchRootsReply{}
+					panicIfError(ac.Call("AtomicClient.WatchRoots", &v2.WatchRootsArgs{Prefix: prefix, SinceSeq: seq, TimeoutSeconds: 60}, &reply))
+					seq = reply.Seq
+
+					for _, change := range reply.Changes {
+						if change.Key == nil {
+							continue
+						}
+
+						cmd := exec.Command(command[0], append(command[1:], change.Key.String())...)
+						cmd.Env = append(os.Environ(), "PLIANT_TAG="+change.Name, "PLIANT_KEY="+change.Key.String())
+						cmd.Stdout = os.Stdout
+						cmd.Stderr = os.Stderr
+						err := cmd.Run()
+						if err != nil {
+							log.Printf("%s failed for %s: %s", command[0], change.Name, err)
+						}
+					}
+				}
+			},
+		},
 	}
 
 	app.Run(os.Args)
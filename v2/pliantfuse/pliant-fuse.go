@@ -7,6 +7,8 @@ import (
 	"log"
 	"net/rpc"
 	"os"
+	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -75,6 +77,29 @@ func connectToServer(addr string) *rpc.Client {
 	return client
 }
 
+// errno reported for each kind of error the pliant service returns
+var errnoByCode = map[v2.ErrorCode]fuse.Errno{
+	v2.ERR_NOT_FOUND:          fuse.ENOENT,
+	v2.ERR_EXISTS:             fuse.EEXIST,
+	v2.ERR_NOT_DIR:            fuse.Errno(syscall.ENOTDIR),
+	v2.ERR_CONFLICT:           fuse.Errno(syscall.EBUSY),
+	v2.ERR_REMOTE_UNAVAILABLE: fuse.Errno(syscall.EAGAIN),
+	v2.ERR_CORRUPT:            fuse.EIO,
+}
+
+// Converts an error from a call to the pliant service into the errno returned to the kernel.  Errors
+// without a code are reported as EIO.
+func toErrno(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errno, ok := errnoByCode[v2.ErrorCodeOf(err)]; ok {
+		return errno
+	}
+	log.Printf("Unexpected error: %s", err)
+	return fuse.EIO
+}
+
 // Dir implements both Node and Handle for the root directory.
 type Dir struct {
 	path   string
@@ -91,8 +116,7 @@ func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 	filename := d.path + "/" + name
 	err := d.client.Call("AtomicClient.Stat", filename, &result)
 	if err != nil {
-		//		return nil, err
-		return nil, fuse.ENOENT
+		return nil, toErrno(err)
 	}
 
 	if result.Error == v2.STAT_ERROR_MISSING {
@@ -100,9 +124,12 @@ func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 	} else if result.IsDir {
 		fmt.Printf("lookup(%s) -> dir\n", filename)
 		return &Dir{path: filename, client: d.client}, nil
+	} else if result.IsSymlink {
+		fmt.Printf("lookup(%s) -> symlink\n", filename)
+		return &Symlink{target: result.SymlinkTarget, mtime: modTime(&result)}, nil
 	} else {
 		fmt.Printf("lookup(%s) -> file\n", filename)
-		return &File{path: filename, client: d.client, size: uint64(result.Size)}, nil
+		return &File{path: filename, client: d.client, size: uint64(result.Size), mode: os.FileMode(result.Mode), mtime: modTime(&result)}, nil
 	}
 }
 
@@ -110,13 +137,15 @@ func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	var result []v2.ListFilesRecord
 	err := d.client.Call("AtomicClient.ListFiles", d.path, &result)
 	if err != nil {
-		return nil, err
+		return nil, toErrno(err)
 	}
 	dirDirs := make([]fuse.Dirent, len(result))
 	for i := 0; i < len(result); i++ {
 		dirDirs[i].Name = result[i].Name
 		if result[i].IsDir {
 			dirDirs[i].Type = fuse.DT_Dir
+		} else if result[i].IsSymlink {
+			dirDirs[i].Type = fuse.DT_Link
 		} else {
 			dirDirs[i].Type = fuse.DT_File
 		}
@@ -124,11 +153,38 @@ func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	return dirDirs, nil
 }
 
+// the modification time recorded when the file was added, falling back to when it was linked
+func modTime(result *v2.StatResponse) time.Time {
+	if result.Mtime != 0 {
+		return time.Unix(0, result.Mtime)
+	}
+	return time.Unix(result.CreationTime, 0)
+}
+
+type Symlink struct {
+	target string
+	mtime  time.Time
+}
+
+func (s *Symlink) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeSymlink | 0777
+	a.Size = uint64(len(s.target))
+	a.Mtime = s.mtime
+	return nil
+}
+
+func (s *Symlink) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	return s.target, nil
+}
+
 // File implements both Node and Handle for the hello file.
 type File struct {
 	path   string
 	client *rpc.Client
 	size   uint64
+	// permission bits, or 0 if unknown
+	mode  os.FileMode
+	mtime time.Time
 }
 
 type FileHandle struct {
@@ -140,7 +196,12 @@ const greeting = "hello, world\n"
 func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
 	//	a.Inode = 2
 	a.Mode = 0444
+	if f.mode != 0 {
+		// the filesystem is read-only, so only keep the read and execute bits
+		a.Mode = f.mode & 0555
+	}
 	a.Size = f.size
+	a.Mtime = f.mtime
 	return nil
 }
 
@@ -153,7 +214,7 @@ func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenR
 
 	err := f.client.Call("AtomicClient.GetLocalPath", f.path, &localPath)
 	if err != nil {
-		return nil, err
+		return nil, toErrno(err)
 	}
 
 	// FIXME: make sure this is not already open
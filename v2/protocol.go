@@ -0,0 +1,401 @@
+package v2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// The largest message ReadMessage will accept.  Requests never carry file content, so this only guards
+// against a corrupt length prefix.
+var MAX_MESSAGE_SIZE uint32 = 64 * 1024 * 1024
+
+// Writes msg preceded by its length as a 4 byte big-endian integer
+func WriteMessage(w io.Writer, msg proto.Message) error {
+	buffer, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 4+len(buffer))
+	binary.BigEndian.PutUint32(frame, uint32(len(buffer)))
+	copy(frame[4:], buffer)
+	_, err = w.Write(frame)
+	return err
+}
+
+// Reads a message written by WriteMessage into msg
+func ReadMessage(r io.Reader, msg proto.Message) error {
+	var header [4]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return err
+	}
+
+	length := binary.BigEndian.Uint32(header[:])
+	if length > MAX_MESSAGE_SIZE {
+		return fmt.Errorf("message of %d bytes is larger than the limit of %d", length, MAX_MESSAGE_SIZE)
+	}
+	buffer := make([]byte, length)
+	_, err = io.ReadFull(r, buffer)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(buffer, msg)
+}
+
+// Serves the AtomicClient operations using the Request and Response messages in data.proto, so that clients
+// in other languages can be generated from it.
+type ProtobufServer struct {
+	ac *AtomicClient
+}
+
+func NewProtobufServer(atomic Atomic) *ProtobufServer {
+	return &ProtobufServer{ac: &AtomicClient{atomic: atomic}}
+}
+
+// Listens on bindAddr and serves connections until the listener fails.  There is no authentication and
+// PUT_LOCAL_PATH reads any file the minion can, so only local clients may connect: a bindAddr containing a
+// "/" is a Unix socket path which only the owner can use, and a TCP address must be on a loopback interface.
+// A TCP address without a host (ie: ":6002") is bound to 127.0.0.1.
+func StartProtobufServer(bindAddr string, atomic Atomic) error {
+	l, err := listenLocal(bindAddr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	log.Printf("Ready to accept protobuf requests via %s\n", l.Addr())
+	return NewProtobufServer(atomic).Accept(l)
+}
+
+func listenLocal(bindAddr string) (net.Listener, error) {
+	if strings.Contains(bindAddr, "/") {
+		l, err := net.Listen("unix", bindAddr)
+		if err != nil {
+			return nil, err
+		}
+		err = os.Chmod(bindAddr, 0600)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+
+	host, port, err := net.SplitHostPort(bindAddr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	} else if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("Refusing to serve protobuf requests on %s: only loopback addresses and Unix sockets are allowed", bindAddr)
+	}
+	return net.Listen("tcp", net.JoinHostPort(host, port))
+}
+
+func (s *ProtobufServer) Accept(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// Answers each request on conn in turn until the client disconnects
+func (s *ProtobufServer) ServeConn(conn io.ReadWriteCloser) {
+	defer conn.Close()
+
+	for {
+		req := &Request{}
+		err := ReadMessage(conn, req)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Closing protobuf connection: %s", err)
+			}
+			return
+		}
+
+		err = WriteMessage(conn, s.Handle(req))
+		if err != nil {
+			log.Printf("Closing protobuf connection: %s", err)
+			return
+		}
+	}
+}
+
+func errorResponse(err error) *Response {
+	resp := &Response{IsSuccess: proto.Bool(false), Error: proto.String(err.Error())}
+	if code := ErrorCodeOf(err); code != "" {
+		resp.ErrorCode = proto.String(string(code))
+	}
+	return resp
+}
+
+// returns nil for an empty string, so that unset values are left out of the message
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return proto.String(value)
+}
+
+// Performs a single request.  Failures are reported in the Response rather than returned.
+func (s *ProtobufServer) Handle(req *Request) *Response {
+	resp, err := s.handle(req)
+	if err != nil {
+		return errorResponse(err)
+	}
+	resp.IsSuccess = proto.Bool(true)
+	return resp
+}
+
+func (s *ProtobufServer) handle(req *Request) (*Response, error) {
+	resp := &Response{}
+	var ignored string
+	missing := fmt.Errorf("%s request is missing its arguments", req.GetType())
+
+	switch req.GetType() {
+	case Request_GET_KEY:
+		if req.GetKey == nil {
+			return nil, missing
+		}
+		var key string
+		err := s.ac.GetKey(req.GetKey.GetPath(), &key)
+		if err != nil {
+			return nil, err
+		}
+		resp.GetKey = &GetKeyResp{IsSuccess: proto.Bool(true), Key: proto.String(key)}
+
+	case Request_GET_LOCAL_PATH:
+		if req.GetLocalPath == nil {
+			return nil, missing
+		}
+		var localPath string
+		err := s.ac.GetLocalPath(req.GetLocalPath.GetPath(), &localPath)
+		if err != nil {
+			return nil, err
+		}
+		resp.GetLocalPath = &GetLocalPathResp{IsSuccess: proto.Bool(true), Path: proto.String(localPath)}
+
+	case Request_PUT_LOCAL_PATH:
+		args := req.PutLocalPath
+		if args == nil {
+			return nil, missing
+		}
+		return resp, s.ac.PutLocalPath(&PutLocalPathArgs{LocalPath: args.GetLocalPath(), DestPath: args.GetPath(), Parents: args.GetParents()}, &ignored)
+
+	case Request_LINK:
+		args := req.Link
+		if args == nil {
+			return nil, missing
+		}
+		return resp, s.ac.Link(&LinkArgs{Key: args.GetKey(), Path: args.GetPath(), IsDir: args.GetIsDir(), Parents: args.GetParents()}, &ignored)
+
+	case Request_UNLINK:
+		if req.Unlink == nil {
+			return nil, missing
+		}
+		return resp, s.ac.Unlink(req.Unlink.GetPath(), &ignored)
+
+	case Request_PUSH:
+		if req.Push == nil {
+			return nil, missing
+		}
+		return resp, s.ac.Push(&PushArgs{Source: req.Push.GetSource(), Tag: req.Push.GetTag()}, &ignored)
+
+	case Request_PULL:
+		if req.Pull == nil {
+			return nil, missing
+		}
+		return resp, s.ac.Pull(&PullArgs{Tag: req.Pull.GetTag(), Destination: req.Pull.GetDestination()}, &ignored)
+
+	case Request_DELETE_TAG:
+		if req.DeleteTag == nil {
+			return nil, missing
+		}
+		return resp, s.ac.DeleteTag(req.DeleteTag.GetTag(), &ignored)
+
+	case Request_LIST_ROOTS:
+		var records []ListRootsRecord
+		err := s.ac.ListRoots(req.GetListRoots().GetPrefix(), &records)
+		if err != nil {
+			return nil, err
+		}
+		resp.ListRoots = &ListRootsResp{Roots: make([]*RootEntry, len(records))}
+		for i, record := range records {
+			resp.ListRoots.Roots[i] = &RootEntry{Name: proto.String(record.Name), Key: proto.String(record.Key.String())}
+		}
+
+	case Request_WATCH_ROOTS:
+		args := req.GetWatchRoots()
+		reply := &WatchRootsReply{}
+		err := s.ac.WatchRoots(&WatchRootsArgs{Prefix: args.GetPrefix(), SinceSeq: args.GetSinceSeq(), TimeoutSeconds: int(args.GetTimeoutSeconds())}, reply)
+		if err != nil {
+			return nil, err
+		}
+		resp.WatchRoots = &WatchRootsResp{Seq: proto.Uint64(reply.Seq), Changes: make([]*TagChangeEntry, len(reply.Changes))}
+		for i, change := range reply.Changes {
+			entry := &TagChangeEntry{Seq: proto.Uint64(change.Seq), Name: proto.String(change.Name)}
+			if change.Key != nil {
+				entry.Key = proto.String(change.Key.String())
+			}
+			resp.WatchRoots.Changes[i] = entry
+		}
+
+	case Request_GET_COMPRESSION_STATS:
+		var stats CompressionStats
+		s.ac.GetCompressionStats(nil, &stats)
+		resp.CompressionStats = &CompressionStatsResp{Chunks: proto.Int64(stats.Chunks), CompressedChunks: proto.Int64(stats.CompressedChunks),
+			RawBytes: proto.Int64(stats.RawBytes), StoredBytes: proto.Int64(stats.StoredBytes)}
+
+	case Request_DIFF:
+		if req.Diff == nil {
+			return nil, missing
+		}
+		var entries []DiffEntry
+		err := s.ac.Diff(&DiffArgs{A: req.Diff.GetA(), B: req.Diff.GetB()}, &entries)
+		if err != nil {
+			return nil, err
+		}
+		resp.Diff = &DiffResp{Entries: make([]*DiffRecord, len(entries))}
+		for i, entry := range entries {
+			resp.Diff.Entries[i] = &DiffRecord{Path: proto.String(entry.Path), Change: proto.String(entry.Change), IsDir: proto.Bool(entry.IsDir),
+				OldKey: optionalString(entry.OldKey), NewKey: optionalString(entry.NewKey), OldSize: proto.Int64(entry.OldSize), NewSize: proto.Int64(entry.NewSize)}
+		}
+
+	case Request_MERGE:
+		args := req.Merge
+		if args == nil {
+			return nil, missing
+		}
+		result := &MergeResult{}
+		err := s.ac.Merge(&MergeArgs{Base: args.GetBase(), Ours: args.GetOurs(), Theirs: args.GetTheirs(), Destination: args.GetDestination(), Tag: args.GetTag()}, result)
+		if err != nil {
+			return nil, err
+		}
+		resp.Merge = &MergeResp{Key: proto.String(result.Key), Conflicts: make([]*MergeConflictRecord, len(result.Conflicts))}
+		for i, conflict := range result.Conflicts {
+			resp.Merge.Conflicts[i] = &MergeConflictRecord{Path: proto.String(conflict.Path), BaseKey: optionalString(conflict.BaseKey),
+				OursKey: optionalString(conflict.OursKey), TheirsKey: optionalString(conflict.TheirsKey)}
+		}
+
+	case Request_LIST_FILES:
+		if req.ListFiles == nil {
+			return nil, missing
+		}
+		var records []ListFilesRecord
+		err := s.ac.ListFiles(req.ListFiles.GetPath(), &records)
+		if err != nil {
+			return nil, err
+		}
+		resp.ListFiles = &ListFilesResp{Entries: make([]*LeafRecordEntry, len(records))}
+		for i, record := range records {
+			metadata := &FileMetadata{Size: proto.Int64(record.Size), CreationTime: proto.Int64(record.CreationTime), IsDir: proto.Bool(record.IsDir),
+				TotalSize: proto.Int64(record.TotalSize), Mode: proto.Uint32(record.Mode), Mtime: proto.Int64(record.Mtime), IsSymlink: proto.Bool(record.IsSymlink),
+				SymlinkTarget: optionalString(record.SymlinkTarget)}
+			resp.ListFiles.Entries[i] = &LeafRecordEntry{Name: proto.String(record.Name), Metadata: metadata}
+		}
+
+	case Request_MAKE_DIR:
+		if req.MakeDir == nil {
+			return nil, missing
+		}
+		return resp, s.ac.MakeDir(&MakeDirArgs{Path: req.MakeDir.GetPath(), Parents: req.MakeDir.GetParents()}, &ignored)
+
+	case Request_STAT:
+		if req.Stat == nil {
+			return nil, missing
+		}
+		stat := &StatResponse{}
+		err := s.ac.Stat(req.Stat.GetPath(), stat)
+		if err != nil {
+			return nil, err
+		}
+		resp.Stat = &StatResp{}
+		if stat.Error != STAT_ERROR_MISSING {
+			metadata := &FileMetadata{Size: proto.Int64(stat.Size), Key: stat.Key, CreationTime: proto.Int64(stat.CreationTime), IsDir: proto.Bool(stat.IsDir),
+				TotalSize: proto.Int64(stat.TotalSize), Mode: proto.Uint32(stat.Mode), Mtime: proto.Int64(stat.Mtime), IsSymlink: proto.Bool(stat.IsSymlink),
+				SymlinkTarget: optionalString(stat.SymlinkTarget)}
+			names := make([]string, 0, len(stat.Xattrs))
+			for name := range stat.Xattrs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				metadata.Xattrs = append(metadata.Xattrs, &Xattr{Name: proto.String(name), Value: stat.Xattrs[name]})
+			}
+			resp.Stat.Metadata = metadata
+		}
+
+	case Request_APPLY_BATCH:
+		if req.ApplyBatch == nil {
+			return nil, missing
+		}
+		ops := make([]BatchOpArgs, len(req.ApplyBatch.Ops))
+		for i, op := range req.ApplyBatch.Ops {
+			ops[i] = BatchOpArgs{Op: op.GetOp(), Path: op.GetPath(), Key: op.GetKey(), IsDir: op.GetIsDir(), LocalPath: op.GetLocalPath()}
+		}
+		return resp, s.ac.ApplyBatch(ops, &ignored)
+
+	default:
+		return nil, fmt.Errorf("unknown request type %d", req.GetType())
+	}
+
+	return resp, nil
+}
+
+// Sends Requests to a ProtobufServer.  Not safe for concurrent use, because responses are matched to
+// requests by their order.
+type ProtobufClient struct {
+	conn io.ReadWriteCloser
+}
+
+func NewProtobufClient(conn io.ReadWriteCloser) *ProtobufClient {
+	return &ProtobufClient{conn: conn}
+}
+
+func DialProtobuf(address string) (*ProtobufClient, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return NewProtobufClient(conn), nil
+}
+
+// Returns the response to req.  A failed request is returned as an error, with the same code (and for
+// sentinels, the same value) it had in the server.
+func (c *ProtobufClient) Call(req *Request) (*Response, error) {
+	err := WriteMessage(c.conn, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &Response{}
+	err = ReadMessage(c.conn, resp)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.GetIsSuccess() {
+		return nil, ParseError(rpc.ServerError(resp.GetError()))
+	}
+	return resp, nil
+}
+
+func (c *ProtobufClient) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,141 @@
+package v2
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/protobuf/proto"
+	. "gopkg.in/check.v1"
+)
+
+type ProtocolSuite struct{}
+
+var _ = Suite(&ProtocolSuite{})
+
+func newProtobufClient(c *C, remote ChunkService, tags TagService) *ProtobufClient {
+	as := newTestAtomicState(c, remote, tags)
+
+	clientConn, serverConn := net.Pipe()
+	go NewProtobufServer(as).ServeConn(serverConn)
+	return NewProtobufClient(clientConn)
+}
+
+func (*ProtocolSuite) TestFileOps(c *C) {
+	client := newProtobufClient(c, NewMemChunkService(), NewMemTagService())
+	defer client.Close()
+
+	_, err := client.Call(&Request{Type: Request_MAKE_DIR.Enum(), MakeDir: &MakeDirReq{Path: proto.String("a/b"), Parents: proto.Bool(true)}})
+	c.Assert(err, IsNil)
+
+	localPath := filepath.Join(c.MkDir(), "local")
+	c.Assert(ioutil.WriteFile(localPath, []byte("data"), 0644), IsNil)
+	_, err = client.Call(&Request{Type: Request_PUT_LOCAL_PATH.Enum(), PutLocalPath: &PutLocalPathReq{Path: proto.String("a/b/file"), LocalPath: proto.String(localPath)}})
+	c.Assert(err, IsNil)
+
+	resp, err := client.Call(&Request{Type: Request_LIST_FILES.Enum(), ListFiles: &ListFilesReq{Path: proto.String("a/b")}})
+	c.Assert(err, IsNil)
+	entries := resp.GetListFiles().GetEntries()
+	c.Assert(len(entries), Equals, 1)
+	c.Assert(entries[0].GetName(), Equals, "file")
+	c.Assert(entries[0].GetMetadata().GetSize(), Equals, int64(4))
+
+	resp, err = client.Call(&Request{Type: Request_STAT.Enum(), Stat: &StatReq{Path: proto.String("a/b/file")}})
+	c.Assert(err, IsNil)
+	c.Assert(resp.GetStat().GetMetadata().GetIsDir(), Equals, false)
+	c.Assert(resp.GetStat().GetMetadata().GetSize(), Equals, int64(4))
+
+	resp, err = client.Call(&Request{Type: Request_STAT.Enum(), Stat: &StatReq{Path: proto.String("a/b/missing")}})
+	c.Assert(err, IsNil)
+	c.Assert(resp.GetStat().GetMetadata(), IsNil)
+
+	resp, err = client.Call(&Request{Type: Request_GET_LOCAL_PATH.Enum(), GetLocalPath: &GetLocalPathReq{Path: proto.String("a/b/file")}})
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadFile(resp.GetGetLocalPath().GetPath())
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "data")
+
+	_, err = client.Call(&Request{Type: Request_UNLINK.Enum(), Unlink: &UnlinkReq{Path: proto.String("a/b/file")}})
+	c.Assert(err, IsNil)
+	resp, err = client.Call(&Request{Type: Request_LIST_FILES.Enum(), ListFiles: &ListFilesReq{Path: proto.String("a/b")}})
+	c.Assert(err, IsNil)
+	c.Assert(len(resp.GetListFiles().GetEntries()), Equals, 0)
+}
+
+func (*ProtocolSuite) TestErrors(c *C) {
+	client := newProtobufClient(c, NewMemChunkService(), NewMemTagService())
+	defer client.Close()
+
+	_, err := client.Call(&Request{Type: Request_LIST_FILES.Enum(), ListFiles: &ListFilesReq{Path: proto.String("missing")}})
+	c.Assert(err, Equals, NO_SUCH_PATH)
+
+	_, err = client.Call(&Request{Type: Request_PULL.Enum(), Pull: &PullReq{Tag: proto.String("missing"), Destination: proto.String("z")}})
+	c.Assert(err, Equals, NO_SUCH_TAG)
+
+	// the request is answered even though it is missing its arguments, and the connection stays usable
+	_, err = client.Call(&Request{Type: Request_STAT.Enum()})
+	c.Assert(err, NotNil)
+	_, err = client.Call(&Request{Type: Request_MAKE_DIR.Enum(), MakeDir: &MakeDirReq{Path: proto.String("a")}})
+	c.Assert(err, IsNil)
+}
+
+func (*ProtocolSuite) TestPushAndPull(c *C) {
+	remote := NewMemChunkService()
+	tags := NewMemTagService()
+	client1 := newProtobufClient(c, remote, tags)
+	defer client1.Close()
+	client2 := newProtobufClient(c, remote, tags)
+	defer client2.Close()
+
+	_, err := client1.Call(&Request{Type: Request_MAKE_DIR.Enum(), MakeDir: &MakeDirReq{Path: proto.String("a/b"), Parents: proto.Bool(true)}})
+	c.Assert(err, IsNil)
+	_, err = client1.Call(&Request{Type: Request_PUSH.Enum(), Push: &PushReq{Source: proto.String("a"), Tag: proto.String("tag")}})
+	c.Assert(err, IsNil)
+
+	resp, err := client2.Call(&Request{Type: Request_LIST_ROOTS.Enum()})
+	c.Assert(err, IsNil)
+	c.Assert(len(resp.GetListRoots().GetRoots()), Equals, 1)
+	c.Assert(resp.GetListRoots().GetRoots()[0].GetName(), Equals, "tag")
+
+	_, err = client2.Call(&Request{Type: Request_PULL.Enum(), Pull: &PullReq{Tag: proto.String("tag"), Destination: proto.String("z")}})
+	c.Assert(err, IsNil)
+
+	resp, err = client2.Call(&Request{Type: Request_LIST_FILES.Enum(), ListFiles: &ListFilesReq{Path: proto.String("z")}})
+	c.Assert(err, IsNil)
+	c.Assert(len(resp.GetListFiles().GetEntries()), Equals, 1)
+	c.Assert(resp.GetListFiles().GetEntries()[0].GetName(), Equals, "b")
+
+	resp, err = client2.Call(&Request{Type: Request_DIFF.Enum(), Diff: &DiffReq{A: proto.String("tag"), B: proto.String("z")}})
+	c.Assert(err, IsNil)
+	c.Assert(len(resp.GetDiff().GetEntries()), Equals, 0)
+}
+
+func (*ProtocolSuite) TestListenLocal(c *C) {
+	l, err := listenLocal(":0")
+	c.Assert(err, IsNil)
+	c.Assert(l.Addr().(*net.TCPAddr).IP.IsLoopback(), Equals, true)
+	l.Close()
+
+	_, err = listenLocal("0.0.0.0:0")
+	c.Assert(err, NotNil)
+	_, err = listenLocal("example.com:0")
+	c.Assert(err, NotNil)
+
+	socketPath := filepath.Join(c.MkDir(), "protobuf.sock")
+	l, err = listenLocal(socketPath)
+	c.Assert(err, IsNil)
+	defer l.Close()
+	info, err := os.Stat(socketPath)
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode().Perm(), Equals, os.FileMode(0600))
+
+	as := newTestAtomicState(c, NewMemChunkService(), NewMemTagService())
+	go NewProtobufServer(as).Accept(l)
+	conn, err := net.Dial("unix", socketPath)
+	c.Assert(err, IsNil)
+	client := NewProtobufClient(conn)
+	defer client.Close()
+	_, err = client.Call(&Request{Type: Request_MAKE_DIR.Enum(), MakeDir: &MakeDirReq{Path: proto.String("a")}})
+	c.Assert(err, IsNil)
+}
@@ -0,0 +1,118 @@
+package s3
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	ss "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pgm/pliant/v2"
+)
+
+// how long a presigned URL remains valid
+var PRESIGN_EXPIRY = 15 * time.Minute
+
+const DEFAULT_REGION = "us-east-1"
+
+// Something which can hand out time-limited URLs for reading and writing individual chunks.  Used by
+// minions which do not hold credentials for the bucket themselves.
+type URLSigner interface {
+	SignGet(key *v2.Key) (string, error)
+	SignPut(key *v2.Key) (string, error)
+}
+
+// Creates a chunk service which holds no credentials, and instead asks signer for a URL for each
+// chunk it reads or writes.  Chunks are written with a single PUT, so are limited to 5GB.
+func NewPresignedS3ChunkService(bucket string, prefix string, signer URLSigner, getDestFn AllocTempDestFn) *S3ChunkService {
+	p := &S3ChunkService{}
+	p.Bucket = bucket
+	p.Prefix = prefix
+	p.Signer = signer
+	p.GetDestFn = getDestFn
+	p.MaxFetchKeys = 2
+	p.configure(S3Options{})
+
+	return p
+}
+
+func (c *S3ChunkService) PresignGet(key *v2.Key) (string, error) {
+	req, _ := c.api.GetObjectRequest(&ss.GetObjectInput{Bucket: aws.String(c.Bucket), Key: aws.String(c.Prefix + "/" + key.String())})
+	return req.Presign(PRESIGN_EXPIRY)
+}
+
+func (c *S3ChunkService) PresignPut(key *v2.Key) (string, error) {
+	req, _ := c.api.PutObjectRequest(&ss.PutObjectInput{Bucket: aws.String(c.Bucket), Key: aws.String(c.Prefix + "/" + key.String())})
+	return req.Presign(PRESIGN_EXPIRY)
+}
+
+func checkResponse(resp *http.Response, url string) error {
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s failed with status %d: %s", resp.Request.Method, url, resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+func (c *S3ChunkService) getPresigned(key *v2.Key) (v2.Resource, error) {
+	url, err := c.Signer.SignGet(key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.conf.Client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, v2.NO_SUCH_CHUNK
+	}
+	err = checkResponse(resp, url)
+	if err != nil {
+		return nil, err
+	}
+
+	destFile := c.GetDestFn()
+	w, err := os.Create(destFile)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	if _, err = io.Copy(w, resp.Body); err != nil {
+		return nil, err
+	}
+
+	return v2.NewFileResource(destFile)
+}
+
+func (c *S3ChunkService) putPresigned(key *v2.Key, resource v2.Resource) error {
+	url, err := c.Signer.SignPut(key)
+	if err != nil {
+		return err
+	}
+
+	r := resource.GetReader()
+	if rCloser, ok := r.(io.Closer); ok {
+		defer rCloser.Close()
+	}
+
+	req, err := http.NewRequest("PUT", url, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = resource.GetLength()
+
+	resp, err := c.conf.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return checkResponse(resp, url)
+}
@@ -0,0 +1,74 @@
+package s3
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/pgm/pliant/v2"
+	. "gopkg.in/check.v1"
+)
+
+type PresignSuite struct{}
+
+var _ = Suite(&PresignSuite{})
+
+// hands out URLs to an in-memory http server standing in for the bucket
+type fakeSigner struct {
+	url string
+}
+
+func (s *fakeSigner) SignGet(key *v2.Key) (string, error) {
+	return s.url + "/chunks/" + key.String() + "?signed=get", nil
+}
+
+func (s *fakeSigner) SignPut(key *v2.Key) (string, error) {
+	return s.url + "/chunks/" + key.String() + "?signed=put", nil
+}
+
+func (s *PresignSuite) TestPresignedGetAndPut(c *C) {
+	var lock sync.Mutex
+	objects := make(map[string][]byte)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		signed := r.URL.Query().Get("signed")
+		if r.Method == "PUT" && signed == "put" {
+			body, _ := ioutil.ReadAll(r.Body)
+			objects[r.URL.Path] = body
+		} else if r.Method == "GET" && signed == "get" {
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(body)
+		} else {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	tempdir := c.MkDir()
+	getDestFn := func() string {
+		f, _ := ioutil.TempFile(tempdir, "dest")
+		f.Close()
+		return f.Name()
+	}
+
+	p := NewPresignedS3ChunkService("bucket", "prefix", &fakeSigner{server.URL}, getDestFn)
+
+	key := &v2.Key{1, 2, 3}
+	err := p.Put(key, v2.NewMemResource([]byte("A")))
+	c.Assert(err, IsNil)
+
+	fetched, err := p.Get(key)
+	c.Assert(err, IsNil)
+	c.Assert(fetched.AsBytes(), DeepEquals, []byte("A"))
+
+	_, err = p.Get(&v2.Key{4})
+	c.Assert(err, NotNil)
+}
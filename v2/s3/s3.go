@@ -1,8 +1,12 @@
 package s3
 
 import (
+	"bytes"
 	"io"
+	"io/ioutil"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/rlmcpherson/s3gof3r"
 	//	"bytes"
@@ -12,12 +16,35 @@ import (
 
 	"fmt"
 
-	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	ss "github.com/aws/aws-sdk-go/service/s3"
 )
 
 type AllocTempDestFn func() string
 
+// Settings for the connection to the bucket.  Fields left as their zero value take the default.
+type S3Options struct {
+	// host, and optionally port, of the S3 service.  Defaults to the AWS endpoint for Region.
+	Endpoint string
+	// defaults to DEFAULT_REGION
+	Region string
+	// address the bucket as Endpoint/bucket rather than bucket.Endpoint, as most non-AWS services require
+	PathStyle bool
+	// connect with http instead of https
+	DisableSSL bool
+	// size of each part of a multipart upload or download.  Defaults to DEFAULT_PART_SIZE
+	PartSize int64
+	// number of parts transferred at once by a single Get or Put.  Defaults to DEFAULT_CONCURRENCY
+	Concurrency int
+}
+
+var DEFAULT_PART_SIZE int64 = 20 * 1024 * 1024
+var DEFAULT_CONCURRENCY = 10
+
+// how long to wait for the service to start responding to a request
+var RESPONSE_TIMEOUT = 60 * time.Second
+
 type S3Parameters struct {
 	EndPoint  string
 	Bucket    string
@@ -26,32 +53,94 @@ type S3Parameters struct {
 	Prefix    string
 }
 
+// All requests share one http.Client, so connections to the service are reused across calls.
 type S3ChunkService struct {
 	S3Parameters
 	DownloadDir  string
 	MaxFetchKeys int64
+	// if set, Get and Put use URLs obtained from Signer instead of Keys
+	Signer URLSigner
+
+	options S3Options
+	// used for reading and writing chunks and other objects
+	bucket *s3gof3r.Bucket
+	conf   *s3gof3r.Config
+	// used for listing, and for presigning URLs
+	api *ss.S3
 }
 
 func NewS3ChunkService(AccessKey string, SecretKey string, endpoint string, bucket string, prefix string, getDestFn AllocTempDestFn) *S3ChunkService {
+	return NewS3ChunkServiceWithOptions(AccessKey, SecretKey, S3Options{Endpoint: endpoint}, bucket, prefix, getDestFn)
+}
+
+func NewS3ChunkServiceWithOptions(AccessKey string, SecretKey string, options S3Options, bucket string, prefix string, getDestFn AllocTempDestFn) *S3ChunkService {
 	p := &S3ChunkService{}
-	p.EndPoint = endpoint
 	p.Bucket = bucket
 	p.Keys = s3gof3r.Keys{AccessKey: AccessKey, SecretKey: SecretKey}
 	p.GetDestFn = getDestFn
 	p.Prefix = prefix
 	p.MaxFetchKeys = 2
+	p.configure(options)
 
 	return p
 }
 
-func (c *S3ChunkService) Delete(key *v2.Key) {
-	conf := new(s3gof3r.Config)
-	*conf = *s3gof3r.DefaultConfig
+// the AWS endpoint for a region, in the form s3gof3r derives the signing region from
+func awsEndpoint(region string) string {
+	if region == DEFAULT_REGION {
+		return "s3.amazonaws.com"
+	}
+	return "s3-" + region + ".amazonaws.com"
+}
+
+// fills in the defaults for any options not set, and creates the clients used by every request
+func (c *S3ChunkService) configure(options S3Options) {
+	if options.Region == "" {
+		options.Region = DEFAULT_REGION
+	}
+	if options.Endpoint == "" {
+		options.Endpoint = awsEndpoint(options.Region)
+	}
+	if options.PartSize == 0 {
+		options.PartSize = DEFAULT_PART_SIZE
+	}
+	if options.Concurrency == 0 {
+		options.Concurrency = DEFAULT_CONCURRENCY
+	}
+	c.options = options
+	c.EndPoint = options.Endpoint
+
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		MaxIdleConnsPerHost:   options.Concurrency * 2,
+		ResponseHeaderTimeout: RESPONSE_TIMEOUT}}
+
+	c.conf = new(s3gof3r.Config)
+	*c.conf = *s3gof3r.DefaultConfig
+	c.conf.Client = client
+	c.conf.PartSize = options.PartSize
+	c.conf.Concurrency = options.Concurrency
+	c.conf.PathStyle = options.PathStyle
+	if options.DisableSSL {
+		c.conf.Scheme = "http"
+	}
 
-	s3 := s3gof3r.New(c.EndPoint, c.Keys)
-	b := s3.Bucket(c.Bucket)
+	c.bucket = s3gof3r.New(options.Endpoint, c.Keys).Bucket(c.Bucket)
+	// requests which are not given a config, such as Delete, use the bucket's
+	c.bucket.Config = c.conf
+
+	c.api = ss.New(aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials(c.Keys.AccessKey, c.Keys.SecretKey, "")).
+		WithEndpoint(options.Endpoint).
+		WithRegion(options.Region).
+		WithDisableSSL(options.DisableSSL).
+		WithS3ForcePathStyle(options.PathStyle).
+		WithHTTPClient(client))
+}
+
+func (c *S3ChunkService) Delete(key *v2.Key) {
 	path := c.Prefix + "/" + key.String()
-	err := b.Delete(path)
+	err := c.bucket.Delete(path)
 	if err != nil {
 		panic("Error Delete")
 	}
@@ -127,18 +216,15 @@ func (c *S3KeyIterator) Next() *v2.Key {
 }
 
 func (c *S3ChunkService) Iterate() v2.KeyIterator {
-	s3c := ss.New(defaults.DefaultConfig)
-	it := &S3KeyIterator{Bucket: c.Bucket, Prefix: c.Prefix + "/", MaxFetchKeys: c.MaxFetchKeys, S3C: s3c}
+	it := &S3KeyIterator{Bucket: c.Bucket, Prefix: c.Prefix + "/", MaxFetchKeys: c.MaxFetchKeys, S3C: c.api}
 	it.fetchNext(nil)
 	return it
 }
 
 func (c *S3ChunkService) Get(key *v2.Key) (v2.Resource, error) {
-	conf := new(s3gof3r.Config)
-	*conf = *s3gof3r.DefaultConfig
-
-	s3 := s3gof3r.New(c.EndPoint, c.Keys)
-	b := s3.Bucket(c.Bucket)
+	if c.Signer != nil {
+		return c.getPresigned(key)
+	}
 
 	destFile := c.GetDestFn()
 	w, err := os.Create(destFile)
@@ -148,9 +234,13 @@ func (c *S3ChunkService) Get(key *v2.Key) (v2.Resource, error) {
 	defer w.Close()
 
 	path := c.Prefix + "/" + key.String()
-	r, _, err := b.GetReader(path, conf)
+	r, _, err := c.bucket.GetReader(path, c.conf)
 	if err != nil {
-		panic(fmt.Sprintf("Error GetReader: %s", err))
+		os.Remove(destFile)
+		if respErr, ok := err.(*s3gof3r.RespError); ok && respErr.StatusCode == http.StatusNotFound {
+			return nil, v2.NO_SUCH_CHUNK
+		}
+		return nil, err
 	}
 	defer r.Close()
 
@@ -167,10 +257,9 @@ func (c *S3ChunkService) Get(key *v2.Key) (v2.Resource, error) {
 }
 
 func (c *S3ChunkService) Put(key *v2.Key, resource v2.Resource) error {
-	conf := new(s3gof3r.Config)
-	*conf = *s3gof3r.DefaultConfig
-	s3 := s3gof3r.New(c.EndPoint, c.Keys)
-	b := s3.Bucket(c.Bucket)
+	if c.Signer != nil {
+		return c.putPresigned(key, resource)
+	}
 
 	r := resource.GetReader()
 	if rCloser, ok := r.(io.Closer); ok {
@@ -179,15 +268,207 @@ func (c *S3ChunkService) Put(key *v2.Key, resource v2.Resource) error {
 
 	header := make(http.Header)
 	path := c.Prefix + "/" + key.String()
-	w, err := b.PutWriter(path, header, conf)
+	w, err := c.bucket.PutWriter(path, header, c.conf)
 	if err != nil {
 		panic(err.Error())
 	}
-	defer w.Close()
 
 	if _, err = io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	// the upload is only completed by Close
+	return w.Close()
+}
+
+// number of HEAD requests HasMany makes at once
+var HAS_CONCURRENCY = 16
+
+// Checks for the chunk with a HEAD request, or with a one byte ranged GET when using presigned URLs, as
+// those are only valid for the method they were signed for.
+func (c *S3ChunkService) Has(key *v2.Key) (bool, error) {
+	var req *http.Request
+	var err error
+	if c.Signer != nil {
+		var signed string
+		signed, err = c.Signer.SignGet(key)
+		if err != nil {
+			return false, err
+		}
+		req, err = http.NewRequest("GET", signed, nil)
+		if err != nil {
+			return false, err
+		}
+		req.Header.Set("Range", "bytes=0-0")
+	} else {
+		url := c.bucket.Url(c.Prefix+"/"+key.String(), c.conf)
+		req, err = http.NewRequest("HEAD", url.String(), nil)
+		if err != nil {
+			return false, err
+		}
+		c.bucket.Sign(req)
+	}
+
+	resp, err := c.conf.Client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+		// a range can't be satisfied for an empty object, but the object still exists
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("%s %s failed with status %d", req.Method, key, resp.StatusCode)
+}
+
+// Checks the keys with up to HAS_CONCURRENCY requests in flight
+func (c *S3ChunkService) HasMany(keys []*v2.Key) ([]bool, error) {
+	found := make([]bool, len(keys))
+	errs := make([]error, len(keys))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < HAS_CONCURRENCY && w < len(keys); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				found[i], errs[i] = c.Has(keys[i])
+			}
+		}()
+	}
+	for i := range keys {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+func (c *S3ChunkService) objectPath(name string) string {
+	return c.Prefix + "/" + name
+}
+
+// Fetches a named (non-chunk) object stored under the prefix.  Returns nil if the object does not exist.
+func (c *S3ChunkService) GetObject(name string) ([]byte, error) {
+	r, _, err := c.bucket.GetReader(c.objectPath(name), c.conf)
+	if err != nil {
+		if respErr, ok := err.(*s3gof3r.RespError); ok && respErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+func (c *S3ChunkService) PutObject(name string, data []byte) error {
+	return c.putObject(name, data, false)
+}
+
+// Writes a named object only if it does not already exist, returning v2.OBJECT_EXISTS if it does.
+func (c *S3ChunkService) CreateObject(name string, data []byte) error {
+	return c.putObject(name, data, true)
+}
+
+// small objects are written with a single PUT rather than s3gof3r's multipart upload so that
+// the conditional header applies to the request which actually creates the object
+func (c *S3ChunkService) putObject(name string, data []byte, onlyIfAbsent bool) error {
+	url := c.bucket.Url(c.objectPath(name), c.conf)
+	req, err := http.NewRequest("PUT", url.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	if onlyIfAbsent {
+		req.Header.Set("If-None-Match", "*")
+	}
+	c.bucket.Sign(req)
+
+	resp, err := c.conf.Client.Do(req)
+	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return v2.OBJECT_EXISTS
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("PUT %s failed with status %d: %s", name, resp.StatusCode, string(body))
+	}
 
 	return nil
 }
+
+func (c *S3ChunkService) GetObjectRange(name string, offset int64, length int64) ([]byte, error) {
+	url := c.bucket.Url(c.objectPath(name), c.conf)
+	req, err := http.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	c.bucket.Sign(req)
+
+	resp, err := c.conf.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, v2.NO_SUCH_OBJECT
+	}
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GET %s failed with status %d: %s", name, resp.StatusCode, string(body))
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, length))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != length {
+		return nil, fmt.Errorf("GET %s returned %d bytes instead of %d", name, len(data), length)
+	}
+	return data, nil
+}
+
+func (c *S3ChunkService) DeleteObject(name string) error {
+	return c.bucket.Delete(c.objectPath(name))
+}
+
+// Lists the names of all objects under the prefix whose name starts with namePrefix
+func (c *S3ChunkService) ListObjects(namePrefix string) ([]string, error) {
+	s3c := c.api
+	prefix := c.objectPath(namePrefix)
+	names := make([]string, 0, 100)
+	var marker *string
+	for {
+		page, err := s3c.ListObjects(&ss.ListObjectsInput{Bucket: &c.Bucket, Prefix: &prefix, Marker: marker})
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			names = append(names, (*obj.Key)[len(c.Prefix)+1:])
+		}
+		if page.IsTruncated == nil || !*page.IsTruncated || len(page.Contents) == 0 {
+			break
+		}
+		marker = page.Contents[len(page.Contents)-1].Key
+	}
+	return names, nil
+}
@@ -1,14 +1,24 @@
 package s3
 
 import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/defaults"
 	ss "github.com/aws/aws-sdk-go/service/s3"
 	"github.com/pgm/pliant/v2"
+	"github.com/rlmcpherson/s3gof3r"
 	. "gopkg.in/check.v1"
 )
 
@@ -87,3 +97,142 @@ func (s *S3Suite) TestSimpleS3ChunkOps(c *C) {
 	c.Assert(!it.HasNext(), Equals, true)
 	c.Assert(nextKey, DeepEquals, key)
 }
+
+type OptionsSuite struct{}
+
+var _ = Suite(&OptionsSuite{})
+
+func (s *OptionsSuite) TestDefaults(c *C) {
+	p := NewS3ChunkService("key", "secret", "", "bucket", "prefix", nil)
+	c.Assert(p.EndPoint, Equals, "s3.amazonaws.com")
+	c.Assert(p.options.Region, Equals, DEFAULT_REGION)
+	c.Assert(p.conf.PartSize, Equals, DEFAULT_PART_SIZE)
+	c.Assert(p.conf.Concurrency, Equals, DEFAULT_CONCURRENCY)
+	c.Assert(p.conf.PathStyle, Equals, false)
+
+	p = NewS3ChunkService("key", "secret", "", "bucket", "prefix", nil)
+	p.configure(S3Options{Region: "eu-west-1"})
+	c.Assert(p.EndPoint, Equals, "s3-eu-west-1.amazonaws.com")
+}
+
+// An in-memory S3 holding the objects of one bucket, which only accepts path-style requests.  Handles the
+// multipart uploads made by s3gof3r as well as plain GET, PUT and DELETE.
+type fakeS3 struct {
+	lock    sync.Mutex
+	bucket  string
+	objects map[string][]byte
+	// the parts received so far, by upload id and part number
+	uploads map[string]map[int][]byte
+	// requests which were not addressed to the bucket path-style
+	misrouted int
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{bucket: bucket, objects: make(map[string][]byte), uploads: make(map[string]map[int][]byte)}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return "\"" + hex.EncodeToString(sum[:]) + "\""
+}
+
+func (s *fakeS3) object(name string) []byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.objects[name]
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	prefix := "/" + s.bucket + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		s.misrouted++
+		http.Error(w, "not a path-style request for "+s.bucket, http.StatusBadRequest)
+		return
+	}
+	name := r.URL.Path[len(prefix):]
+	query := r.URL.Query()
+	uploadId := query.Get("uploadId")
+	body, _ := ioutil.ReadAll(r.Body)
+
+	_, initiate := query["uploads"]
+	switch {
+	case r.Method == "POST" && initiate:
+		uploadId = fmt.Sprintf("upload%d", len(s.uploads))
+		s.uploads[uploadId] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>",
+			s.bucket, name, uploadId)
+	case r.Method == "PUT" && uploadId != "":
+		part, _ := strconv.Atoi(query.Get("partNumber"))
+		s.uploads[uploadId][part] = body
+		w.Header().Set("ETag", etag(body))
+	case r.Method == "POST" && uploadId != "":
+		parts := s.uploads[uploadId]
+		delete(s.uploads, uploadId)
+		var content, sums bytes.Buffer
+		for i := 1; i <= len(parts); i++ {
+			content.Write(parts[i])
+			sum := md5.Sum(parts[i])
+			sums.Write(sum[:])
+		}
+		s.objects[name] = content.Bytes()
+		sum := md5.Sum(sums.Bytes())
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>\"%s-%d\"</ETag></CompleteMultipartUploadResult>",
+			s.bucket, name, hex.EncodeToString(sum[:]), len(parts))
+	case r.Method == "PUT":
+		s.objects[name] = body
+		w.Header().Set("ETag", etag(body))
+	case r.Method == "GET" || r.Method == "HEAD":
+		data, ok := s.objects[name]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", etag(data))
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+	case r.Method == "DELETE":
+		delete(s.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func (s *OptionsSuite) TestPathStyleEndpoint(c *C) {
+	fake := newFakeS3("bucket")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	options := S3Options{Endpoint: strings.TrimPrefix(server.URL, "http://"), PathStyle: true, DisableSSL: true,
+		PartSize: 5 * 1024 * 1024, Concurrency: 3}
+	tempdir := c.MkDir()
+	getDestFn := func() string {
+		f, _ := ioutil.TempFile(tempdir, "dest")
+		f.Close()
+		return f.Name()
+	}
+	p := NewS3ChunkServiceWithOptions("key", "secret", options, "bucket", "prefix", getDestFn)
+	c.Assert(p.conf.PartSize, Equals, int64(5*1024*1024))
+	c.Assert(p.conf.Concurrency, Equals, 3)
+	// the default config shared by all s3gof3r users is left alone
+	c.Assert(p.conf == s3gof3r.DefaultConfig, Equals, false)
+	c.Assert(s3gof3r.DefaultConfig.PathStyle, Equals, false)
+
+	key := &v2.Key{1, 2, 3}
+	c.Assert(p.Put(key, v2.NewMemResource([]byte("A"))), IsNil)
+	c.Assert(fake.object("prefix/"+key.String()), DeepEquals, []byte("A"))
+
+	fetched, err := p.Get(key)
+	c.Assert(err, IsNil)
+	c.Assert(fetched.AsBytes(), DeepEquals, []byte("A"))
+
+	p.Delete(key)
+	c.Assert(fake.object("prefix/"+key.String()), IsNil)
+	_, err = p.Get(key)
+	c.Assert(err, Equals, v2.NO_SUCH_CHUNK)
+
+	c.Assert(fake.misrouted, Equals, 0)
+}
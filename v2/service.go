@@ -1,48 +1,74 @@
 package v2
 
 import (
+	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"net/rpc"
 	"net/rpc/jsonrpc"
 	"os"
-//	"net/http"
-//	"code.google.com/p/go.net/websocket"
-//	"fmt"
+
+	"golang.org/x/net/websocket"
 )
 
-func ServerAccept(server *rpc.Server, lis net.Listener) {
-	for {
-		conn, err := lis.Accept()
-		if err != nil {
-			log.Fatal("rpc.Serve: accept:", err.Error()) // TODO(r): exit?
-		}
-		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
-	}
-}
+// The path at which JSON-RPC requests are accepted over a websocket, as used by pypliant.py
+const JSON_RPC_PATH = "/conn"
 
-func StartJsonRpc(bindAddr string, ac *AtomicClient) error {
+// Serves the AtomicClient methods as JSON-RPC over a websocket at JSON_RPC_PATH.  Each websocket
+// connection is a separate JSON-RPC session.  There is no authentication, so connections from web pages are
+// refused unless the page was itself served from this host, otherwise any site the user visits could read
+// local files through PutLocalPath.
+func NewJsonRpcHandler(ac *AtomicClient) http.Handler {
 	server := rpc.NewServer()
 	server.Register(ac)
 
-	l, err := net.ListenUnix("unix", &net.UnixAddr{bindAddr, "unix"})
+	mux := http.NewServeMux()
+	mux.Handle(JSON_RPC_PATH, websocket.Server{Handshake: checkLocalOrigin, Handler: func(conn *websocket.Conn) {
+		server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}})
+	return mux
+}
+
+// Browsers always send an Origin header with websocket requests, so requests without one come from other
+// programs (ie: pypliant.py) and are accepted.
+func checkLocalOrigin(config *websocket.Config, req *http.Request) error {
+	origin, err := websocket.Origin(config, req)
 	if err != nil {
 		return err
 	}
-	defer os.Remove(bindAddr)
+	if origin == nil {
+		return nil
+	}
 
-	log.Printf("Ready to accept requests via %s\n", bindAddr)
+	host := origin.Hostname()
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("Refusing websocket connection from origin %s", origin)
+	}
+	config.Origin = origin
+	return nil
+}
 
-	ServerAccept(server, l)
+// Listens for HTTP on bindAddr and serves JSON-RPC requests until the listener fails.  Like
+// StartProtobufServer, bindAddr must be a loopback address (ie: "127.0.0.1:6001") or a Unix socket path.
+func StartJsonRpc(bindAddr string, ac *AtomicClient) error {
+	l, err := listenLocal(bindAddr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
 
-	return nil
+	log.Printf("Ready to accept JSON-RPC requests via ws://%s%s\n", l.Addr(), JSON_RPC_PATH)
+	return http.Serve(l, NewJsonRpcHandler(ac))
 }
 
 func StartServer(bindAddr string, jsonBindAddr string, atomic Atomic) error {
 	ac := AtomicClient{atomic: atomic}
 
 	if jsonBindAddr != "" {
-		go StartJsonRpc(jsonBindAddr, &ac)
+		go func() {
+			log.Fatal(StartJsonRpc(jsonBindAddr, &ac))
+		}()
 	}
 
 	server := rpc.NewServer()
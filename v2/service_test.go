@@ -0,0 +1,146 @@
+package v2
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/websocket"
+	. "gopkg.in/check.v1"
+)
+
+type ServiceSuite struct{}
+
+var _ = Suite(&ServiceSuite{})
+
+func dialJsonRpc(c *C, server *httptest.Server) *rpc.Client {
+	url := "ws://" + strings.TrimPrefix(server.URL, "http://") + JSON_RPC_PATH
+	conn, err := websocket.Dial(url, "", server.URL)
+	c.Assert(err, IsNil)
+	return jsonrpc.NewClient(conn)
+}
+
+func (*ServiceSuite) TestJsonRpcOnlyLocal(c *C) {
+	as := newTestAtomicState(c, NewMemChunkService(), NewMemTagService())
+	server := httptest.NewServer(NewJsonRpcHandler(&AtomicClient{atomic: as}))
+	defer server.Close()
+
+	url := "ws://" + strings.TrimPrefix(server.URL, "http://") + JSON_RPC_PATH
+	for _, origin := range []string{"http://localhost:8000/", "http://127.0.0.1/", "http://[::1]:8000/"} {
+		conn, err := websocket.Dial(url, "", origin)
+		c.Assert(err, IsNil, Commentf("origin %s", origin))
+		conn.Close()
+	}
+	// a page on another site cannot connect through the user's browser
+	for _, origin := range []string{"http://evil.example.com/", "http://127.0.0.1.evil.example.com/"} {
+		_, err := websocket.Dial(url, "", origin)
+		c.Assert(err, NotNil, Commentf("origin %s", origin))
+	}
+
+	// nor can a sandboxed page, but programs which send no Origin are accepted
+	req := httptest.NewRequest("GET", url, nil)
+	c.Assert(checkLocalOrigin(&websocket.Config{Version: websocket.ProtocolVersionHybi13}, req), IsNil)
+	req.Header.Set("Origin", "null")
+	c.Assert(checkLocalOrigin(&websocket.Config{Version: websocket.ProtocolVersionHybi13}, req), NotNil)
+
+	c.Assert(StartJsonRpc("0.0.0.0:0", &AtomicClient{atomic: as}), NotNil)
+}
+
+func (*ServiceSuite) TestJsonRpcOverWebsocket(c *C) {
+	as := newTestAtomicState(c, NewMemChunkService(), NewMemTagService())
+
+	server := httptest.NewServer(NewJsonRpcHandler(&AtomicClient{atomic: as}))
+	defer server.Close()
+
+	client := dialJsonRpc(c, server)
+	defer client.Close()
+
+	var key string
+	c.Assert(client.Call("AtomicClient.MakeDir", &MakeDirArgs{Path: "a/b", Parents: true}, &key), IsNil)
+
+	var files []ListFilesRecord
+	c.Assert(client.Call("AtomicClient.ListFiles", "a", &files), IsNil)
+	c.Assert(len(files), Equals, 1)
+	c.Assert(files[0].Name, Equals, "b")
+	c.Assert(files[0].IsDir, Equals, true)
+
+	var stat StatResponse
+	c.Assert(client.Call("AtomicClient.Stat", "a/b", &stat), IsNil)
+	c.Assert(stat.IsDir, Equals, true)
+
+	// errors keep their code when sent as JSON
+	err := client.Call("AtomicClient.ListFiles", "missing", &files)
+	c.Assert(ErrorCodeOf(err), Equals, ERR_NOT_FOUND)
+
+	// each connection is served independently
+	other := dialJsonRpc(c, server)
+	defer other.Close()
+	c.Assert(other.Call("AtomicClient.ListFiles", "a", &files), IsNil)
+	c.Assert(len(files), Equals, 1)
+}
+
+// sends a request the way pypliant.py does, and returns the response decoded the way it reads it
+func pypliantCall(c *C, conn *websocket.Conn, id int, method string, params string) (result interface{}, rpcErr interface{}) {
+	request := `{"Method": "AtomicClient.` + method + `", "Params": ` + params + `, "Id": ` + strconv.Itoa(id) + `}`
+	c.Assert(websocket.Message.Send(conn, request), IsNil)
+
+	var response string
+	c.Assert(websocket.Message.Receive(conn, &response), IsNil)
+	var decoded map[string]interface{}
+	c.Assert(json.Unmarshal([]byte(response), &decoded), IsNil)
+	c.Assert(decoded["id"], Equals, float64(id))
+	return decoded["result"], decoded["error"]
+}
+
+func (*ServiceSuite) TestPypliantRequests(c *C) {
+	as := newTestAtomicState(c, NewMemChunkService(), NewMemTagService())
+	server := httptest.NewServer(NewJsonRpcHandler(&AtomicClient{atomic: as}))
+	defer server.Close()
+
+	url := "ws://" + strings.TrimPrefix(server.URL, "http://") + JSON_RPC_PATH
+	conn, err := websocket.Dial(url, "", server.URL)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	localPath := filepath.Join(c.MkDir(), "local")
+	c.Assert(ioutil.WriteFile(localPath, []byte("data"), 0644), IsNil)
+	localPathJson, _ := json.Marshal(localPath)
+
+	_, rpcErr := pypliantCall(c, conn, 0, "MakeDir", `[{"Path": "a", "Parents": false}]`)
+	c.Assert(rpcErr, IsNil)
+	_, rpcErr = pypliantCall(c, conn, 1, "PutLocalPath", `[{"LocalPath": `+string(localPathJson)+`, "DestPath": "a/f"}]`)
+	c.Assert(rpcErr, IsNil)
+
+	result, rpcErr := pypliantCall(c, conn, 2, "ListFiles", `["a"]`)
+	c.Assert(rpcErr, IsNil)
+	files := result.([]interface{})
+	c.Assert(len(files), Equals, 1)
+	c.Assert(files[0].(map[string]interface{})["Name"], Equals, "f")
+
+	result, rpcErr = pypliantCall(c, conn, 3, "Stat", `["a/f"]`)
+	c.Assert(rpcErr, IsNil)
+	c.Assert(result.(map[string]interface{})["IsDir"], Equals, false)
+
+	result, rpcErr = pypliantCall(c, conn, 4, "GetLocalPath", `["a/f"]`)
+	c.Assert(rpcErr, IsNil)
+	content, err := ioutil.ReadFile(result.(string))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "data")
+
+	_, rpcErr = pypliantCall(c, conn, 5, "Push", `[{"Source": "a", "Tag": "label"}]`)
+	c.Assert(rpcErr, IsNil)
+	_, rpcErr = pypliantCall(c, conn, 6, "Pull", `[{"Destination": "z", "Tag": "label"}]`)
+	c.Assert(rpcErr, IsNil)
+	result, rpcErr = pypliantCall(c, conn, 7, "ListFiles", `["z"]`)
+	c.Assert(rpcErr, IsNil)
+	c.Assert(len(result.([]interface{})), Equals, 1)
+
+	// errors are reported in the "error" field, which pypliant raises as an RPCException
+	_, rpcErr = pypliantCall(c, conn, 8, "ListFiles", `["missing"]`)
+	c.Assert(rpcErr, NotNil)
+}
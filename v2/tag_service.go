@@ -1,16 +1,29 @@
 package v2
 
 import (
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 type MemTagService struct {
 	lock sync.Mutex
 	tags map[string]*Key
+
+	history []TagChange
+	// closed and replaced each time a tag changes
+	changed chan bool
 }
 
 func NewMemTagService() *MemTagService {
-	return &MemTagService{tags: make(map[string]*Key)}
+	return &MemTagService{tags: make(map[string]*Key), changed: make(chan bool)}
+}
+
+func (m *MemTagService) recordChange(tag string, key *Key) {
+	m.history = append(m.history, TagChange{Seq: uint64(len(m.history) + 1), Name: tag, Key: key})
+	close(m.changed)
+	m.changed = make(chan bool)
 }
 
 func (m *MemTagService) Get(tag string) (*Key, error) {
@@ -25,21 +38,86 @@ func (m *MemTagService) Put(tag string, key *Key) error {
 	defer m.lock.Unlock()
 
 	m.tags[tag] = key
+	m.recordChange(tag, key)
+
+	return nil
+}
+
+func (m *MemTagService) CompareAndPut(tag string, expected *Key, key *Key) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	current := m.tags[tag]
+	if (current == nil) != (expected == nil) || (current != nil && *current != *expected) {
+		return TAG_CHANGED
+	}
+
+	m.tags[tag] = key
+	m.recordChange(tag, key)
+
+	return nil
+}
+
+func (m *MemTagService) Delete(tag string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.tags[tag]; !exists {
+		return NO_SUCH_TAG
+	}
+	delete(m.tags, tag)
+	m.recordChange(tag, nil)
 
 	return nil
 }
 
-func (m *MemTagService) ForEach(callback func(name string, key *Key)) {
+func (m *MemTagService) ForEach(prefix string, callback func(name string, key *Key)) error {
 	m.lock.Lock()
 
 	mapCopy := make(map[string]*Key)
+	names := make([]string, 0, len(m.tags))
 	for k, v := range m.tags {
-		mapCopy[k] = v
+		if strings.HasPrefix(k, prefix) {
+			mapCopy[k] = v
+			names = append(names, k)
+		}
 	}
 
 	m.lock.Unlock()
 
-	for k, v := range mapCopy {
-		callback(k, v)
+	sort.Strings(names)
+	for _, name := range names {
+		callback(name, mapCopy[name])
+	}
+
+	return nil
+}
+
+func (m *MemTagService) Watch(prefix string, sinceSeq uint64, timeout time.Duration) ([]TagChange, uint64, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		m.lock.Lock()
+		changes := make([]TagChange, 0)
+		if sinceSeq < uint64(len(m.history)) {
+			for _, change := range m.history[sinceSeq:] {
+				if strings.HasPrefix(change.Name, prefix) {
+					changes = append(changes, change)
+				}
+			}
+		}
+		seq := uint64(len(m.history))
+		changed := m.changed
+		m.lock.Unlock()
+
+		remaining := deadline.Sub(time.Now())
+		if len(changes) > 0 || remaining <= 0 {
+			return changes, seq, nil
+		}
+
+		select {
+		case <-changed:
+		case <-time.After(remaining):
+		}
+		sinceSeq = seq
 	}
 }
@@ -0,0 +1,120 @@
+package tagsvc
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	gcfg "gopkg.in/gcfg.v1"
+)
+
+var PERMISSION_DENIED error = errors.New("Permission denied")
+
+// a prefix which matches every label
+const ALL_LABELS = "*"
+
+// A named minion, the secret it authenticates with and the label prefixes it may access.  A prefix
+// of "rnaseq/" grants access to every label starting with "rnaseq/".
+type Identity struct {
+	Name   string
+	Secret string
+	Read   []string
+	Write  []string
+}
+
+// the identity used by minions which authenticate with the shared AuthSecret when no ACL is configured
+func anonymousIdentity(secret string) *Identity {
+	return &Identity{Name: "", Secret: secret, Read: []string{ALL_LABELS}, Write: []string{ALL_LABELS}}
+}
+
+func matchesPrefix(prefixes []string, label string) bool {
+	for _, prefix := range prefixes {
+		if prefix == ALL_LABELS || strings.HasPrefix(label, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// write access implies read access
+func (i *Identity) CanRead(label string) bool {
+	return matchesPrefix(i.Read, label) || matchesPrefix(i.Write, label)
+}
+
+func (i *Identity) CanWrite(label string) bool {
+	return matchesPrefix(i.Write, label)
+}
+
+// Leases protect chunks which are in the middle of being pushed, so any identity which can write
+// some label may take them out.
+func (i *Identity) CanLease() bool {
+	return len(i.Write) > 0
+}
+
+// anonymous leases are only visible to identities which can read every label
+func (i *Identity) CanReadAll() bool {
+	return matchesPrefix(i.Read, ALL_LABELS) || matchesPrefix(i.Write, ALL_LABELS)
+}
+
+// minions which cannot read any label have no use for chunks, so may not look up peers
+func (i *Identity) CanReadAny() bool {
+	return len(i.Read) > 0 || len(i.Write) > 0
+}
+
+// GC deletes chunks on behalf of every label, so is limited to identities which can write all of them
+func (i *Identity) CanWriteAll() bool {
+	return matchesPrefix(i.Write, ALL_LABELS)
+}
+
+type ACL struct {
+	identities map[string]*Identity
+}
+
+func NewACL() *ACL {
+	return &ACL{identities: make(map[string]*Identity)}
+}
+
+func (a *ACL) Add(identity *Identity) {
+	a.identities[identity.Name] = identity
+}
+
+// returns nil if there is no identity with that name
+func (a *ACL) Get(name string) *Identity {
+	return a.identities[name]
+}
+
+// Reads an ACL file of the form:
+//
+//	[Minion "rnaseq"]
+//	Secret=...
+//	Read=reference/
+//	Write=rnaseq/
+//
+// Read and Write may be repeated, and "*" grants access to all labels.
+func LoadACL(filename string) (*ACL, error) {
+	cfg := struct {
+		Minion map[string]*struct {
+			Secret string
+			Read   []string
+			Write  []string
+		}
+	}{}
+
+	err := gcfg.ReadFileInto(&cfg, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	acl := NewACL()
+	for name, m := range cfg.Minion {
+		if m.Secret == "" {
+			return nil, errors.New("No secret for minion " + name)
+		}
+		acl.Add(&Identity{Name: name, Secret: m.Secret, Read: m.Read, Write: m.Write})
+	}
+	return acl, nil
+}
+
+func logDenied(identity *Identity, operation string, label string) {
+	log.Printf("ACL: denied %s of %q to minion %q", operation, label, identity.Name)
+}
@@ -2,22 +2,51 @@ package tagsvc
 
 import (
 	"bytes"
+	"errors"
 	"github.com/golang/protobuf/proto"
 	"github.com/pgm/pliant/v2"
 	"os"
 	//	"fmt"
 )
 
+// the persistent record of every change made to the roots, replayed on startup
+type logWriter interface {
+	appendLabel(label string, key *v2.Key) error
+	// records all of the label changes as a single entry
+	appendLabels(changes []SetArgs) error
+	appendLease(key *v2.Key, timestamp uint64) error
+	// the sequence number of the last entry replayed or written.  Entries are numbered from 1.
+	lastSeq() uint64
+	Close()
+}
+
+// entries are prefixed by a two byte length
+var LOG_ENTRY_TOO_LARGE = errors.New("Log entry larger than 65535 bytes")
+
 type Log struct {
-	w *os.File
+	w   *os.File
+	seq uint64
 }
 
-func (log *Log) write(buffer []byte) {
+func (log *Log) write(buffer []byte) error {
+	if len(buffer) > 0xffff {
+		return LOG_ENTRY_TOO_LARGE
+	}
+
 	paddedBuffer := bytes.NewBuffer(make([]byte, 0, len(buffer)+2))
 	paddedBuffer.WriteByte(byte(len(buffer) >> 8))
 	paddedBuffer.WriteByte(byte(len(buffer)))
 	paddedBuffer.Write(buffer)
-	log.w.Write(paddedBuffer.Bytes())
+	_, err := log.w.Write(paddedBuffer.Bytes())
+	if err != nil {
+		return err
+	}
+	log.seq++
+	return nil
+}
+
+func (log *Log) lastSeq() uint64 {
+	return log.seq
 }
 
 func (log *Log) read() []byte {
@@ -44,7 +73,7 @@ func (log *Log) read() []byte {
 	return buffer
 }
 
-func (log *Log) appendLabel(label string, key *v2.Key) {
+func labelEntry(label string, key *v2.Key) *v2.RootLog {
 	et := v2.RootLog_LABEL
 	var keyBytes []byte
 	if key == nil {
@@ -52,24 +81,66 @@ func (log *Log) appendLabel(label string, key *v2.Key) {
 	} else {
 		keyBytes = key.AsBytes()
 	}
-	buffer, err := proto.Marshal(&v2.RootLog{Type: &et, Name: proto.String(label), Key: keyBytes})
+	return &v2.RootLog{Type: &et, Name: proto.String(label), Key: keyBytes}
+}
+
+func batchEntry(changes []SetArgs) *v2.RootLog {
+	et := v2.RootLog_BATCH
+	labels := make([]*v2.RootLog, len(changes))
+	for i, change := range changes {
+		labels[i] = labelEntry(change.Label, change.Key)
+	}
+	return &v2.RootLog{Type: &et, Labels: labels}
+}
+
+func leaseEntry(key *v2.Key, timestamp uint64) *v2.RootLog {
+	et := v2.RootLog_LEASE
+	return &v2.RootLog{Type: &et, Key: key.AsBytes(), Expiry: proto.Uint64(timestamp)}
+}
+
+func marshalEntry(entry *v2.RootLog) []byte {
+	buffer, err := proto.Marshal(entry)
 	if err != nil {
 		panic(err.Error())
 	}
-	log.write(buffer)
+	return buffer
+}
+
+// invoke the callback which corresponds to the type of entry
+func replayEntry(entry *v2.RootLog, replayLabel func(label string, key *v2.Key), replayLease func(key *v2.Key, timestamp uint64)) {
+	if entry.GetType() == v2.RootLog_LEASE {
+		replayLease(v2.KeyFromBytes(entry.GetKey()), entry.GetExpiry())
+	} else if entry.GetType() == v2.RootLog_LABEL {
+		var key *v2.Key
+		if entry.Key == nil {
+			key = nil
+		} else {
+			key = v2.KeyFromBytes(entry.GetKey())
+		}
+		replayLabel(entry.GetName(), key)
+	} else if entry.GetType() == v2.RootLog_BATCH {
+		for _, label := range entry.GetLabels() {
+			replayEntry(label, replayLabel, replayLease)
+		}
+	} else {
+		panic("invalid entry type")
+	}
+}
+
+func (log *Log) appendLabel(label string, key *v2.Key) error {
+	return log.write(marshalEntry(labelEntry(label, key)))
+}
+
+func (log *Log) appendLabels(changes []SetArgs) error {
+	return log.write(marshalEntry(batchEntry(changes)))
 }
 
 func (log *Log) Close() {
 	log.w.Close()
 }
 
-func (log *Log) appendLease(key *v2.Key, timestamp uint64) {
-	et := v2.RootLog_LEASE
-	buffer, err := proto.Marshal(&v2.RootLog{Type: &et, Key: key.AsBytes(), Expiry: proto.Uint64(timestamp)})
-	if err != nil {
-		panic(err.Error())
-	}
-	log.write(buffer)
+func (log *Log) appendLease(key *v2.Key, timestamp uint64) error {
+	return log.write(marshalEntry(leaseEntry(key, timestamp)))
 }
 
 func OpenLog(filename string, replayLabel func(label string, key *v2.Key), replayLease func(key *v2.Key, timestamp uint64)) *Log {
@@ -90,19 +161,8 @@ func OpenLog(filename string, replayLabel func(label string, key *v2.Key), repla
 		var entry v2.RootLog
 		proto.Unmarshal(buffer, &entry)
 
-		if entry.GetType() == v2.RootLog_LEASE {
-			replayLease(v2.KeyFromBytes(entry.GetKey()), entry.GetExpiry())
-		} else if entry.GetType() == v2.RootLog_LABEL {
-			var key *v2.Key
-			if entry.Key == nil {
-				key = nil
-			} else {
-				key = v2.KeyFromBytes(entry.GetKey())
-			}
-			replayLabel(entry.GetName(), key)
-		} else {
-			panic("invalid entry type")
-		}
+		replayEntry(&entry, replayLabel, replayLease)
+		log.seq++
 	}
 
 	return log
@@ -0,0 +1,79 @@
+package tagsvc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pgm/pliant/v2"
+)
+
+// a peer which has not advertised within this long is assumed to be gone, along with its chunks
+var PEER_TTL = 5 * time.Minute
+
+// maximum number of peers returned for a single chunk
+var MAX_PEERS_PER_CHUNK = 5
+
+// Records which minions have which chunks in their cache, so minions can fetch chunks from each other
+// instead of from the bucket.
+type PeerRegistry struct {
+	lock sync.Mutex
+	// the last time each peer advertised, by address
+	lastSeen map[string]time.Time
+	// the peers which have advertised each key
+	holders map[v2.Key][]string
+}
+
+func NewPeerRegistry() *PeerRegistry {
+	return &PeerRegistry{lastSeen: make(map[string]time.Time), holders: make(map[v2.Key][]string)}
+}
+
+// Records that the peer at address holds keys, and that it is still alive
+func (r *PeerRegistry) Advertise(address string, keys []*v2.Key, now time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.lastSeen[address] = now
+	for _, key := range keys {
+		holders := r.holders[*key]
+		found := false
+		for _, holder := range holders {
+			if holder == address {
+				found = true
+				break
+			}
+		}
+		if !found {
+			r.holders[*key] = append(holders, address)
+		}
+	}
+}
+
+// Returns the live peers which hold key, dropping any which have expired
+func (r *PeerRegistry) Find(key *v2.Key, now time.Time) []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	holders := r.holders[*key]
+	live := make([]string, 0, len(holders))
+	for _, holder := range holders {
+		if now.Sub(r.lastSeen[holder]) < PEER_TTL {
+			live = append(live, holder)
+		}
+	}
+	if len(live) == 0 {
+		delete(r.holders, *key)
+	} else {
+		r.holders[*key] = live
+	}
+
+	// rotate through the holders so requests for the same chunk are spread across them
+	if len(live) > MAX_PEERS_PER_CHUNK {
+		offset := int(now.UnixNano() % int64(len(live)))
+		rotated := make([]string, MAX_PEERS_PER_CHUNK)
+		for i := range rotated {
+			rotated[i] = live[(offset+i)%len(live)]
+		}
+		return rotated
+	}
+	return live
+}
@@ -0,0 +1,260 @@
+package tagsvc
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pgm/pliant/v2"
+)
+
+// Minimal blob storage needed to persist the root log remotely.  Names are relative to wherever the store
+// keeps its objects (ie: the prefix of the chunk bucket)
+type LogStore interface {
+	// returns nil if no object with that name exists
+	GetObject(name string) ([]byte, error)
+	// unconditionally writes the object, replacing any previous version
+	PutObject(name string, data []byte) error
+	// writes the object only if no object with that name exists.  Returns v2.OBJECT_EXISTS otherwise.
+	CreateObject(name string, data []byte) error
+}
+
+// returned once another master has written a log entry this master did not know about.  After this the
+// log refuses all further writes, because the in-memory state of this master is stale.
+var SEQUENCE_CONFLICT error = v2.NewError(v2.ERR_CONFLICT, "Log sequence number already written by another master")
+
+const REMOTE_LOG_CHECKPOINT = "roots/checkpoint"
+
+// number of entries written between checkpoints
+var CHECKPOINT_INTERVAL uint64 = 100
+
+func remoteLogEntryName(seq uint64) string {
+	return fmt.Sprintf("roots/log/%016d", seq)
+}
+
+// A root log stored as one immutable object per entry, named by sequence number.  Each entry is created
+// with CreateObject so two masters can never both write the same sequence number.  Periodically
+// the full set of live labels and leases is written to a checkpoint object so replay does not need to
+// start from the first entry.
+type RemoteLog struct {
+	lock  sync.Mutex
+	store LogStore
+
+	// sequence number of the next entry to write
+	nextSeq uint64
+	// sequence number of the last entry included in the checkpoint
+	checkpointSeq uint64
+	fenced        bool
+
+	// a copy of the state recorded in the log, used to write checkpoints
+	labels map[string]*v2.Key
+	leases []KeyLease
+}
+
+func OpenRemoteLog(store LogStore, replayLabel func(label string, key *v2.Key), replayLease func(key *v2.Key, timestamp uint64)) (*RemoteLog, error) {
+	rl := &RemoteLog{store: store, labels: make(map[string]*v2.Key), leases: make([]KeyLease, 0)}
+
+	apply := func(entry *v2.RootLog) {
+		replayEntry(entry, func(label string, key *v2.Key) {
+			rl.recordLabel(label, key)
+			replayLabel(label, key)
+		}, func(key *v2.Key, timestamp uint64) {
+			rl.recordLease(key, timestamp)
+			replayLease(key, timestamp)
+		})
+	}
+
+	buffer, err := store.GetObject(REMOTE_LOG_CHECKPOINT)
+	if err != nil {
+		return nil, err
+	}
+	if buffer != nil {
+		var checkpoint v2.RootLogCheckpoint
+		err = proto.Unmarshal(buffer, &checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range checkpoint.GetEntries() {
+			apply(entry)
+		}
+		rl.checkpointSeq = checkpoint.GetSeq()
+	}
+
+	seq := rl.checkpointSeq + 1
+	for {
+		buffer, err := store.GetObject(remoteLogEntryName(seq))
+		if err != nil {
+			return nil, err
+		}
+		if buffer == nil {
+			break
+		}
+
+		var entry v2.RootLog
+		err = proto.Unmarshal(buffer, &entry)
+		if err != nil {
+			return nil, err
+		}
+		apply(&entry)
+		seq++
+	}
+	rl.nextSeq = seq
+
+	return rl, nil
+}
+
+func (rl *RemoteLog) recordLabel(label string, key *v2.Key) {
+	if key == nil {
+		delete(rl.labels, label)
+	} else {
+		rl.labels[label] = key
+	}
+}
+
+func (rl *RemoteLog) recordLease(key *v2.Key, timestamp uint64) {
+	rl.leases = append(rl.leases, KeyLease{timestamp: timestamp, key: key})
+}
+
+func (rl *RemoteLog) write(entry *v2.RootLog) error {
+	if rl.fenced {
+		return SEQUENCE_CONFLICT
+	}
+
+	err := rl.store.CreateObject(remoteLogEntryName(rl.nextSeq), marshalEntry(entry))
+	if err == v2.OBJECT_EXISTS {
+		rl.fenced = true
+		return SEQUENCE_CONFLICT
+	} else if err != nil {
+		return err
+	}
+	rl.nextSeq++
+
+	return nil
+}
+
+// write a checkpoint if enough entries have been written since the last one.  A failure to write the
+// checkpoint is not fatal because the entries themselves are already durable.
+func (rl *RemoteLog) maybeCheckpoint() {
+	lastSeq := rl.nextSeq - 1
+	if lastSeq-rl.checkpointSeq < CHECKPOINT_INTERVAL {
+		return
+	}
+
+	now := uint64(time.Now().Unix())
+	entries := make([]*v2.RootLog, 0, len(rl.labels)+len(rl.leases))
+	liveLeases := make([]KeyLease, 0, len(rl.leases))
+	for _, lease := range rl.leases {
+		if lease.timestamp >= now {
+			liveLeases = append(liveLeases, lease)
+			entries = append(entries, leaseEntry(lease.key, lease.timestamp))
+		}
+	}
+	for label, key := range rl.labels {
+		entries = append(entries, labelEntry(label, key))
+	}
+
+	buffer, err := proto.Marshal(&v2.RootLogCheckpoint{Seq: proto.Uint64(lastSeq), Entries: entries})
+	if err != nil {
+		panic(err.Error())
+	}
+
+	err = rl.store.PutObject(REMOTE_LOG_CHECKPOINT, buffer)
+	if err != nil {
+		log.Printf("Could not write checkpoint: %s", err)
+		return
+	}
+
+	rl.leases = liveLeases
+	rl.checkpointSeq = lastSeq
+}
+
+func (rl *RemoteLog) appendLabel(label string, key *v2.Key) error {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	err := rl.write(labelEntry(label, key))
+	if err != nil {
+		return err
+	}
+	rl.recordLabel(label, key)
+	rl.maybeCheckpoint()
+
+	return nil
+}
+
+func (rl *RemoteLog) appendLabels(changes []SetArgs) error {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	err := rl.write(batchEntry(changes))
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		rl.recordLabel(change.Label, change.Key)
+	}
+	rl.maybeCheckpoint()
+
+	return nil
+}
+
+func (rl *RemoteLog) appendLease(key *v2.Key, timestamp uint64) error {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	err := rl.write(leaseEntry(key, timestamp))
+	if err != nil {
+		return err
+	}
+	rl.recordLease(key, timestamp)
+	rl.maybeCheckpoint()
+
+	return nil
+}
+
+func (rl *RemoteLog) lastSeq() uint64 {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	return rl.nextSeq - 1
+}
+
+func (rl *RemoteLog) Close() {
+}
+
+type MemLogStore struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func NewMemLogStore() *MemLogStore {
+	return &MemLogStore{objects: make(map[string][]byte)}
+}
+
+func (s *MemLogStore) GetObject(name string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.objects[name], nil
+}
+
+func (s *MemLogStore) PutObject(name string, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.objects[name] = data
+	return nil
+}
+
+func (s *MemLogStore) CreateObject(name string, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, exists := s.objects[name]; exists {
+		return v2.OBJECT_EXISTS
+	}
+	s.objects[name] = data
+	return nil
+}
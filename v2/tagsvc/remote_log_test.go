@@ -0,0 +1,87 @@
+package tagsvc
+
+import (
+	"github.com/pgm/pliant/v2"
+	. "gopkg.in/check.v1"
+)
+
+type RemoteLogSuite struct{}
+
+var _ = Suite(&RemoteLogSuite{})
+
+func (s *RemoteLogSuite) TestReplay(c *C) {
+	store := NewMemLogStore()
+
+	key1 := v2.Key{1}
+	key2 := v2.Key{2}
+
+	roots1, err := NewRemoteRoots(store)
+	c.Assert(err, IsNil)
+	c.Assert(roots1.Set("a", &key1), IsNil)
+	c.Assert(roots1.Set("b", &key2), IsNil)
+	c.Assert(roots1.Set("a", nil), IsNil)
+	c.Assert(roots1.AddLease(100, &key1), IsNil)
+	c.Assert(roots1.SetMany([]SetArgs{{"c", &key1}, {"d", &key2}}, nil), IsNil)
+
+	roots2, err := NewRemoteRoots(store)
+	c.Assert(err, IsNil)
+	c.Assert(roots2.Get("a"), IsNil)
+	c.Assert(roots2.Get("b"), DeepEquals, &key2)
+	c.Assert(roots2.Get("c"), DeepEquals, &key1)
+	c.Assert(roots2.Get("d"), DeepEquals, &key2)
+	c.Assert(len(roots2.GetRoots()), Equals, 4)
+}
+
+func (s *RemoteLogSuite) TestCheckpoint(c *C) {
+	oldInterval := CHECKPOINT_INTERVAL
+	CHECKPOINT_INTERVAL = 2
+	defer func() { CHECKPOINT_INTERVAL = oldInterval }()
+
+	store := NewMemLogStore()
+
+	key1 := v2.Key{1}
+	key2 := v2.Key{2}
+
+	roots1, _ := NewRemoteRoots(store)
+	roots1.Set("a", &key1)
+	roots1.Set("b", &key1)
+	roots1.Set("b", &key2)
+
+	checkpoint, _ := store.GetObject(REMOTE_LOG_CHECKPOINT)
+	c.Assert(checkpoint, NotNil)
+
+	// entries included in the checkpoint are no longer needed for replay
+	store.PutObject(remoteLogEntryName(1), nil)
+	store.PutObject(remoteLogEntryName(2), nil)
+
+	roots2, err := NewRemoteRoots(store)
+	c.Assert(err, IsNil)
+	c.Assert(roots2.Get("a"), DeepEquals, &key1)
+	c.Assert(roots2.Get("b"), DeepEquals, &key2)
+
+	// and the next entry continues the sequence
+	c.Assert(roots2.Set("c", &key1), IsNil)
+	next, _ := store.GetObject(remoteLogEntryName(4))
+	c.Assert(next, NotNil)
+}
+
+func (s *RemoteLogSuite) TestConcurrentMasters(c *C) {
+	store := NewMemLogStore()
+
+	key1 := v2.Key{1}
+	key2 := v2.Key{2}
+
+	roots1, _ := NewRemoteRoots(store)
+	roots2, _ := NewRemoteRoots(store)
+
+	c.Assert(roots1.Set("a", &key1), IsNil)
+	c.Assert(roots2.Set("a", &key2), Equals, SEQUENCE_CONFLICT)
+	c.Assert(roots2.Get("a"), IsNil)
+
+	// once fenced, the stale master stays fenced
+	c.Assert(roots2.Set("b", &key2), Equals, SEQUENCE_CONFLICT)
+
+	roots3, _ := NewRemoteRoots(store)
+	c.Assert(roots3.Get("a"), DeepEquals, &key1)
+	c.Assert(roots3.Get("b"), IsNil)
+}
@@ -3,7 +3,10 @@ package tagsvc
 import (
 	"container/heap"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/pgm/pliant/v2"
 )
@@ -26,45 +29,194 @@ type Roots struct {
 	// all anonymous roots with a time-to-live.  After which they expire
 	leases Leases
 
-	log *Log
+	log logWriter
+
+	// the most recent label changes, oldest first, used to answer Watch
+	history []v2.TagChange
+	// changes at or before this sequence number are not in history
+	historyFloor uint64
+	// closed and replaced each time a label changes
+	changed chan bool
 
 	// the GC current state
 	coloring *Coloring
 }
 
+// the number of label changes remembered for watchers
+var WATCH_HISTORY = 10000
+
+type openLogFn func(replayLabel func(label string, key *v2.Key), replayLease func(key *v2.Key, timestamp uint64)) (logWriter, error)
+
 func NewRoots(logName string) *Roots {
+	roots, _ := newRoots(func(replayLabel func(label string, key *v2.Key), replayLease func(key *v2.Key, timestamp uint64)) (logWriter, error) {
+		return OpenLog(logName, replayLabel, replayLease), nil
+	})
+	return roots
+}
+
+// Creates roots which are persisted as a sequence of objects in store instead of a local file, so that
+// the master can be restarted on any host by replaying the log from the store.
+func NewRemoteRoots(store LogStore) (*Roots, error) {
+	return newRoots(func(replayLabel func(label string, key *v2.Key), replayLease func(key *v2.Key, timestamp uint64)) (logWriter, error) {
+		return OpenRemoteLog(store, replayLabel, replayLease)
+	})
+}
+
+func newRoots(openLog openLogFn) (*Roots, error) {
 	leases := Leases(make([]KeyLease, 0))
 	labels := make(map[string]*v2.Key)
 
-	log := OpenLog(logName, func(label string, key *v2.Key) {
-		labels[label] = key
+	log, err := openLog(func(label string, key *v2.Key) {
+		if key == nil {
+			delete(labels, label)
+		} else {
+			labels[label] = key
+		}
 	},
 		func(key *v2.Key, timestamp uint64) {
 			leases = append(leases, KeyLease{timestamp: timestamp, key: key})
 		})
+	if err != nil {
+		return nil, err
+	}
 
 	roots := &Roots{
-		log:      log,
-		labels:   labels,
-		leases:   leases,
-		coloring: &Coloring{gray: make(map[v2.Key]int), black: make(map[v2.Key]int)}}
+		log:          log,
+		labels:       labels,
+		leases:       leases,
+		history:      make([]v2.TagChange, 0),
+		historyFloor: log.lastSeq(),
+		changed:      make(chan bool),
+		coloring:     &Coloring{gray: make(map[v2.Key]int), black: make(map[v2.Key]int)}}
 	heap.Init(&roots.leases)
 	//fmt.Printf("%s", roots)
-	return roots
+	return roots, nil
 }
 
-func (r *Roots) Set(label string, key *v2.Key) {
+func (r *Roots) Set(label string, key *v2.Key) error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
+	// only update the in-memory state once the change has been durably recorded
+	err := r.log.appendLabel(label, key)
+	if err != nil {
+		return err
+	}
+
 	if key == nil {
 		delete(r.labels, label)
 	} else {
 		r.labels[label] = key
 		r.coloring.mark(key, GRAY)
 	}
+	r.recordChange(label, key)
 
-	r.log.appendLabel(label, key)
+	return nil
+}
+
+// Applies all of the changes as a single log entry, provided every label in expected currently has the
+// given key (or does not exist, if the expected key is nil).  Otherwise nothing is changed and
+// LABEL_CHANGED is returned.
+func (r *Roots) SetMany(changes []SetArgs, expected map[string]*v2.Key) error {
+	seen := make(map[string]bool)
+	for _, change := range changes {
+		if seen[change.Label] {
+			return fmt.Errorf("Label %s changed more than once", change.Label)
+		}
+		seen[change.Label] = true
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for label, key := range expected {
+		current := r.labels[label]
+		if (current == nil) != (key == nil) || (current != nil && *current != *key) {
+			return LABEL_CHANGED
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	err := r.log.appendLabels(changes)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		if change.Key == nil {
+			delete(r.labels, change.Label)
+		} else {
+			r.labels[change.Label] = change.Key
+			r.coloring.mark(change.Key, GRAY)
+		}
+		r.recordChange(change.Label, change.Key)
+	}
+
+	return nil
+}
+
+func (r *Roots) recordChange(label string, key *v2.Key) {
+	r.history = append(r.history, v2.TagChange{Seq: r.log.lastSeq(), Name: label, Key: key})
+	if len(r.history) > WATCH_HISTORY {
+		dropped := len(r.history) - WATCH_HISTORY
+		r.historyFloor = r.history[dropped-1].Seq
+		r.history = r.history[dropped:]
+	}
+
+	close(r.changed)
+	r.changed = make(chan bool)
+}
+
+// must be called with lock held
+func (r *Roots) changesSince(prefix string, sinceSeq uint64, canRead func(label string) bool) ([]v2.TagChange, uint64) {
+	seq := r.log.lastSeq()
+	changes := make([]v2.TagChange, 0)
+
+	if sinceSeq < r.historyFloor || sinceSeq > seq {
+		// we no longer know what happened after sinceSeq, so report the current state instead
+		for _, root := range r.unsafeList(prefix, "") {
+			if canRead(root.Name) {
+				changes = append(changes, v2.TagChange{Seq: seq, Name: root.Name, Key: root.Key})
+			}
+		}
+		return changes, seq
+	}
+
+	i := sort.Search(len(r.history), func(i int) bool {
+		return r.history[i].Seq > sinceSeq
+	})
+	for _, change := range r.history[i:] {
+		if strings.HasPrefix(change.Name, prefix) && canRead(change.Name) {
+			changes = append(changes, change)
+		}
+	}
+	return changes, seq
+}
+
+// Waits up to timeout for a label starting with prefix to change after sinceSeq.  Returns the changes
+// and the sequence number of the last log entry.
+func (r *Roots) Watch(prefix string, sinceSeq uint64, timeout time.Duration, canRead func(label string) bool) ([]v2.TagChange, uint64) {
+	deadline := time.Now().Add(timeout)
+	for {
+		r.lock.Lock()
+		changes, seq := r.changesSince(prefix, sinceSeq, canRead)
+		changed := r.changed
+		r.lock.Unlock()
+
+		remaining := deadline.Sub(time.Now())
+		if len(changes) > 0 || remaining <= 0 {
+			return changes, seq
+		}
+
+		select {
+		case <-changed:
+		case <-time.After(remaining):
+		}
+		sinceSeq = seq
+	}
 }
 
 func (r *Roots) Get(label string) *v2.Key {
@@ -74,14 +226,19 @@ func (r *Roots) Get(label string) *v2.Key {
 	return r.labels[label]
 }
 
-func (r *Roots) AddLease(expiry uint64, key *v2.Key) {
+func (r *Roots) AddLease(expiry uint64, key *v2.Key) error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
+	err := r.log.appendLease(key, expiry)
+	if err != nil {
+		return err
+	}
+
 	heap.Push(&r.leases, KeyLease{expiry, key})
 	r.coloring.mark(key, GRAY)
 
-	r.log.appendLease(key, expiry)
+	return nil
 }
 
 // Find all leases which have expired, remove them and return the list of the removed
@@ -116,6 +273,31 @@ func (r *Roots) GetNamedRoots() []NameAndKey {
 	return roots
 }
 
+// returns the labels starting with prefix which sort after "after", in sorted order
+func (r *Roots) List(prefix string, after string) []NameAndKey {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.unsafeList(prefix, after)
+}
+
+func (r *Roots) unsafeList(prefix string, after string) []NameAndKey {
+	roots := make([]NameAndKey, 0, 100)
+	for name, key := range r.labels {
+		if strings.HasPrefix(name, prefix) && name > after {
+			roots = append(roots, NameAndKey{name, key})
+		}
+	}
+	sort.Sort(byName(roots))
+	return roots
+}
+
+type byName []NameAndKey
+
+func (l byName) Len() int           { return len(l) }
+func (l byName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+func (l byName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
 func (r *Roots) GetRoots() []*v2.Key {
 	namedRoots := r.GetNamedRoots()
 	result := make([]*v2.Key, len(namedRoots))
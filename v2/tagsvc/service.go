@@ -2,6 +2,7 @@ package tagsvc
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"net"
 
@@ -10,15 +11,31 @@ import (
 	//"net/http"
 	"net/rpc"
 	//	"strconv"
-	"bytes"
-	"crypto/md5"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"time"
 )
 
 const CHALLENGE_SIZE int = 64
-const GREETING string = "minion_v1\n"
+const GREETING string = "minion_v2\n"
+
+// sent by the master after checking the client's response
+const AUTH_OK byte = 1
+const AUTH_FAILED byte = 0
+
+// how long a newly accepted connection has to complete the handshake
+var HANDSHAKE_TIMEOUT = 10 * time.Second
+
+var AUTH_REJECTED error = errors.New("Authentication rejected by master")
+var GC_ENCRYPTED error = errors.New("GC cannot run on encrypted chunks, because the master cannot read them")
 
 func RandomChallenge() []byte {
 	b := make([]byte, CHALLENGE_SIZE)
@@ -27,16 +44,15 @@ func RandomChallenge() []byte {
 }
 
 func ComputeResponse(secret []byte, client []byte, server []byte) []byte {
-	buf := bytes.NewBuffer(make([]byte, 0, 100))
-	buf.Write(secret)
-	buf.Write(client)
-	buf.Write(server)
-	response := md5.Sum(buf.Bytes())
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(client)
+	mac.Write(server)
 
-	return response[:]
+	return mac.Sum(nil)
 }
 
-var NO_SUCH_KEY error = errors.New("No such key")
+var NO_SUCH_KEY error = v2.NewError(v2.ERR_NOT_FOUND, "No such key")
+var LABEL_CHANGED error = v2.NewError(v2.ERR_CONFLICT, "Label does not have the expected key")
 
 type Config struct {
 	AccessKeyId     string
@@ -47,11 +63,101 @@ type Config struct {
 	Prefix          string
 	PersistPath     string
 	AuthSecret      string
+	// if both are set, the master only accepts TLS connections using this certificate
+	TLSCertFile string
+	TLSKeyFile  string
+	// if set, the roots are persisted as objects in the bucket instead of in the file at PersistPath
+	RemoteLog bool
+	// if set, minions must authenticate as one of the identities in this file instead of using AuthSecret
+	ACLFile string
+	// if set, the S3 credentials are not given to minions.  Instead they request a presigned URL
+	// from the master for each chunk they read or write.
+	PresignedAccess bool
+	// if set, small chunks are bundled into packs (see v2.PackingChunkService)
+	PackChunks bool
+	// if set, minions store chunks on the chunkd server at this URL instead of in the bucket.  The bucket
+	// is still used for anything else it is configured for (ie: RemoteLog)
+	ChunkServer string
+	// the secret the chunkd server was started with
+	ChunkServerSecret string
+	// set if minions encrypt chunks (see v2.EncryptingChunkService).  The chunks are then stored under names
+	// derived from a key only the minions hold, so the master cannot tell which are reachable and refuses to GC.
+	EncryptedChunks bool
+	// connection settings for the bucket, see s3.S3Options
+	Region      string
+	PathStyle   bool
+	DisableSSL  bool
+	PartSize    int64
+	Concurrency int
+}
+
+func (c *Config) S3Options() s3.S3Options {
+	return c.minionConfig().S3Options()
 }
 
+// The part of Config which minions need in order to reach the chunks.  The master's own secrets and file
+// paths are left out, because every authenticated minion can read this.
+type MinionConfig struct {
+	AccessKeyId       string
+	SecretAccessKey   string
+	Endpoint          string
+	Bucket            string
+	Prefix            string
+	PresignedAccess   bool
+	PackChunks        bool
+	ChunkServer       string
+	ChunkServerSecret string
+	EncryptedChunks   bool
+	Region            string
+	PathStyle         bool
+	DisableSSL        bool
+	PartSize          int64
+	Concurrency       int
+}
+
+func (c *Config) minionConfig() *MinionConfig {
+	m := &MinionConfig{
+		Endpoint:          c.Endpoint,
+		Bucket:            c.Bucket,
+		Prefix:            c.Prefix,
+		PresignedAccess:   c.PresignedAccess,
+		PackChunks:        c.PackChunks,
+		ChunkServer:       c.ChunkServer,
+		ChunkServerSecret: c.ChunkServerSecret,
+		EncryptedChunks:   c.EncryptedChunks,
+		Region:            c.Region,
+		PathStyle:         c.PathStyle,
+		DisableSSL:        c.DisableSSL,
+		PartSize:          c.PartSize,
+		Concurrency:       c.Concurrency}
+	if !c.PresignedAccess {
+		m.AccessKeyId = c.AccessKeyId
+		m.SecretAccessKey = c.SecretAccessKey
+	}
+	return m
+}
+
+func (c *MinionConfig) S3Options() s3.S3Options {
+	return s3.S3Options{Endpoint: c.Endpoint,
+		Region:      c.Region,
+		PathStyle:   c.PathStyle,
+		DisableSSL:  c.DisableSSL,
+		PartSize:    c.PartSize,
+		Concurrency: c.Concurrency}
+}
+
+// packs where less than this fraction of the bytes are still reachable are rewritten after a GC
+var REPACK_LIVE_FRACTION = 0.5
+
+// Each connection is served by its own Master which shares roots and config with all others
+// but records which identity the connection authenticated as.
 type Master struct {
-	roots  *Roots
-	config *Config
+	roots    *Roots
+	config   *Config
+	chunks   *s3.S3ChunkService
+	acl      *ACL
+	peers    *PeerRegistry
+	identity *Identity
 }
 
 type SetArgs struct {
@@ -59,13 +165,84 @@ type SetArgs struct {
 	Key   *v2.Key
 }
 
+type SetManyArgs struct {
+	Changes []SetArgs
+	// labels which must have the given key for the changes to be applied.  A nil key means the label
+	// must not exist.  (A slice rather than a map because gob cannot encode nil map values.)
+	Expected []SetArgs
+}
+
+type ListArgs struct {
+	Prefix string
+	// only return labels which sort after this one
+	After string
+	Limit int
+}
+
+// the most labels returned by a single call to List
+const MAX_LIST_LIMIT = 1000
+
+type WatchArgs struct {
+	Prefix string
+	// only report changes made after this sequence number
+	SinceSeq uint64
+	// how long to wait for a change before returning an empty reply
+	TimeoutSeconds int
+}
+
+type WatchReply struct {
+	Changes []v2.TagChange
+	// the sequence number to pass as SinceSeq on the next call
+	Seq uint64
+}
+
+// the longest a single Watch call will block, so watchers notice a dead master
+const MAX_WATCH_TIMEOUT = 5 * time.Minute
+
 type AddLeaseArgs struct {
 	Timeout uint64
 	Key     *v2.Key
 }
 
 func (t *Master) Set(args *SetArgs, reply *bool) error {
-	t.roots.Set(args.Label, args.Key)
+	if !t.identity.CanWrite(args.Label) {
+		logDenied(t.identity, "write", args.Label)
+		return PERMISSION_DENIED
+	}
+
+	err := t.roots.Set(args.Label, args.Key)
+	if err != nil {
+		return err
+	}
+
+	*reply = true
+
+	return nil
+}
+
+// Applies all of the changes together as a single log entry, or none of them if any label in
+// args.Expected has changed.
+func (t *Master) SetMany(args *SetManyArgs, reply *bool) error {
+	for _, change := range args.Changes {
+		if !t.identity.CanWrite(change.Label) {
+			logDenied(t.identity, "write", change.Label)
+			return PERMISSION_DENIED
+		}
+	}
+
+	expected := make(map[string]*v2.Key)
+	for _, e := range args.Expected {
+		if !t.identity.CanRead(e.Label) {
+			logDenied(t.identity, "read", e.Label)
+			return PERMISSION_DENIED
+		}
+		expected[e.Label] = e.Key
+	}
+
+	err := t.roots.SetMany(args.Changes, expected)
+	if err != nil {
+		return err
+	}
 
 	*reply = true
 
@@ -73,6 +250,11 @@ func (t *Master) Set(args *SetArgs, reply *bool) error {
 }
 
 func (t *Master) Get(label *string, reply *v2.Key) error {
+	if !t.identity.CanRead(*label) {
+		logDenied(t.identity, "read", *label)
+		return PERMISSION_DENIED
+	}
+
 	replyPtr := t.roots.Get(*label)
 	if replyPtr == nil {
 		return NO_SUCH_KEY
@@ -82,15 +264,95 @@ func (t *Master) Get(label *string, reply *v2.Key) error {
 	return nil
 }
 
+func (t *Master) Delete(label *string, reply *bool) error {
+	if !t.identity.CanWrite(*label) {
+		logDenied(t.identity, "delete", *label)
+		return PERMISSION_DENIED
+	}
+
+	if t.roots.Get(*label) == nil {
+		return NO_SUCH_KEY
+	}
+
+	err := t.roots.Set(*label, nil)
+	if err != nil {
+		return err
+	}
+
+	*reply = true
+
+	return nil
+}
+
+// Returns a page of labels starting with the prefix, in sorted order.  Unlike GetAll, anonymous
+// leases are not included.
+func (t *Master) List(args *ListArgs, reply *[]NameAndKey) error {
+	limit := args.Limit
+	if limit <= 0 || limit > MAX_LIST_LIMIT {
+		limit = MAX_LIST_LIMIT
+	}
+
+	roots := t.roots.List(args.Prefix, args.After)
+	page := make([]NameAndKey, 0, limit)
+	for _, root := range roots {
+		if len(page) >= limit {
+			break
+		}
+		if t.identity.CanRead(root.Name) {
+			page = append(page, root)
+		}
+	}
+	*reply = page
+
+	return nil
+}
+
+// Blocks until a readable label starting with args.Prefix changes after args.SinceSeq, or the timeout
+// expires.  If the master no longer remembers that far back, every matching label is returned instead.
+func (t *Master) Watch(args *WatchArgs, reply *WatchReply) error {
+	timeout := time.Duration(args.TimeoutSeconds) * time.Second
+	if timeout < 0 || timeout > MAX_WATCH_TIMEOUT {
+		timeout = MAX_WATCH_TIMEOUT
+	}
+
+	changes, seq := t.roots.Watch(args.Prefix, args.SinceSeq, timeout, t.identity.CanRead)
+	reply.Changes = changes
+	reply.Seq = seq
+
+	return nil
+}
+
 func (t *Master) GetAll(ignored *string, reply *[]NameAndKey) error {
-	*reply = t.roots.GetNamedRoots()
+	roots := t.roots.GetNamedRoots()
+	readable := make([]NameAndKey, 0, len(roots))
+	canReadAll := t.identity.CanReadAll()
+	for _, root := range roots {
+		// the names of anonymous leases are blank so only show them to those who can see everything
+		if root.Name == "" {
+			if !canReadAll {
+				continue
+			}
+		} else if !t.identity.CanRead(root.Name) {
+			continue
+		}
+		readable = append(readable, root)
+	}
+	*reply = readable
 
 	return nil
 }
 
 func (t *Master) AddLease(args *AddLeaseArgs, reply *bool) error {
+	if !t.identity.CanLease() {
+		logDenied(t.identity, "lease", args.Key.String())
+		return PERMISSION_DENIED
+	}
+
 	now := uint64(time.Now().Unix())
-	t.roots.AddLease(args.Timeout+now, args.Key)
+	err := t.roots.AddLease(args.Timeout+now, args.Key)
+	if err != nil {
+		return err
+	}
 
 	*reply = true
 
@@ -98,22 +360,104 @@ func (t *Master) AddLease(args *AddLeaseArgs, reply *bool) error {
 }
 
 func (t *Master) GC(label *string, reply *v2.Key) error {
-	panic("todo: update code to pass in bolt db ref")
-	cache, _ := v2.NewFilesystemCacheDB("cache", nil)
-	chunkService := s3.NewS3ChunkService(t.config.AccessKeyId, t.config.SecretAccessKey, t.config.Endpoint, t.config.Bucket, t.config.Prefix, cache.AllocateTempFilename)
-	dirService := v2.NewLeafDirService(chunkService)
-	t.roots.GC(dirService, chunkService, chunkService.Delete)
+	if !t.identity.CanWriteAll() {
+		logDenied(t.identity, "gc", ALL_LABELS)
+		return PERMISSION_DENIED
+	}
+	if t.config.EncryptedChunks {
+		// every chunk would look unreachable and be deleted
+		return GC_ENCRYPTED
+	}
 
+	// directories are downloaded while marking reachable chunks, into a directory which is removed afterwards
+	tempDir, err := ioutil.TempDir("", "pliant-gc")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+	var downloads int64
+	getDestFn := func() string {
+		return filepath.Join(tempDir, fmt.Sprintf("%d", atomic.AddInt64(&downloads, 1)))
+	}
+
+	chunkService := s3.NewS3ChunkServiceWithOptions(t.config.AccessKeyId, t.config.SecretAccessKey, t.config.S3Options(), t.config.Bucket, t.config.Prefix, getDestFn)
+	if !t.config.PackChunks {
+		dirService := v2.NewLeafDirService(&v2.DecodingChunkService{chunkService})
+		t.roots.GC(dirService, chunkService, chunkService.Delete)
+		return nil
+	}
+
+	packed, err := v2.NewPackingChunkService(chunkService, chunkService)
+	if err != nil {
+		return err
+	}
+	dirService := v2.NewLeafDirService(&v2.DecodingChunkService{packed})
+	t.roots.GC(dirService, packed, packed.Delete)
+	_, err = packed.Repack(REPACK_LIVE_FRACTION)
+
+	return err
+}
+
+func (t *Master) GetConfig(nothing *string, reply *MinionConfig) error {
+	*reply = *t.config.minionConfig()
 	return nil
 }
 
-func (t *Master) GetConfig(nothing *string, reply *Config) error {
-	fmt.Printf("reply=%s\nconfig=%s\n", reply, t.config)
-	*reply = *t.config
+func (t *Master) SignGet(key *v2.Key, reply *string) error {
+	url, err := t.chunks.PresignGet(key)
+	if err != nil {
+		return err
+	}
+	*reply = url
 
 	return nil
 }
 
+// only minions which can write some label can upload chunks
+func (t *Master) SignPut(key *v2.Key, reply *string) error {
+	if !t.identity.CanLease() {
+		logDenied(t.identity, "upload", key.String())
+		return PERMISSION_DENIED
+	}
+
+	url, err := t.chunks.PresignPut(key)
+	if err != nil {
+		return err
+	}
+	*reply = url
+
+	return nil
+}
+
+type AdvertiseChunksArgs struct {
+	// the address of the minion's peer server
+	Address string
+	Keys    []*v2.Key
+}
+
+// Other minions try advertised peers before the bucket, so only minions which could upload the chunks
+// themselves may advertise them.
+func (t *Master) AdvertiseChunks(args *AdvertiseChunksArgs, reply *bool) error {
+	if !t.identity.CanLease() {
+		logDenied(t.identity, "advertise", args.Address)
+		return PERMISSION_DENIED
+	}
+
+	t.peers.Advertise(args.Address, args.Keys, time.Now())
+	*reply = true
+	return nil
+}
+
+func (t *Master) FindPeers(key *v2.Key, reply *[]string) error {
+	if !t.identity.CanReadAny() {
+		logDenied(t.identity, "peer lookup", key.String())
+		return PERMISSION_DENIED
+	}
+
+	*reply = t.peers.Find(key, time.Now())
+	return nil
+}
+
 /*	clientChallenge := RandomChallenge()
 
 	conn.Write([]byte(GREETING))
@@ -129,32 +473,94 @@ func (t *Master) GetConfig(nothing *string, reply *Config) error {
 	conn.Write(response)
 */
 
-func handleConnection(config *Config, conn net.Conn) {
+// finds the identity a minion is claiming to be.  Without an ACL, only the anonymous identity exists.
+func (t *Master) lookupIdentity(name string) *Identity {
+	if t.acl == nil {
+		if name != "" {
+			return nil
+		}
+		return anonymousIdentity(t.config.AuthSecret)
+	}
+	return t.acl.Get(name)
+}
+
+// Performs the server side of the challenge-response handshake.  Returns the identity the client
+// proved it holds the secret for, or an error in which case the connection should be dropped.
+func (t *Master) authenticate(conn net.Conn) (*Identity, error) {
 	serverChallenge := RandomChallenge()
 	clientChallenge := make([]byte, CHALLENGE_SIZE)
 
 	greetingBuffer := make([]byte, len([]byte(GREETING)))
-	conn.Read(greetingBuffer)
-	n, _ := conn.Read(clientChallenge)
-	if n != CHALLENGE_SIZE {
-		log.Fatalf("expecting challenge but read %d", n)
+	_, err := io.ReadFull(conn, greetingBuffer)
+	if err != nil {
+		return nil, err
+	}
+	if string(greetingBuffer) != GREETING {
+		return nil, errors.New("Unexpected greeting")
 	}
 
-	conn.Write(serverChallenge)
+	nameLength := make([]byte, 1)
+	_, err = io.ReadFull(conn, nameLength)
+	if err != nil {
+		return nil, err
+	}
+	name := make([]byte, int(nameLength[0]))
+	_, err = io.ReadFull(conn, name)
+	if err != nil {
+		return nil, err
+	}
 
-	expected := ComputeResponse([]byte(config.AuthSecret), clientChallenge, serverChallenge)
-	response := make([]byte, len(expected))
-	conn.Read(response)
-	if bytes.Compare(expected, response) == 0 {
-		fmt.Printf("Auth succeeded!\n")
-		rpc.ServeConn(conn)
+	identity := t.lookupIdentity(string(name))
+	var secret []byte
+	if identity != nil {
+		secret = []byte(identity.Secret)
 	} else {
-		fmt.Printf("Auth failed!\n")
+		// go through the rest of the handshake so unknown names look the same as bad secrets
+		secret = RandomChallenge()
+	}
+
+	_, err = io.ReadFull(conn, clientChallenge)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.Write(serverChallenge)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := ComputeResponse(secret, clientChallenge, serverChallenge)
+	response := make([]byte, len(expected))
+	_, err = io.ReadFull(conn, response)
+	if err != nil {
+		return nil, err
+	}
+
+	if identity == nil || !hmac.Equal(expected, response) {
+		conn.Write([]byte{AUTH_FAILED})
+		return nil, fmt.Errorf("%s (minion %q)", AUTH_REJECTED, string(name))
+	}
+
+	_, err = conn.Write([]byte{AUTH_OK})
+	return identity, err
+}
+
+func (t *Master) handleConnection(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
+	identity, err := t.authenticate(conn)
+	if err != nil {
+		log.Printf("Auth failed for %s: %s", conn.RemoteAddr(), err)
 		conn.Close()
+		return
 	}
+	conn.SetDeadline(time.Time{})
+
+	server := rpc.NewServer()
+	server.Register(&Master{roots: t.roots, config: t.config, chunks: t.chunks, acl: t.acl, peers: t.peers, identity: identity})
+	server.ServeConn(conn)
 }
 
-func listenForever(config *Config, l net.Listener) {
+func (t *Master) listenForever(l net.Listener) {
 	for {
 		log.Printf("Serve starting")
 
@@ -164,21 +570,61 @@ func listenForever(config *Config, l net.Listener) {
 			break
 		}
 
-		go handleConnection(config, conn)
+		go t.handleConnection(conn)
 	}
 }
 
+func openRoots(config *Config, chunks *s3.S3ChunkService) (*Roots, error) {
+	if !config.RemoteLog {
+		return NewRoots(config.PersistPath), nil
+	}
+
+	return NewRemoteRoots(chunks)
+}
+
 func StartServer(config *Config) (net.Listener, error) {
-	ac := &Master{config: config, roots: NewRoots(config.PersistPath)}
-	rpc.Register(ac)
-	rpc.HandleHTTP()
-	l, e := net.Listen("tcp", fmt.Sprintf("localhost:%d", config.MasterPort))
-	if e != nil {
-		log.Fatal("listen error:", e)
-		return nil, e
+	var acl *ACL
+	if config.ACLFile != "" {
+		var err error
+		acl, err = LoadACL(config.ACLFile)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	go listenForever(config, l)
+	return startServer(config, acl)
+}
+
+func startServer(config *Config, acl *ACL) (net.Listener, error) {
+	// the master only signs URLs and reads and writes named objects, so no download location is needed
+	chunks := s3.NewS3ChunkServiceWithOptions(config.AccessKeyId, config.SecretAccessKey, config.S3Options(), config.Bucket, config.Prefix, nil)
+
+	roots, err := openRoots(config, chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	ac := &Master{config: config, roots: roots, chunks: chunks, acl: acl, peers: NewPeerRegistry()}
+
+	addr := fmt.Sprintf("localhost:%d", config.MasterPort)
+	var l net.Listener
+	if config.TLSCertFile != "" && config.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		l, err = tls.Listen("tcp", addr, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		l, err = net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	go ac.listenForever(l)
 
 	return l, nil
 }
@@ -187,10 +633,23 @@ type Client struct {
 	client *rpc.Client
 }
 
-func (c *Client) GetConfig() (*Config, error) {
-	var config Config
+// Makes an RPC to the master.  Errors returned by the master are turned back into the coded errors they
+// were sent as (ie: NO_SUCH_KEY), and failures to reach it are marked v2.ERR_REMOTE_UNAVAILABLE.
+func (c *Client) call(method string, args interface{}, reply interface{}) error {
+	err := c.client.Call(method, args, reply)
+	if err == nil {
+		return nil
+	}
+	if _, fromMaster := err.(rpc.ServerError); fromMaster {
+		return v2.ParseError(err)
+	}
+	return v2.RemoteUnavailable(err)
+}
+
+func (c *Client) GetConfig() (*MinionConfig, error) {
+	var config MinionConfig
 	param := "nil"
-	err := c.client.Call("Master.GetConfig", param, &config)
+	err := c.call("Master.GetConfig", param, &config)
 	if err != nil {
 		return nil, err
 	}
@@ -199,7 +658,7 @@ func (c *Client) GetConfig() (*Config, error) {
 
 func (c *Client) Get(label string) (*v2.Key, error) {
 	var key v2.Key
-	err := c.client.Call("Master.Get", label, &key)
+	err := c.call("Master.Get", label, &key)
 	if err != nil {
 		return nil, err
 	}
@@ -214,45 +673,166 @@ type NameAndKey struct {
 func (c *Client) GetAll() ([]NameAndKey, error) {
 	var input = ""
 	var result []NameAndKey
-	err := c.client.Call("Master.GetAll", &input, &result)
+	err := c.call("Master.GetAll", &input, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *Client) List(prefix string, after string, limit int) ([]NameAndKey, error) {
+	var result []NameAndKey
+	err := c.call("Master.List", &ListArgs{Prefix: prefix, After: after, Limit: limit}, &result)
 	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
+func (c *Client) Watch(prefix string, sinceSeq uint64, timeout time.Duration) ([]v2.TagChange, uint64, error) {
+	var reply WatchReply
+	err := c.call("Master.Watch", &WatchArgs{Prefix: prefix, SinceSeq: sinceSeq, TimeoutSeconds: int(timeout / time.Second)}, &reply)
+	if err != nil {
+		return nil, 0, err
+	}
+	return reply.Changes, reply.Seq, nil
+}
+
+func (c *Client) Delete(label string) error {
+	var reply bool
+	return c.call("Master.Delete", label, &reply)
+}
+
 func (c *Client) Set(label string, key *v2.Key) error {
-	err := c.client.Call("Master.Set", &SetArgs{label, key}, nil)
+	err := c.call("Master.Set", &SetArgs{label, key}, nil)
 	return err
 }
 
+// Sets all of the labels at once, provided each label in expected currently has the given key (or
+// does not exist, if the key is nil).  Returns LABEL_CHANGED if one did not.
+func (c *Client) SetMany(changes []SetArgs, expected map[string]*v2.Key) error {
+	args := &SetManyArgs{Changes: changes, Expected: make([]SetArgs, 0, len(expected))}
+	for label, key := range expected {
+		args.Expected = append(args.Expected, SetArgs{label, key})
+	}
+
+	var reply bool
+	return c.call("Master.SetMany", args, &reply)
+}
+
+func (c *Client) SignGet(key *v2.Key) (string, error) {
+	var url string
+	err := c.call("Master.SignGet", key, &url)
+	return url, err
+}
+
+func (c *Client) SignPut(key *v2.Key) (string, error) {
+	var url string
+	err := c.call("Master.SignPut", key, &url)
+	return url, err
+}
+
+// Deletes the chunks which are not reachable from any label or lease
+func (c *Client) GC() error {
+	param := "nil"
+	return c.call("Master.GC", param, nil)
+}
+
 func (c *Client) AddLease(Timeout uint64, Key *v2.Key) error {
-	err := c.client.Call("Master.AddLease", &AddLeaseArgs{Timeout, Key}, nil)
+	err := c.call("Master.AddLease", &AddLeaseArgs{Timeout, Key}, nil)
 	return err
 }
 
-func NewClient(address string, authSecret []byte) *Client {
-	conn, err := net.Dial("tcp", address)
+// Tells the master that the minion serving chunks at address has keys in its cache.  Also serves as a
+// heartbeat, so should be called at least once every PEER_TTL even if there are no new keys.
+func (c *Client) AdvertiseChunks(address string, keys []*v2.Key) error {
+	var reply bool
+	return c.call("Master.AdvertiseChunks", &AdvertiseChunksArgs{Address: address, Keys: keys}, &reply)
+}
+
+// Returns the addresses of peers which have the chunk in their cache
+func (c *Client) FindPeers(key *v2.Key) ([]string, error) {
+	var peers []string
+	err := c.call("Master.FindPeers", key, &peers)
+	return peers, err
+}
+
+// Loads a PEM encoded CA certificate to use for verifying the master's certificate
+func LoadClientTLSConfig(caFile string) (*tls.Config, error) {
+	pem, err := ioutil.ReadFile(caFile)
 	if err != nil {
-		log.Fatal("dialing:", err)
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in %s", caFile)
 	}
+	return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
 
+// Connects to the master and authenticates as the named minion, or with the shared secret if identity
+// is blank.  If tlsConfig is nil, the connection is made over plain TCP.
+func Dial(address string, identity string, authSecret []byte, tlsConfig *tls.Config) (*Client, error) {
+	if len(identity) > 255 {
+		return nil, errors.New("Identity name too long")
+	}
+
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		conn, err = tls.Dial("tcp", address, tlsConfig)
+	} else {
+		conn, err = net.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = clientHandshake(conn, identity, authSecret)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	client := rpc.NewClient(conn)
+	return &Client{client: client}, nil
+}
+
+func clientHandshake(conn net.Conn, identity string, authSecret []byte) error {
 	clientChallenge := RandomChallenge()
 
 	conn.Write([]byte(GREETING))
+	conn.Write([]byte{byte(len(identity))})
+	conn.Write([]byte(identity))
 	conn.Write(clientChallenge)
 
 	serverChallenge := make([]byte, CHALLENGE_SIZE)
-	n, err := conn.Read(serverChallenge)
-	if n != CHALLENGE_SIZE {
-		log.Fatalf("expecting challenge but read %d", n)
+	_, err := io.ReadFull(conn, serverChallenge)
+	if err != nil {
+		return err
 	}
 
-	response := ComputeResponse([]byte(authSecret), clientChallenge, serverChallenge)
+	response := ComputeResponse(authSecret, clientChallenge, serverChallenge)
 	conn.Write(response)
 
-	client := rpc.NewClient(conn)
-	return &Client{client: client}
+	status := make([]byte, 1)
+	_, err = io.ReadFull(conn, status)
+	if err != nil {
+		return err
+	}
+	if status[0] != AUTH_OK {
+		return AUTH_REJECTED
+	}
+
+	return nil
+}
+
+func NewClient(address string, authSecret []byte) *Client {
+	client, err := Dial(address, "", authSecret, nil)
+	if err != nil {
+		log.Fatal("dialing:", err)
+	}
+	return client
 }
 
 type TagService struct {
@@ -266,7 +846,7 @@ func (t *TagService) Put(name string, key *v2.Key) error {
 func (t *TagService) Get(name string) (*v2.Key, error) {
 	key, err := t.client.Get(name)
 	if err != nil {
-		if err.Error() == NO_SUCH_KEY.Error() {
+		if err == NO_SUCH_KEY {
 			return nil, nil
 		}
 		return nil, err
@@ -274,16 +854,46 @@ func (t *TagService) Get(name string) (*v2.Key, error) {
 	return key, nil
 }
 
-func (t *TagService) ForEach(callback func(name string, key *v2.Key)) {
-	result, err := t.client.GetAll()
-	if err != nil {
-		panic(err.Error())
+func (t *TagService) CompareAndPut(name string, expected *v2.Key, key *v2.Key) error {
+	err := t.client.SetMany([]SetArgs{{name, key}}, map[string]*v2.Key{name: expected})
+	if err == LABEL_CHANGED {
+		return v2.TAG_CHANGED
+	}
+	return err
+}
+
+func (t *TagService) Delete(name string) error {
+	err := t.client.Delete(name)
+	if err == NO_SUCH_KEY {
+		return v2.NO_SUCH_TAG
 	}
-	for _, nameAndKey := range result {
-		callback(nameAndKey.Name, nameAndKey.Key)
+	return err
+}
+
+// number of labels to fetch per call to the master
+var LIST_PAGE_SIZE = 100
+
+func (t *TagService) ForEach(prefix string, callback func(name string, key *v2.Key)) error {
+	after := ""
+	for {
+		page, err := t.client.List(prefix, after, LIST_PAGE_SIZE)
+		if err != nil {
+			return err
+		}
+		for _, nameAndKey := range page {
+			callback(nameAndKey.Name, nameAndKey.Key)
+		}
+		if len(page) < LIST_PAGE_SIZE {
+			return nil
+		}
+		after = page[len(page)-1].Name
 	}
 }
 
+func (t *TagService) Watch(prefix string, sinceSeq uint64, timeout time.Duration) ([]v2.TagChange, uint64, error) {
+	return t.client.Watch(prefix, sinceSeq, timeout)
+}
+
 func NewTagService(c *Client) v2.TagService {
 	return &TagService{client: c}
 }
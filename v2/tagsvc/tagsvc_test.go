@@ -1,10 +1,24 @@
 package tagsvc
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/pgm/pliant/v2"
@@ -123,7 +137,13 @@ func (s *TagSvcSuite) TestClientServer(c *C) {
 	client := NewClient(l.Addr().String(), []byte("x"))
 	vconfig, err := client.GetConfig()
 	c.Assert(err, IsNil)
-	c.Assert(vconfig, DeepEquals, config)
+	c.Assert(vconfig, DeepEquals, &MinionConfig{
+		AccessKeyId:     "access",
+		SecretAccessKey: "secret",
+		Endpoint:        "http://endpoint",
+		Bucket:          "bucket",
+		Prefix:          "prefix",
+	})
 
 	key1 := v2.Key{10}
 	err2 := client.AddLease(uint64(100), &key1)
@@ -141,3 +161,488 @@ func (s *TagSvcSuite) TestClientServer(c *C) {
 
 	l.Close()
 }
+
+func writePEM(filename string, blockType string, der []byte) {
+	fd, err := os.Create(filename)
+	if err != nil {
+		panic(err.Error())
+	}
+	pem.Encode(fd, &pem.Block{Type: blockType, Bytes: der})
+	fd.Close()
+}
+
+// creates a throwaway CA and a certificate for localhost signed by it.  Returns the paths to the CA
+// certificate, the server certificate and the server key.
+func generateCerts(dir string) (string, string, string) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pliant test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		panic(err.Error())
+	}
+	caCert, _ := x509.ParseCertificate(caDer)
+
+	serverKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	serverDer, err := x509.CreateCertificate(rand.Reader, serverTemplate, caCert, &serverKey.PublicKey, caKey)
+	if err != nil {
+		panic(err.Error())
+	}
+	serverKeyDer, _ := x509.MarshalECPrivateKey(serverKey)
+
+	caFile := dir + "/ca.pem"
+	certFile := dir + "/cert.pem"
+	keyFile := dir + "/key.pem"
+	writePEM(caFile, "CERTIFICATE", caDer)
+	writePEM(certFile, "CERTIFICATE", serverDer)
+	writePEM(keyFile, "EC PRIVATE KEY", serverKeyDer)
+
+	return caFile, certFile, keyFile
+}
+
+func (s *TagSvcSuite) TestTLSClientServer(c *C) {
+	tempfp, _ := ioutil.TempFile("", "tagsvc_test")
+	s.tempfile = tempfp.Name()
+
+	caFile, certFile, keyFile := generateCerts(c.MkDir())
+
+	config := &Config{
+		SecretAccessKey: "secret",
+		MasterPort:      0,
+		PersistPath:     s.tempfile,
+		AuthSecret:      "x",
+		TLSCertFile:     certFile,
+		TLSKeyFile:      keyFile,
+	}
+
+	l, err := StartServer(config)
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	tlsConfig, err := LoadClientTLSConfig(caFile)
+	c.Assert(err, IsNil)
+
+	client, err := Dial(l.Addr().String(), "", []byte("x"), tlsConfig)
+	c.Assert(err, IsNil)
+	vconfig, err := client.GetConfig()
+	c.Assert(err, IsNil)
+	c.Assert(vconfig.SecretAccessKey, Equals, "secret")
+
+	// clients which do not trust the CA fail the TLS handshake
+	otherCAFile, _, _ := generateCerts(c.MkDir())
+	otherTLSConfig, err := LoadClientTLSConfig(otherCAFile)
+	c.Assert(err, IsNil)
+	for _, untrusting := range []*tls.Config{otherTLSConfig, &tls.Config{RootCAs: x509.NewCertPool()}} {
+		_, err = Dial(l.Addr().String(), "", []byte("x"), untrusting)
+		var unknownAuthority x509.UnknownAuthorityError
+		c.Assert(errors.As(err, &unknownAuthority), Equals, true, Commentf("%v", err))
+	}
+
+	// nor can a client which does not use TLS
+	_, err = Dial(l.Addr().String(), "", []byte("x"), nil)
+	c.Assert(err, NotNil)
+}
+
+func (s *TagSvcSuite) TestBadSecret(c *C) {
+	tempfp, _ := ioutil.TempFile("", "tagsvc_test")
+	s.tempfile = tempfp.Name()
+
+	config := &Config{MasterPort: 0, PersistPath: s.tempfile, AuthSecret: "x"}
+
+	l, err := StartServer(config)
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	_, err = Dial(l.Addr().String(), "", []byte("y"), nil)
+	c.Assert(err, Equals, AUTH_REJECTED)
+
+	// without an ACL, only the anonymous identity exists
+	_, err = Dial(l.Addr().String(), "rnaseq", []byte("x"), nil)
+	c.Assert(err, Equals, AUTH_REJECTED)
+
+	// a client which hangs up mid-handshake must not take down the master
+	conn, err := net.Dial("tcp", l.Addr().String())
+	c.Assert(err, IsNil)
+	conn.Write([]byte(GREETING))
+	conn.Close()
+
+	client, err := Dial(l.Addr().String(), "", []byte("x"), nil)
+	c.Assert(err, IsNil)
+	_, err = client.GetConfig()
+	c.Assert(err, IsNil)
+}
+
+func (s *TagSvcSuite) TestACL(c *C) {
+	tempfp, _ := ioutil.TempFile("", "tagsvc_test")
+	s.tempfile = tempfp.Name()
+
+	acl := NewACL()
+	acl.Add(&Identity{Name: "rnaseq", Secret: "s1", Read: []string{"reference/"}, Write: []string{"rnaseq/"}})
+	acl.Add(&Identity{Name: "admin", Secret: "s2", Write: []string{ALL_LABELS}})
+
+	config := &Config{MasterPort: 0, PersistPath: s.tempfile, AuthSecret: "x"}
+	l, err := startServer(config, acl)
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	// the shared secret is not accepted once an ACL is configured
+	_, err = Dial(l.Addr().String(), "", []byte("x"), nil)
+	c.Assert(err, Equals, AUTH_REJECTED)
+	_, err = Dial(l.Addr().String(), "rnaseq", []byte("s2"), nil)
+	c.Assert(err, Equals, AUTH_REJECTED)
+
+	admin, err := Dial(l.Addr().String(), "admin", []byte("s2"), nil)
+	c.Assert(err, IsNil)
+	rnaseq, err := Dial(l.Addr().String(), "rnaseq", []byte("s1"), nil)
+	c.Assert(err, IsNil)
+
+	key1 := v2.Key{1}
+	c.Assert(admin.Set("reference/hg19", &key1), IsNil)
+	c.Assert(admin.Set("other/x", &key1), IsNil)
+	c.Assert(admin.AddLease(100, &key1), IsNil)
+
+	c.Assert(rnaseq.Set("rnaseq/run1", &key1), IsNil)
+	c.Assert(rnaseq.Set("reference/hg19", &key1).Error(), Equals, PERMISSION_DENIED.Error())
+	c.Assert(rnaseq.AddLease(100, &key1), IsNil)
+
+	_, err = rnaseq.Get("reference/hg19")
+	c.Assert(err, IsNil)
+	_, err = rnaseq.Get("other/x")
+	c.Assert(err.Error(), Equals, PERMISSION_DENIED.Error())
+
+	all, err := rnaseq.GetAll()
+	c.Assert(err, IsNil)
+	names := make(map[string]bool)
+	for _, r := range all {
+		names[r.Name] = true
+	}
+	c.Assert(names, DeepEquals, map[string]bool{"reference/hg19": true, "rnaseq/run1": true})
+
+	all, err = admin.GetAll()
+	c.Assert(err, IsNil)
+	c.Assert(len(all), Equals, 5)
+}
+
+func (s *TagSvcSuite) TestGCRequiresWriteAll(c *C) {
+	tempfp, _ := ioutil.TempFile("", "tagsvc_test")
+	s.tempfile = tempfp.Name()
+
+	acl := NewACL()
+	acl.Add(&Identity{Name: "reader", Secret: "s1", Read: []string{ALL_LABELS}})
+	acl.Add(&Identity{Name: "rnaseq", Secret: "s2", Write: []string{"rnaseq/"}})
+
+	config := &Config{MasterPort: 0, PersistPath: s.tempfile}
+	l, err := startServer(config, acl)
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	for _, name := range []string{"reader", "rnaseq"} {
+		client, err := Dial(l.Addr().String(), name, []byte(acl.Get(name).Secret), nil)
+		c.Assert(err, IsNil)
+		err = client.GC()
+		c.Assert(err, NotNil)
+		c.Assert(err.Error(), Equals, PERMISSION_DENIED.Error())
+	}
+}
+
+func (s *TagSvcSuite) TestGCLeavesEncryptedChunks(c *C) {
+	tempfp, _ := ioutil.TempFile("", "tagsvc_test")
+	s.tempfile = tempfp.Name()
+
+	// the bucket fails the test if GC sends it anything
+	var requests int32
+	bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "unexpected request", http.StatusInternalServerError)
+	}))
+	defer bucket.Close()
+
+	config := &Config{MasterPort: 0, PersistPath: s.tempfile, AuthSecret: "x", Endpoint: bucket.URL, PathStyle: true,
+		Bucket: "bucket", EncryptedChunks: true}
+	l, err := StartServer(config)
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	client, err := Dial(l.Addr().String(), "", []byte("x"), nil)
+	c.Assert(err, IsNil)
+	c.Assert(client.Set("label", &v2.Key{1}), IsNil)
+	err = client.GC()
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Equals, GC_ENCRYPTED.Error())
+	c.Assert(atomic.LoadInt32(&requests), Equals, int32(0))
+
+	vconfig, err := client.GetConfig()
+	c.Assert(err, IsNil)
+	c.Assert(vconfig.EncryptedChunks, Equals, true)
+}
+
+func (s *TagSvcSuite) TestPresignedAccess(c *C) {
+	tempfp, _ := ioutil.TempFile("", "tagsvc_test")
+	s.tempfile = tempfp.Name()
+
+	acl := NewACL()
+	acl.Add(&Identity{Name: "reader", Secret: "s1", Read: []string{ALL_LABELS}})
+
+	config := &Config{AccessKeyId: "access", SecretAccessKey: "secret", MasterPort: 0, PersistPath: s.tempfile, PresignedAccess: true}
+	l, err := startServer(config, acl)
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	client, err := Dial(l.Addr().String(), "reader", []byte("s1"), nil)
+	c.Assert(err, IsNil)
+
+	vconfig, err := client.GetConfig()
+	c.Assert(err, IsNil)
+	c.Assert(vconfig.AccessKeyId, Equals, "")
+	c.Assert(vconfig.SecretAccessKey, Equals, "")
+
+	_, err = client.SignPut(&v2.Key{1})
+	c.Assert(err.Error(), Equals, PERMISSION_DENIED.Error())
+}
+
+func (s *TagSvcSuite) TestListAndDelete(c *C) {
+	tempfp, _ := ioutil.TempFile("", "tagsvc_test")
+	s.tempfile = tempfp.Name()
+
+	config := &Config{MasterPort: 0, PersistPath: s.tempfile, AuthSecret: "x"}
+	l, err := StartServer(config)
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	client, err := Dial(l.Addr().String(), "", []byte("x"), nil)
+	c.Assert(err, IsNil)
+
+	key1 := v2.Key{1}
+	for _, label := range []string{"b/3", "a/1", "b/1", "b/2", "c"} {
+		c.Assert(client.Set(label, &key1), IsNil)
+	}
+	c.Assert(client.AddLease(100, &key1), IsNil)
+
+	page, err := client.List("b/", "", 2)
+	c.Assert(err, IsNil)
+	c.Assert(len(page), Equals, 2)
+	c.Assert(page[0].Name, Equals, "b/1")
+	c.Assert(page[1].Name, Equals, "b/2")
+
+	page, err = client.List("b/", "b/2", 2)
+	c.Assert(err, IsNil)
+	c.Assert(len(page), Equals, 1)
+	c.Assert(page[0].Name, Equals, "b/3")
+
+	oldPageSize := LIST_PAGE_SIZE
+	LIST_PAGE_SIZE = 2
+	defer func() { LIST_PAGE_SIZE = oldPageSize }()
+
+	tagSvc := NewTagService(client)
+	names := make([]string, 0)
+	err = tagSvc.ForEach("", func(name string, key *v2.Key) {
+		names = append(names, name)
+	})
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"a/1", "b/1", "b/2", "b/3", "c"})
+
+	c.Assert(tagSvc.Delete("b/2"), IsNil)
+	c.Assert(tagSvc.Delete("b/2"), Equals, v2.NO_SUCH_TAG)
+	k, _ := tagSvc.Get("b/2")
+	c.Assert(k, IsNil)
+}
+
+func (s *TagSvcSuite) TestWatch(c *C) {
+	tempfp, _ := ioutil.TempFile("", "tagsvc_test")
+	s.tempfile = tempfp.Name()
+
+	config := &Config{MasterPort: 0, PersistPath: s.tempfile, AuthSecret: "x"}
+	l, err := StartServer(config)
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	client, err := Dial(l.Addr().String(), "", []byte("x"), nil)
+	c.Assert(err, IsNil)
+
+	key1 := v2.Key{1}
+	key2 := v2.Key{2}
+	c.Assert(client.Set("a/1", &key1), IsNil)
+	c.Assert(client.Set("b/1", &key1), IsNil)
+
+	changes, seq, err := client.Watch("a/", 0, 0)
+	c.Assert(err, IsNil)
+	c.Assert(len(changes), Equals, 1)
+	c.Assert(changes[0].Name, Equals, "a/1")
+
+	// nothing has changed since seq, so this times out with no changes
+	changes, seq2, err := client.Watch("a/", seq, 0)
+	c.Assert(err, IsNil)
+	c.Assert(len(changes), Equals, 0)
+	c.Assert(seq2, Equals, seq)
+
+	// changes to other prefixes do not wake the watcher
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		client.Set("b/1", &key2)
+		time.Sleep(50 * time.Millisecond)
+		client.Delete("a/1")
+	}()
+	changes, seq, err = client.Watch("a/", seq, 10*time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(len(changes), Equals, 1)
+	c.Assert(changes[0].Name, Equals, "a/1")
+	c.Assert(changes[0].Key, IsNil)
+	c.Assert(changes[0].Seq, Equals, seq)
+
+	// once history has been trimmed, watchers which fell behind get the current state
+	oldHistory := WATCH_HISTORY
+	WATCH_HISTORY = 1
+	defer func() { WATCH_HISTORY = oldHistory }()
+
+	c.Assert(client.Set("a/2", &key1), IsNil)
+	c.Assert(client.Set("a/3", &key2), IsNil)
+	changes, _, err = client.Watch("a/", 0, 0)
+	c.Assert(err, IsNil)
+	c.Assert(len(changes), Equals, 2)
+	c.Assert(changes[0].Name, Equals, "a/2")
+	c.Assert(changes[1].Name, Equals, "a/3")
+}
+
+func (s *TagSvcSuite) TestSetMany(c *C) {
+	tempfp, _ := ioutil.TempFile("", "tagsvc_test")
+	s.tempfile = tempfp.Name()
+
+	config := &Config{MasterPort: 0, PersistPath: s.tempfile, AuthSecret: "x"}
+	l, err := StartServer(config)
+	c.Assert(err, IsNil)
+
+	client, err := Dial(l.Addr().String(), "", []byte("x"), nil)
+	c.Assert(err, IsNil)
+
+	key1 := v2.Key{1}
+	key2 := v2.Key{2}
+	c.Assert(client.Set("out/a", &key1), IsNil)
+
+	_, seq, err := client.Watch("out/", 0, 0)
+	c.Assert(err, IsNil)
+
+	// a stale expectation leaves every label untouched
+	err = client.SetMany([]SetArgs{{"out/a", &key2}, {"out/b", &key2}}, map[string]*v2.Key{"out/a": &key2})
+	c.Assert(err, Equals, LABEL_CHANGED)
+	err = client.SetMany([]SetArgs{{"out/a", &key2}, {"out/b", &key2}}, map[string]*v2.Key{"out/b": &key1})
+	c.Assert(err, Equals, LABEL_CHANGED)
+	k, _ := client.Get("out/b")
+	c.Assert(k, IsNil)
+
+	err = client.SetMany([]SetArgs{{"out/a", &key2}, {"out/b", &key2}}, map[string]*v2.Key{"out/a": &key1, "out/b": nil})
+	c.Assert(err, IsNil)
+
+	// both changes share a single log entry
+	changes, seq2, err := client.Watch("out/", seq, 0)
+	c.Assert(err, IsNil)
+	c.Assert(len(changes), Equals, 2)
+	c.Assert(seq2, Equals, seq+1)
+	c.Assert(changes[0].Seq, Equals, changes[1].Seq)
+
+	tagSvc := NewTagService(client)
+	c.Assert(tagSvc.CompareAndPut("out/a", &key1, &key1), Equals, v2.TAG_CHANGED)
+	c.Assert(tagSvc.CompareAndPut("out/a", &key2, &key2), IsNil)
+
+	l.Close()
+
+	// and are replayed together
+	roots := NewRoots(s.tempfile)
+	c.Assert(roots.Get("out/a"), DeepEquals, &key2)
+	c.Assert(roots.Get("out/b"), DeepEquals, &key2)
+}
+
+func (s *TagSvcSuite) TestPeers(c *C) {
+	tempfp, _ := ioutil.TempFile("", "tagsvc_test")
+	s.tempfile = tempfp.Name()
+
+	config := &Config{MasterPort: 0, PersistPath: s.tempfile, AuthSecret: "x"}
+	l, err := StartServer(config)
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	client1, err := Dial(l.Addr().String(), "", []byte("x"), nil)
+	c.Assert(err, IsNil)
+	client2, err := Dial(l.Addr().String(), "", []byte("x"), nil)
+	c.Assert(err, IsNil)
+
+	key1 := v2.Key{1}
+	key2 := v2.Key{2}
+	c.Assert(client1.AdvertiseChunks("peer1:8000", []*v2.Key{&key1, &key2}), IsNil)
+	c.Assert(client2.AdvertiseChunks("peer2:8000", []*v2.Key{&key1}), IsNil)
+
+	peers, err := client2.FindPeers(&key1)
+	c.Assert(err, IsNil)
+	c.Assert(peers, DeepEquals, []string{"peer1:8000", "peer2:8000"})
+	peers, err = client2.FindPeers(&key2)
+	c.Assert(err, IsNil)
+	c.Assert(peers, DeepEquals, []string{"peer1:8000"})
+	peers, err = client2.FindPeers(&v2.Key{3})
+	c.Assert(err, IsNil)
+	c.Assert(len(peers), Equals, 0)
+}
+
+func (s *TagSvcSuite) TestPeerPermissions(c *C) {
+	tempfp, _ := ioutil.TempFile("", "tagsvc_test")
+	s.tempfile = tempfp.Name()
+
+	acl := NewACL()
+	acl.Add(&Identity{Name: "writer", Secret: "s1", Write: []string{"rnaseq/"}})
+	acl.Add(&Identity{Name: "reader", Secret: "s2", Read: []string{ALL_LABELS}})
+	acl.Add(&Identity{Name: "nobody", Secret: "s3"})
+
+	config := &Config{MasterPort: 0, PersistPath: s.tempfile}
+	l, err := startServer(config, acl)
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	writer, err := Dial(l.Addr().String(), "writer", []byte("s1"), nil)
+	c.Assert(err, IsNil)
+	reader, err := Dial(l.Addr().String(), "reader", []byte("s2"), nil)
+	c.Assert(err, IsNil)
+	nobody, err := Dial(l.Addr().String(), "nobody", []byte("s3"), nil)
+	c.Assert(err, IsNil)
+
+	key := v2.Key{1}
+	c.Assert(writer.AdvertiseChunks("writer:8000", []*v2.Key{&key}), IsNil)
+	c.Assert(reader.AdvertiseChunks("reader:8000", []*v2.Key{&key}).Error(), Equals, PERMISSION_DENIED.Error())
+
+	peers, err := reader.FindPeers(&key)
+	c.Assert(err, IsNil)
+	c.Assert(peers, DeepEquals, []string{"writer:8000"})
+	_, err = nobody.FindPeers(&key)
+	c.Assert(err.Error(), Equals, PERMISSION_DENIED.Error())
+}
+
+func (s *TagSvcSuite) TestPeerExpiry(c *C) {
+	registry := NewPeerRegistry()
+	key := v2.Key{1}
+	start := time.Now()
+
+	registry.Advertise("peer1:8000", []*v2.Key{&key}, start)
+	registry.Advertise("peer2:8000", []*v2.Key{&key}, start.Add(PEER_TTL/2))
+	c.Assert(registry.Find(&key, start.Add(PEER_TTL/2)), DeepEquals, []string{"peer1:8000", "peer2:8000"})
+
+	// peer1 has not been heard from within the TTL
+	c.Assert(registry.Find(&key, start.Add(PEER_TTL)), DeepEquals, []string{"peer2:8000"})
+
+	// a heartbeat with no keys keeps peer2's chunks alive
+	registry.Advertise("peer2:8000", []*v2.Key{}, start.Add(PEER_TTL))
+	c.Assert(registry.Find(&key, start.Add(PEER_TTL*3/2)), DeepEquals, []string{"peer2:8000"})
+}
@@ -0,0 +1,401 @@
+package v2
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Iterates over the keys of each service in turn, skipping keys which were already returned by an
+// earlier one.
+type unionKeyIterator struct {
+	services []IterableChunkService
+	current  KeyIterator
+	seen     map[Key]bool
+	next     *Key
+}
+
+func newUnionKeyIterator(services []IterableChunkService) *unionKeyIterator {
+	it := &unionKeyIterator{services: services, seen: make(map[Key]bool)}
+	it.advance()
+	return it
+}
+
+func (it *unionKeyIterator) advance() {
+	it.next = nil
+	for {
+		for it.current == nil || !it.current.HasNext() {
+			if len(it.services) == 0 {
+				return
+			}
+			it.current = it.services[0].Iterate()
+			it.services = it.services[1:]
+		}
+
+		key := it.current.Next()
+		if !it.seen[*key] {
+			it.seen[*key] = true
+			it.next = key
+			return
+		}
+	}
+}
+
+func (it *unionKeyIterator) HasNext() bool {
+	return it.next != nil
+}
+
+func (it *unionKeyIterator) Next() *Key {
+	key := it.next
+	it.advance()
+	return key
+}
+
+// deletes the key from each service which supports deletion
+func deleteFromAll(services []IterableChunkService, key *Key) {
+	for _, service := range services {
+		if deleter, ok := service.(chunkDeleter); ok {
+			deleter.Delete(key)
+		}
+	}
+}
+
+// reports a key as present if any of the services has it
+func hasManyInAny(services []IterableChunkService, keys []*Key) ([]bool, error) {
+	found := make([]bool, len(keys))
+	for _, service := range services {
+		inService, err := hasManyOrNone(service, keys)
+		if err != nil {
+			return nil, err
+		}
+		for i := range found {
+			found[i] = found[i] || inService[i]
+		}
+	}
+	return found, nil
+}
+
+// flushes each service which buffers chunks
+func flushAll(services []IterableChunkService) error {
+	for _, service := range services {
+		if flushable, ok := service.(FlushableChunkService); ok {
+			err := flushable.Flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// A read-through stack of chunk services, fastest first.  Get tries each tier in turn until one has the
+// chunk, and Put writes to the first tier only.  If Promote is set, a chunk found in a later tier is also
+// copied into every tier before it, so the next read is served by the fastest.
+type TieredChunkService struct {
+	tiers   []IterableChunkService
+	Promote bool
+}
+
+func NewTieredChunkService(tiers ...IterableChunkService) *TieredChunkService {
+	return &TieredChunkService{tiers: tiers}
+}
+
+func (t *TieredChunkService) Get(key *Key) (Resource, error) {
+	for i, tier := range t.tiers {
+		resource, err := tier.Get(key)
+		if err == NO_SUCH_CHUNK {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if t.Promote {
+			for _, faster := range t.tiers[:i] {
+				err = faster.Put(key, resource)
+				if err != nil {
+					log.Printf("Could not promote %s: %s", key, err)
+				}
+			}
+		}
+		return resource, nil
+	}
+
+	return nil, NO_SUCH_CHUNK
+}
+
+func (t *TieredChunkService) Put(key *Key, resource Resource) error {
+	return t.tiers[0].Put(key, resource)
+}
+
+func (t *TieredChunkService) Has(key *Key) (bool, error) {
+	found, err := t.HasMany([]*Key{key})
+	if err != nil {
+		return false, err
+	}
+	return found[0], nil
+}
+
+func (t *TieredChunkService) HasMany(keys []*Key) ([]bool, error) {
+	return hasManyInAny(t.tiers, keys)
+}
+
+func (t *TieredChunkService) Iterate() KeyIterator {
+	return newUnionKeyIterator(t.tiers)
+}
+
+func (t *TieredChunkService) Delete(key *Key) {
+	deleteFromAll(t.tiers, key)
+}
+
+func (t *TieredChunkService) Flush() error {
+	return flushAll(t.tiers)
+}
+
+var QUORUM_NOT_REACHED error = NewError(ERR_REMOTE_UNAVAILABLE, "Chunk was not written to enough replicas")
+
+// Writes every chunk to all replicas.  Put returns as soon as Quorum replicas have succeeded, and the
+// writes to the remaining replicas carry on in the background.  Get reads from the first replica which
+// has the chunk.
+type ReplicatedChunkService struct {
+	replicas []IterableChunkService
+	Quorum   int
+}
+
+// Creates a replicated service which requires every replica to succeed.  Set Quorum to accept fewer.
+func NewReplicatedChunkService(replicas ...IterableChunkService) *ReplicatedChunkService {
+	return &ReplicatedChunkService{replicas: replicas, Quorum: len(replicas)}
+}
+
+func (r *ReplicatedChunkService) Put(key *Key, resource Resource) error {
+	results := make(chan error, len(r.replicas))
+	for _, replica := range r.replicas {
+		go func(replica IterableChunkService) {
+			results <- replica.Put(key, resource)
+		}(replica)
+	}
+
+	succeeded := 0
+	failed := 0
+	var lastErr error
+	for range r.replicas {
+		err := <-results
+		if err == nil {
+			succeeded++
+		} else {
+			failed++
+			lastErr = err
+		}
+
+		if succeeded >= r.Quorum {
+			return nil
+		}
+		if len(r.replicas)-failed < r.Quorum {
+			break
+		}
+	}
+
+	log.Printf("Could not write %s: %d of %d replicas failed, last error: %s", key, failed, len(r.replicas), lastErr)
+	return QUORUM_NOT_REACHED
+}
+
+func (r *ReplicatedChunkService) Get(key *Key) (Resource, error) {
+	var lastErr error = NO_SUCH_CHUNK
+	for _, replica := range r.replicas {
+		resource, err := replica.Get(key)
+		if err == nil {
+			return resource, nil
+		}
+		if err != NO_SUCH_CHUNK {
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+func (r *ReplicatedChunkService) Has(key *Key) (bool, error) {
+	found, err := r.HasMany([]*Key{key})
+	if err != nil {
+		return false, err
+	}
+	return found[0], nil
+}
+
+// A chunk is only reported as present if at least Quorum replicas have it, so that skipping the upload of a
+// chunk which exists does not leave it less replicated than Put would have.
+func (r *ReplicatedChunkService) HasMany(keys []*Key) ([]bool, error) {
+	counts := make([]int, len(keys))
+	for _, replica := range r.replicas {
+		inReplica, err := hasManyOrNone(replica, keys)
+		if err != nil {
+			return nil, err
+		}
+		for i := range counts {
+			if inReplica[i] {
+				counts[i]++
+			}
+		}
+	}
+
+	found := make([]bool, len(keys))
+	for i := range found {
+		found[i] = counts[i] >= r.Quorum
+	}
+	return found, nil
+}
+
+func (r *ReplicatedChunkService) Iterate() KeyIterator {
+	return newUnionKeyIterator(r.replicas)
+}
+
+func (r *ReplicatedChunkService) Delete(key *Key) {
+	deleteFromAll(r.replicas, key)
+}
+
+func (r *ReplicatedChunkService) Flush() error {
+	return flushAll(r.replicas)
+}
+
+// number of chunks which can be waiting to be mirrored before Put blocks
+var MIRROR_QUEUE_SIZE = 10000
+
+// number of times a chunk is copied before giving up on it
+var MIRROR_ATTEMPTS = 5
+
+var MIRROR_RETRY_DELAY = 5 * time.Second
+
+// Writes chunks to primary, and copies them to secondary in the background.  Get reads from primary,
+// falling back to secondary for chunks which have been removed from primary (or were only ever written to
+// secondary).  Chunks which could not be copied after MIRROR_ATTEMPTS tries are reported by Failed.
+type MirrorChunkService struct {
+	primary   IterableChunkService
+	secondary IterableChunkService
+
+	queue chan *Key
+
+	lock    sync.Mutex
+	idle    *sync.Cond
+	pending int
+	failed  []*Key
+}
+
+func NewMirrorChunkService(primary IterableChunkService, secondary IterableChunkService, workers int) *MirrorChunkService {
+	m := &MirrorChunkService{primary: primary, secondary: secondary, queue: make(chan *Key, MIRROR_QUEUE_SIZE), failed: make([]*Key, 0)}
+	m.idle = sync.NewCond(&m.lock)
+	for i := 0; i < workers; i++ {
+		go m.mirrorLoop()
+	}
+	return m
+}
+
+func (m *MirrorChunkService) mirrorLoop() {
+	for key := range m.queue {
+		var err error
+		for attempt := 0; attempt < MIRROR_ATTEMPTS; attempt++ {
+			if attempt > 0 {
+				time.Sleep(MIRROR_RETRY_DELAY)
+			}
+			err = m.copyToSecondary(key)
+			if err == nil {
+				break
+			}
+		}
+
+		m.lock.Lock()
+		if err != nil {
+			log.Printf("Could not mirror %s: %s", key, err)
+			m.failed = append(m.failed, key)
+		}
+		m.pending--
+		if m.pending == 0 {
+			m.idle.Broadcast()
+		}
+		m.lock.Unlock()
+	}
+}
+
+func (m *MirrorChunkService) copyToSecondary(key *Key) error {
+	resource, err := m.primary.Get(key)
+	if err != nil {
+		return err
+	}
+	return m.secondary.Put(key, resource)
+}
+
+func (m *MirrorChunkService) Put(key *Key, resource Resource) error {
+	err := m.primary.Put(key, resource)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	m.pending++
+	m.lock.Unlock()
+
+	m.queue <- key
+	return nil
+}
+
+func (m *MirrorChunkService) Get(key *Key) (Resource, error) {
+	resource, err := m.primary.Get(key)
+	if err == NO_SUCH_CHUNK {
+		return m.secondary.Get(key)
+	}
+	return resource, err
+}
+
+func (m *MirrorChunkService) Has(key *Key) (bool, error) {
+	found, err := m.HasMany([]*Key{key})
+	if err != nil {
+		return false, err
+	}
+	return found[0], nil
+}
+
+func (m *MirrorChunkService) HasMany(keys []*Key) ([]bool, error) {
+	return hasManyInAny([]IterableChunkService{m.primary, m.secondary}, keys)
+}
+
+func (m *MirrorChunkService) Iterate() KeyIterator {
+	return newUnionKeyIterator([]IterableChunkService{m.primary, m.secondary})
+}
+
+func (m *MirrorChunkService) Delete(key *Key) {
+	deleteFromAll([]IterableChunkService{m.primary, m.secondary}, key)
+}
+
+// Chunks written to the primary are already readable, so this only flushes the primary if it buffers
+// chunks.  Use Wait to block until the mirror has caught up.
+func (m *MirrorChunkService) Flush() error {
+	return flushAll([]IterableChunkService{m.primary})
+}
+
+// Blocks until every chunk which has been Put has either been copied to secondary or given up on.
+func (m *MirrorChunkService) Wait() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for m.pending > 0 {
+		m.idle.Wait()
+	}
+}
+
+// The number of chunks waiting to be copied to secondary
+func (m *MirrorChunkService) Pending() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.pending
+}
+
+// The chunks which could not be copied to secondary
+func (m *MirrorChunkService) Failed() []*Key {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return append([]*Key{}, m.failed...)
+}
+
+// Stops the background copying once the queue is empty.  Put must not be called afterwards.
+func (m *MirrorChunkService) Close() {
+	close(m.queue)
+}
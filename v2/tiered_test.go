@@ -0,0 +1,110 @@
+package v2
+
+import (
+	"errors"
+
+	. "gopkg.in/check.v1"
+)
+
+type TieredSuite struct{}
+
+var _ = Suite(&TieredSuite{})
+
+var REPLICA_DOWN = errors.New("Replica is down")
+
+// a replica which rejects every write
+type failingChunkService struct {
+	*MemChunkService
+}
+
+func (s *failingChunkService) Put(key *Key, resource Resource) error {
+	return REPLICA_DOWN
+}
+
+func iteratedKeys(it KeyIterator) map[Key]bool {
+	keys := make(map[Key]bool)
+	for it.HasNext() {
+		keys[*it.Next()] = true
+	}
+	return keys
+}
+
+func (*TieredSuite) TestTiered(c *C) {
+	fast := NewMemChunkService()
+	slow := NewMemChunkService()
+	tiered := NewTieredChunkService(fast, slow)
+
+	a := putChunk(c, slow, "a")
+	b := putChunk(c, tiered, "b")
+	c.Assert(len(fast.chunks), Equals, 1)
+	c.Assert(len(slow.chunks), Equals, 1)
+
+	resource, err := tiered.Get(a)
+	c.Assert(err, IsNil)
+	c.Assert(string(resource.AsBytes()), Equals, "a")
+	c.Assert(len(fast.chunks), Equals, 1)
+
+	tiered.Promote = true
+	tiered.Get(a)
+	c.Assert(fast.chunks[*a], NotNil)
+
+	_, err = tiered.Get(computeContentKey([]byte("missing")))
+	c.Assert(err, Equals, NO_SUCH_CHUNK)
+
+	keys := iteratedKeys(tiered.Iterate())
+	c.Assert(keys, DeepEquals, map[Key]bool{*a: true, *b: true})
+}
+
+func (*TieredSuite) TestReplicatedQuorum(c *C) {
+	r1 := NewMemChunkService()
+	r2 := NewMemChunkService()
+	down := &failingChunkService{NewMemChunkService()}
+
+	replicated := NewReplicatedChunkService(r1, r2, down)
+	data := []byte("a")
+	key := computeContentKey(data)
+	c.Assert(replicated.Put(key, NewMemResource(data)), Equals, QUORUM_NOT_REACHED)
+
+	replicated.Quorum = 2
+	c.Assert(replicated.Put(key, NewMemResource(data)), IsNil)
+
+	resource, err := replicated.Get(key)
+	c.Assert(err, IsNil)
+	c.Assert(string(resource.AsBytes()), Equals, "a")
+	c.Assert(iteratedKeys(replicated.Iterate()), DeepEquals, map[Key]bool{*key: true})
+}
+
+func (*TieredSuite) TestMirror(c *C) {
+	primary := NewMemChunkService()
+	secondary := NewMemChunkService()
+	mirror := NewMirrorChunkService(primary, secondary, 2)
+	defer mirror.Close()
+
+	keys := make(map[Key]bool)
+	for _, content := range []string{"a", "b", "c"} {
+		keys[*putChunk(c, mirror, content)] = true
+	}
+	mirror.Wait()
+
+	c.Assert(mirror.Pending(), Equals, 0)
+	c.Assert(len(mirror.Failed()), Equals, 0)
+	c.Assert(iteratedKeys(secondary.Iterate()), DeepEquals, keys)
+
+	// falls back to the mirror for chunks no longer in the primary
+	only := putChunk(c, secondary, "only in secondary")
+	resource, err := mirror.Get(only)
+	c.Assert(err, IsNil)
+	c.Assert(string(resource.AsBytes()), Equals, "only in secondary")
+}
+
+func (*TieredSuite) TestMirrorFailures(c *C) {
+	oldDelay := MIRROR_RETRY_DELAY
+	MIRROR_RETRY_DELAY = 0
+	defer func() { MIRROR_RETRY_DELAY = oldDelay }()
+	mirror := NewMirrorChunkService(NewMemChunkService(), &failingChunkService{NewMemChunkService()}, 1)
+	defer mirror.Close()
+
+	key := putChunk(c, mirror, "a")
+	mirror.Wait()
+	c.Assert(mirror.Failed(), DeepEquals, []*Key{key})
+}
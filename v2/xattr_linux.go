@@ -0,0 +1,66 @@
+package v2
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"syscall"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func readUserXattrs(path string) ([]*Xattr, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err == syscall.ENOTSUP {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	buffer := make([]byte, size)
+	size, err = syscall.Listxattr(path, buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := make([]*Xattr, 0)
+	for _, name := range bytes.Split(buffer[:size], []byte{0}) {
+		if !strings.HasPrefix(string(name), USER_XATTR_PREFIX) {
+			continue
+		}
+
+		valueSize, err := syscall.Getxattr(path, string(name), nil)
+		if err != nil {
+			return nil, err
+		}
+		if valueSize > MAX_XATTR_SIZE {
+			log.Printf("Not recording xattr %s of %s because it is %d bytes", name, path, valueSize)
+			continue
+		}
+
+		value := make([]byte, valueSize)
+		valueSize, err = syscall.Getxattr(path, string(name), value)
+		if err != nil {
+			return nil, err
+		}
+		xattrs = append(xattrs, &Xattr{Name: proto.String(string(name)), Value: value[:valueSize]})
+	}
+
+	return xattrs, nil
+}
+
+func writeUserXattrs(path string, xattrs []*Xattr) error {
+	for _, xattr := range xattrs {
+		err := syscall.Setxattr(path, xattr.GetName(), xattr.GetValue(), 0)
+		if err == syscall.ENOTSUP {
+			log.Printf("Not restoring xattrs of %s because the filesystem does not support them", path)
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package v2
+
+// xattrs are only recorded and restored on linux
+func readUserXattrs(path string) ([]*Xattr, error) {
+	return nil, nil
+}
+
+func writeUserXattrs(path string, xattrs []*Xattr) error {
+	return nil
+}